@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/logging"
+)
+
+// errReusePortUnsupported is returned by reusePortControl where the
+// platform or kernel lacks SO_REUSEPORT.
+var errReusePortUnsupported = errors.New("SO_REUSEPORT isn't supported")
+
+// listen opens addr, a TCP address or "unix:" and a socket path. A TCP
+// listener gets SO_REUSEPORT if reusePort is set and the platform has it.
+// A socket file gets mode as its permissions, and is removed when the
+// listener closes, as on shutdown.
+func listen(addr string, mode fs.FileMode, reusePort bool) (net.Listener, error) {
+	path, ok := config.UnixSocket(addr)
+	if !ok {
+		if reusePort {
+			lc := net.ListenConfig{Control: reusePortControl}
+			ln, err := lc.Listen(context.Background(), "tcp", addr)
+			if !errors.Is(err, errReusePortUnsupported) {
+				return ln, err
+			}
+			logging.Warnf("listen %s: %v; listening without it", addr, err)
+		}
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is created under the umask; tighten or widen it before
+	// anything is served.
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	return ln, nil
+}
+
+// removeStaleSocket deletes the socket file a crashed gateway left at
+// path. A socket something still answers on, or a file that isn't a
+// socket, is left alone and reported.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: another process is already listening", path)
+	}
+	return os.Remove(path)
+}
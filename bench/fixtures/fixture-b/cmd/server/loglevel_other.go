@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// notifyLogLevel does nothing where there are no SIGUSR1 and SIGUSR2; the
+// admin API still sets the level.
+func notifyLogLevel() {}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"api-gateway/internal/logging"
+)
+
+// notifyLogLevel makes SIGUSR1 log one level more verbosely, down to
+// debug, and SIGUSR2 one level less, up to error.
+func notifyLogLevel() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			delta := -1
+			if sig == syscall.SIGUSR2 {
+				delta = 1
+			}
+			log.Printf("%v: log level now %s", sig, logging.Step(delta))
+		}
+	}()
+}
@@ -1,31 +1,502 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"api-gateway/internal/auth"
+	"api-gateway/internal/config"
+	"api-gateway/internal/connlimit"
 	"api-gateway/internal/handler"
+	"api-gateway/internal/health"
+	"api-gateway/internal/lifecycle"
+	"api-gateway/internal/logging"
 	"api-gateway/internal/middleware"
+	"api-gateway/internal/redis"
+	"api-gateway/internal/tracing"
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("config: %v", err)
 	}
+	logging.SetLevel(cfg.Level())
+	notifyLogLevel()
 
-	jwtValidator := auth.NewValidator(os.Getenv("JWT_SECRET"))
-	mux := http.NewServeMux()
-	handler.RegisterRoutes(mux)
+	// Background components register their cleanup as they start; it runs
+	// in reverse once the server has drained.
+	var shutdown lifecycle.Shutdown
 
-	chain := middleware.Chain(
-		middleware.Logger,
-		middleware.CORS,
-		jwtValidator.Middleware,
-	)
+	rules := cfg.Routes
+	checker := health.NewChecker(handler.HealthTargets(rules))
+	routerOpts := []handler.RouterOption{handler.WithHealthChecker(checker)}
+	if cfg.Debug.DumpBodies {
+		logging.Warnf("WARNING: dumping request and response bodies for routes with dump_body set")
+		routerOpts = append(routerOpts, handler.WithBodyDump(middleware.NewDumpBody(middleware.DumpConfig{
+			MaxBytes:     cfg.Debug.DumpMaxBytes,
+			RedactFields: cfg.Debug.RedactFields,
+		})))
+	}
+	if len(cfg.Auth.ClaimHeaders) > 0 {
+		routerOpts = append(routerOpts, handler.WithUpstreamClaims(cfg.Auth.ClaimHeaders))
+	}
+	router, err := handler.NewRouter(rules, routerOpts...)
+	if err != nil {
+		log.Fatalf("routes: %v", err)
+	}
+
+	var audit *auth.AuditLog
+	if dst := cfg.Auth.AuditLog; dst != "" {
+		out, err := openLog(dst)
+		if err != nil {
+			log.Fatalf("audit log: %v", err)
+		}
+		audit = auth.NewAuditLog(auth.AuditConfig{
+			Out:       out,
+			Allows:    cfg.Auth.AuditAllows,
+			RequestID: middleware.RequestIDFromContext,
+			ClientIP:  middleware.RemoteIP,
+			Route:     router.RouteTemplate,
+		})
+	}
+	jwtValidator := auth.NewValidator(cfg.Auth.JWTSecret,
+		auth.WithFallbackSecrets(cfg.Auth.JWTFallbackSecrets...), auth.WithAudit(audit))
+	authenticators := []auth.Authenticator{jwtValidator}
+	if path := cfg.Auth.APIKeysFile; path != "" {
+		keys, err := auth.LoadAPIKeys(path)
+		if err != nil {
+			log.Fatalf("api keys: %v", err)
+		}
+		authenticators = append(authenticators, auth.NewAPIKeyValidator(keys))
+	}
+	var sessions *auth.Sessions
+	if sc := cfg.Auth.Session; sc.Enabled() {
+		opts := []auth.SessionOption{}
+		if sc.CookieName != "" {
+			opts = append(opts, auth.SessionCookieName(sc.CookieName))
+		}
+		if sc.TTL > 0 {
+			opts = append(opts, auth.SessionTTL(time.Duration(sc.TTL)))
+		}
+		if sc.Insecure {
+			logging.Warnf("WARNING: session cookies are sent without the Secure attribute")
+			opts = append(opts, auth.InsecureSessionCookie())
+		}
+		sessions = auth.NewSessions(sc.Secret, opts...)
+		authenticators = append(authenticators, sessions)
+	}
+	authenticate := jwtValidator.Middleware
+	if len(authenticators) > 1 {
+		authenticate = auth.AnyOf(authenticators...)
+		if audit != nil {
+			authenticate = audit.AnyOf(authenticators...)
+		}
+	}
+	if cfg.Auth.Disabled {
+		logging.Warnf("WARNING: authentication is disabled; every request reaches its upstream unauthenticated")
+		authenticate = func(next http.Handler) http.Handler { return next }
+	}
+	if cfg.Debug.ServerTiming {
+		authenticate = middleware.Timed("auth", authenticate)
+	}
+
+	rl := cfg.RateLimit
+	rateStore := middleware.NewMemoryRateStore(rl.RPS, rl.Burst)
+	if rl.RedisURL != "" {
+		client, err := redis.NewClient(rl.RedisURL, 100*time.Millisecond)
+		if err != nil {
+			log.Fatalf("rate limit: %v", err)
+		}
+		shutdown.RegisterShutdownHook("redis", func(context.Context) error { return client.Close() })
+		rateStore = redis.NewRateStore(client, rl.Burst, rl.Window(), "gateway:ratelimit:")
+		logging.Infof("rate limiting through Redis: %d requests per %v per client", rl.Burst, rl.Window())
+	}
+	var tracerProvider tracing.TracerProvider
+	var traceExporter *tracing.Provider
+	if endpoint := cfg.Tracing.OTLPEndpoint; endpoint != "" {
+		traceExporter = tracing.NewProvider(tracing.ProviderConfig{
+			Exporter:    tracing.NewOTLPExporter(endpoint, cfg.Tracing.ServiceName),
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		tracerProvider = traceExporter
+		// Flushes the spans still queued.
+		shutdown.RegisterShutdownHook("tracing", traceExporter.Shutdown)
+		logging.Infof("exporting traces to %s", endpoint)
+	}
+	mux := handler.NewMux()
+
+	// Operational endpoints get only the global stack: no CORS, no auth.
+	handler.RegisterMetrics(mux.ServeMux)
+	readiness := handler.RegisterHealth(mux.ServeMux)
+	handler.RegisterUpstreamHealth(mux.ServeMux, checker)
+	checkNames := addRouteChecks(readiness, checker, rules, nil)
+	if sessions != nil {
+		// Browsers log in here with their JWT and get a session cookie.
+		handler.RegisterSessions(mux.ServeMux, sessions, jwtValidator)
+	}
+
+	// Everything else goes to the proxied routes behind the full API stack.
+	// Each CORS policy mounts the routes again under its prefix, so the mux
+	// picks the policy with the longest matching prefix.
+	// Warmup holds proxied requests back from upstreams that aren't up yet
+	// after a cold start; the operational endpoints above stay reachable.
+	warmup := middleware.NewWarmup(middleware.WarmupConfig{
+		Duration:     time.Duration(cfg.Warmup.Duration),
+		Ready:        readiness.Healthy,
+		PathPrefixes: cfg.Warmup.PathPrefixes,
+	})
+	// The in-flight limit is shared by every group, and leaves the
+	// operational endpoints out so probes still answer under load.
+	maxInFlight := middleware.MaxInFlight(cfg.MaxInFlight)
+	// Routes with a timeout of their own override REQUEST_TIMEOUT.
+	requestTimeout := middleware.TimeoutFunc(func(r *http.Request) time.Duration {
+		if d := router.Timeout(r); d > 0 {
+			return d
+		}
+		return time.Duration(cfg.Timeouts.Request)
+	})
+	var tierLimit middleware.LimitFunc
+	if len(rl.Tiers) > 0 {
+		tierLimit = middleware.ClaimLimit(rl.TierClaim, rl.Tiers)
+	}
+	rateLimit := middleware.NewRateLimit(middleware.RateLimitConfig{
+		Store:    rateStore,
+		Limit:    tierLimit,
+		FailOpen: cfg.RateLimit.FailOpen,
+	})
+	accessLog := middleware.Logger
+	if al := cfg.AccessLog; al.SuccessSampleRate < 1 {
+		accessLog = middleware.NewSampledLogger(middleware.JSONFormat, os.Stderr, middleware.SamplingPolicy{
+			SuccessRate: al.SuccessSampleRate,
+			SlowerThan:  time.Duration(al.SlowThreshold),
+		})
+	}
+
+	// Middleware built from the gateway's own state is registered here so
+	// that config can place it by name, like the package's built-ins.
+	registry := middleware.DefaultRegistry.Clone()
+	for name, mw := range map[string]middleware.Middleware{
+		"allowed_hosts":    middleware.AllowedHosts(cfg.AllowedHosts),
+		"real_ip":          middleware.RealIP(cfg.TrustedPrefixes()...),
+		"client_cert":      auth.ClientCertificate,
+		"tracing":          middleware.Tracing(tracerProvider),
+		"access_log":       accessLog,
+		"max_header_bytes": middleware.MaxHeaderBytes(cfg.MaxHeaderBytes),
+		"max_uri_length":   middleware.MaxURILength(cfg.MaxURILength),
+		"max_in_flight":    maxInFlight,
+		"request_timeout":  requestTimeout,
+		"warmup":           warmup,
+		"auth":             authenticate,
+		"rate_limit":       rateLimit,
+	} {
+		registry.Register(name, middleware.NoOptions(mw))
+	}
+	apiStack := cfg.Middleware.API
+	if len(apiStack) == 0 {
+		apiStack = specs("max_in_flight", "request_timeout", "max_body_bytes", "cors", "warmup",
+			"gzip", "auth", "rate_limit", "idempotency")
+	}
+	apiGroup := func(prefix string, cors middleware.Middleware) *handler.RouteGroup {
+		// "cors" is the policy of the group being mounted.
+		groupRegistry := registry.Clone()
+		groupRegistry.Register("cors", middleware.NoOptions(cors))
+		stack, err := groupRegistry.Build(apiStack)
+		if err != nil {
+			log.Fatalf("middleware.api: %v", err)
+		}
+		return handler.Group(mux.ServeMux, prefix, stack)
+	}
+	rootCORS := middleware.CORS
+	for _, policy := range cfg.CORS {
+		prefix := strings.TrimSuffix(policy.PathPrefix, "/")
+		if prefix == "" {
+			rootCORS = policy.Middleware()
+			continue
+		}
+		handler.RegisterRoutes(apiGroup(prefix, policy.Middleware()), router)
+	}
+	handler.RegisterRoutes(apiGroup("", rootCORS), router)
+
+	globalStack := cfg.Middleware.Global
+	if len(globalStack) == 0 {
+		// Oversized URIs are refused before anything logs them.
+		globalStack = specs("recover", "max_uri_length")
+		if cfg.Debug.ServerTiming {
+			globalStack = append(globalStack, specs("server_timing")...)
+		}
+		globalStack = append(globalStack, specs("allowed_hosts", "real_ip", "request_id", "client_cert",
+			"tracing", "access_log", "metrics", "max_header_bytes")...)
+	}
+	chain, err := registry.Build(globalStack)
+	if err != nil {
+		log.Fatalf("middleware.global: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: chain(mux),
+		// ReadHeaderTimeout bounds reading the request line and headers, the
+		// window slowloris clients exploit.
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		// ReadTimeout bounds reading the whole request, headers and body.
+		ReadTimeout: time.Duration(cfg.Timeouts.Read),
+		// WriteTimeout runs from the end of the header read to the end of the
+		// response write, so it caps handler time plus response transfer.
+		WriteTimeout: time.Duration(cfg.Timeouts.Write),
+		// IdleTimeout bounds how long a keep-alive connection waits for the
+		// next request.
+		IdleTimeout: time.Duration(cfg.Timeouts.Idle),
+		// MaxHeaderBytes stops the server reading headers far past the
+		// limit; middleware.MaxHeaderBytes refuses the rest with a JSON 431.
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+
+	// The admin API gets a listener of its own, so it is never reachable
+	// through the public address. Reloads update what it reports.
+	var effective atomic.Pointer[config.Config]
+	effective.Store(cfg.Redacted())
+	// One limiter spans every listener, so a client can't get round its
+	// limit by connecting to another of the gateway's addresses.
+	var connLimiter *connlimit.Limiter
+	if cfg.MaxConnsPerIP > 0 {
+		connLimiter = connlimit.New(cfg.MaxConnsPerIP, cfg.TrustedPrefixes())
+	}
+	var admin *http.Server
+	if addr := cfg.Admin.Addr; addr != "" {
+		admin = &http.Server{
+			Addr: addr,
+			Handler: middleware.Chain(middleware.Recover, middleware.Logger)(handler.NewAdmin(handler.AdminConfig{
+				Router:      router,
+				Config:      func() any { return effective.Load() },
+				Connections: connLimiter,
+				Token:       cfg.Admin.Token,
+			})),
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+			ReadTimeout:       server.ReadTimeout,
+			WriteTimeout:      server.WriteTimeout,
+			IdleTimeout:       server.IdleTimeout,
+			MaxHeaderBytes:    server.MaxHeaderBytes,
+		}
+	}
 
-	log.Printf("Starting gateway on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, chain(mux)))
+	useTLS := cfg.TLS.Enabled()
+	var redirect *http.Server
+	if useTLS {
+		server.TLSConfig = tlsConfig()
+		if path := cfg.TLS.ClientCAFile; path != "" {
+			pool, err := loadCertPool(path)
+			if err != nil {
+				log.Fatalf("client CAs: %v", err)
+			}
+			server.TLSConfig.ClientCAs = pool
+			server.TLSConfig.ClientAuth = cfg.TLS.ClientAuthType()
+		}
+		if addr := cfg.TLS.RedirectAddr; addr != "" {
+			_, port, _ := net.SplitHostPort(cfg.Addr)
+			redirect = &http.Server{
+				Addr:              addr,
+				Handler:           redirectToHTTPS(port),
+				ReadHeaderTimeout: server.ReadHeaderTimeout,
+				IdleTimeout:       server.IdleTimeout,
+				MaxHeaderBytes:    server.MaxHeaderBytes,
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	checkCtx, stopChecks := context.WithCancel(context.Background())
+	checksDone := make(chan struct{})
+	go func() {
+		checker.Run(checkCtx)
+		close(checksDone)
+	}()
+	shutdown.RegisterShutdownHook("health checks", func(ctx context.Context) error {
+		stopChecks()
+		select {
+		case <-checksDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	// fail ends the process when a listener can't serve, as on a port
+	// already in use, once the hooks have flushed and closed what they can.
+	fail := func(format string, args ...any) {
+		logging.Errorf(format, args...)
+		hookCtx, cancelHooks := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
+		shutdown.Run(hookCtx)
+		cancelHooks()
+		os.Exit(1)
+	}
+
+	// Every listener serves the same server, so Shutdown drains them all.
+	addrs := append([]string{cfg.Addr}, cfg.Listen...)
+	serveErr := make(chan error, len(addrs)+2)
+	for _, addr := range addrs {
+		ln, err := listen(addr, cfg.SocketMode(), cfg.ReusePort)
+		if err != nil {
+			fail("listen: %v", err)
+		}
+		if connLimiter != nil {
+			ln = connLimiter.Listener(ln)
+		}
+		go func() {
+			if useTLS {
+				logging.Infof("Starting gateway on %s (HTTPS)", addr)
+				serveErr <- server.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+				return
+			}
+			logging.Infof("Starting gateway on %s (HTTP)", addr)
+			serveErr <- server.Serve(ln)
+		}()
+	}
+	if redirect != nil {
+		go func() {
+			logging.Infof("Redirecting HTTP on %s to HTTPS", redirect.Addr)
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+	if admin != nil {
+		go func() {
+			logging.Infof("Serving admin API on %s", admin.Addr)
+			serveErr <- admin.ListenAndServe()
+		}()
+	}
+
+	readiness.SetReady(true)
+
+	// SIGHUP re-reads the configuration and swaps in its routes. Other
+	// settings only take effect on restart.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			next, err := config.Load()
+			if err == nil {
+				err = router.Update(next.Routes)
+			}
+			if err != nil {
+				logging.Errorf("reload rejected, keeping current routes: %v", err)
+				continue
+			}
+			checker.SetTargets(handler.HealthTargets(next.Routes))
+			checkNames = addRouteChecks(readiness, checker, next.Routes, checkNames)
+			reloaded := *cfg
+			reloaded.Routes = next.Routes
+			effective.Store(reloaded.Redacted())
+			logging.Infof("reloaded %d routes", len(next.Routes))
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		fail("serve: %v", err)
+	case <-ctx.Done():
+	}
+	signal.Stop(hup)
+	stop()
+	// Fail readiness first and keep serving for SHUTDOWN_DELAY, so load
+	// balancers see /readyz go 503 and stop routing here before the
+	// listener closes. A second signal during the delay exits immediately.
+	readiness.SetReady(false)
+	if delay := time.Duration(cfg.Timeouts.ShutdownDelay); delay > 0 {
+		logging.Infof("not ready, waiting %v before draining", delay)
+		time.Sleep(delay)
+	}
+
+	grace := time.Duration(cfg.Timeouts.Shutdown)
+	logging.Infof("shutting down, draining for up to %v", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if redirect != nil {
+		redirect.Close()
+	}
+	if admin != nil {
+		admin.Close()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logging.Errorf("drain incomplete, forcing close: %v", err)
+		server.Close()
+		// The hooks still flush and close what they can, in what is left
+		// of the deadline, or a short window of their own if the drain
+		// used it all.
+		deadline, _ := shutdownCtx.Deadline()
+		hookCtx, cancelHooks := context.WithTimeout(context.Background(), max(time.Until(deadline), forcedHookGrace))
+		shutdown.Run(hookCtx)
+		cancelHooks()
+		os.Exit(1)
+	}
+	// The rest of the drain's deadline is left for the hooks.
+	shutdown.Run(shutdownCtx)
+	for range addrs {
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}
+	logging.Infof("shutdown complete")
+}
+
+// forcedHookGrace is the least time the shutdown hooks get after a drain
+// that ran out of time, enough to flush traces or close a connection.
+const forcedHookGrace = 2 * time.Second
+
+// openLog opens the log file at path for appending, creating it if need
+// be; "stdout" and "stderr" name the standard streams.
+func openLog(path string) (*os.File, error) {
+	switch path {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+// addRouteChecks registers a readiness check per route, drops the checks
+// named in prev that no route uses any more, and returns the new names.
+// Routes with active health checks report the checker's view; the rest
+// fall back to a TCP dial.
+func addRouteChecks(readiness *handler.Health, checker *health.Checker, rules []handler.Rule, prev []string) []string {
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		var urls []string
+		for _, up := range rule.Targets() {
+			urls = append(urls, up.URL)
+		}
+		if rule.HealthPath != "" {
+			readiness.AddCheck(rule.Name(), checker.AnyHealthy(urls...))
+		} else {
+			readiness.AddCheck(rule.Name(), handler.UpstreamReachable(urls...))
+		}
+		names = append(names, rule.Name())
+	}
+	for _, name := range prev {
+		if !slices.Contains(names, name) {
+			readiness.RemoveCheck(name)
+		}
+	}
+	return names
+}
+
+// specs lists middleware by name, without options.
+func specs(names ...string) []middleware.Spec {
+	out := make([]middleware.Spec, len(names))
+	for i, name := range names {
+		out[i] = middleware.Spec{Name: name}
+	}
+	return out
 }
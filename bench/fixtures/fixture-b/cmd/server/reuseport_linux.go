@@ -0,0 +1,28 @@
+//go:build !(mips || mipsle || mips64 || mips64le)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package doesn't define.
+// MIPS numbers its socket options differently and isn't covered.
+const soReusePort = 0xf
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); err != nil {
+		return err
+	}
+	// Kernels before 3.9 don't know the option.
+	if errors.Is(sockErr, syscall.ENOPROTOOPT) {
+		return fmt.Errorf("%w: %v", errReusePortUnsupported, sockErr)
+	}
+	return sockErr
+}
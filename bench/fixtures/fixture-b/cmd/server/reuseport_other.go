@@ -0,0 +1,15 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// reusePortControl refuses, as SO_REUSEPORT is only used on Linux, where
+// it spreads connections across the processes sharing a port.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("%w on %s/%s", errReusePortUnsupported, runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,159 @@
+// Package apierr writes the gateway's error responses.
+//
+// Every error the gateway produces itself has the same shape:
+//
+//	{"error": {"code": "not_found", "message": "not found"}}
+//
+// code is a stable, machine-readable identifier clients can switch on;
+// message is human-readable and may change. Clients that prefer plain text,
+// such as browsers, get the same code and message as a line of text
+// instead; see Negotiate.
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error codes used by the gateway.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidHost          = "invalid_host"
+	CodeUnauthorized         = "unauthorized"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeClientCertNotAllowed = "client_cert_not_allowed"
+	CodeIPNotAllowed         = "ip_not_allowed"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeHeaderTooLarge       = "header_too_large"
+	CodeURITooLong           = "uri_too_long"
+	CodeRateLimited          = "rate_limited"
+	CodeRateLimitUnavailable = "rate_limit_unavailable"
+	CodeOverloaded           = "overloaded"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeInternal             = "internal_error"
+	CodeBadGateway           = "bad_gateway"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeWarmingUp            = "warming_up"
+	CodeRequestTimeout       = "request_timeout"
+	CodeGatewayTimeout       = "gateway_timeout"
+)
+
+// Body is the JSON error envelope.
+type Body struct {
+	Error Detail `json:"error"`
+}
+
+// Detail describes one error.
+type Detail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Format is how an error body is serialized.
+type Format int
+
+const (
+	// FormatJSON is the Body envelope as application/json.
+	FormatJSON Format = iota
+	// FormatText is "code: message" as text/plain.
+	FormatText
+)
+
+// Write sends status with an error body in the format r's Accept header
+// prefers, JSON unless it prefers text.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	WriteFormat(w, Negotiate(r.Header.Get("Accept"), FormatJSON), status, code, message)
+}
+
+// WriteFormat sends status with an error body in format f.
+func WriteFormat(w http.ResponseWriter, f Format, status int, code, message string) {
+	h := w.Header()
+	// The same URL can fail in either format, so caches must key on Accept.
+	h.Add("Vary", "Accept")
+	if f == FormatText {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s: %s\n", code, message)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Body{Error: Detail{Code: code, Message: message}})
+}
+
+// Negotiate returns the Format an Accept header value ranks higher, def if
+// it ranks both the same, as "*/*" or an empty header does. Each format
+// takes the q-value of the most specific media range matching it, per RFC
+// 9110: application/json for JSON, and text/plain for text, or text/html,
+// so that browsers asking for pages get text.
+func Negotiate(accept string, def Format) Format {
+	if strings.TrimSpace(accept) == "" {
+		return def
+	}
+	jsonQ, _ := quality(accept, "application", "json")
+	// text/html only speaks for text when named more specifically than
+	// text/plain, so "text/plain;q=0, */*" still refuses text.
+	textQ, plain := quality(accept, "text", "plain")
+	if htmlQ, html := quality(accept, "text", "html"); html > plain {
+		textQ = htmlQ
+	}
+	switch {
+	case jsonQ > textQ:
+		return FormatJSON
+	case textQ > jsonQ:
+		return FormatText
+	}
+	return def
+}
+
+// quality returns the q-value accept gives typ/subtype, that of its most
+// specific matching media range, and how specific that range was: 2 for
+// typ/subtype, 1 for typ/*, 0 for */*, and -1 with q 0 if none matches.
+func quality(accept, typ, subtype string) (float64, int) {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		t, st, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+		if !ok {
+			continue
+		}
+		var s int
+		switch {
+		case t == typ && st == subtype:
+			s = 2
+		case t == typ && st == "*":
+			s = 1
+		case t == "*" && st == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = rangeQuality(params), s
+		}
+	}
+	return q, specificity
+}
+
+// rangeQuality parses the q parameter among a media range's params,
+// defaulting to 1. A malformed q-value counts as 0.
+func rangeQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(name, "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
@@ -0,0 +1,77 @@
+package apierr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusNotFound, CodeNotFound, "no such user")
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"error":{"code":"not_found","message":"no such user"}}` {
+		t.Fatalf("body = %s", got)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	rec := httptest.NewRecorder()
+	Write(rec, req, http.StatusNotFound, CodeNotFound, "no such user")
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if rec.Header().Get("Vary") != "Accept" {
+		t.Fatalf("Vary = %q, want Accept", rec.Header().Get("Vary"))
+	}
+	if got := rec.Body.String(); got != "not_found: no such user\n" {
+		t.Fatalf("body = %q", got)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		def    Format
+		want   Format
+	}{
+		{"", FormatJSON, FormatJSON},
+		{"", FormatText, FormatText},
+		{"*/*", FormatJSON, FormatJSON},
+		{"*/*", FormatText, FormatText},
+		{"application/json", FormatText, FormatJSON},
+		{"text/plain", FormatJSON, FormatText},
+		{"TEXT/Plain; charset=utf-8", FormatJSON, FormatText},
+		{"text/*", FormatJSON, FormatText},
+		{"application/*", FormatText, FormatJSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", FormatJSON, FormatText},
+		{"application/json, text/plain;q=0.5", FormatText, FormatJSON},
+		{"text/plain;q=0.4, application/json;q=0.6", FormatText, FormatJSON},
+		{"text/plain; q=0.9, */*;q=0.1", FormatJSON, FormatText},
+		{"text/plain;q=0.5, application/json;q=0.5", FormatText, FormatText},
+		// The specific range outranks */* even with a lower q.
+		{"*/*, application/json;q=0.2", FormatJSON, FormatText},
+		{"text/plain;q=0, */*", FormatText, FormatJSON},
+		{"text/plain;q=bogus, application/json;q=0.1", FormatText, FormatJSON},
+		{"image/png", FormatJSON, FormatJSON},
+		{"garbage", FormatText, FormatText},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.accept, tt.def); got != tt.want {
+			t.Errorf("Negotiate(%q, %v) = %v, want %v", tt.accept, tt.def, got, tt.want)
+		}
+	}
+}
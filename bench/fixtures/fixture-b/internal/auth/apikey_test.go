@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyValidatorMiddleware(t *testing.T) {
+	v := NewAPIKeyValidator(StaticAPIKeys(map[string]map[string]any{
+		"k-billing": {"sub": "billing", "scope": "services:read"},
+	}))
+	var got map[string]any
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ClaimsFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"known key", "k-billing", http.StatusOK, ""},
+		{"unknown key", "k-other", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"invalid API key"}}`},
+		{"no key", "", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"unauthorized"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+	if got["sub"] != "billing" || !HasScope(got, "services:read") {
+		t.Fatalf("claims = %v", got)
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	keys := NewAPIKeyValidator(StaticAPIKeys(map[string]map[string]any{"k1": {"sub": "machine"}}))
+	h := AnyOf(NewValidator(testSecret), keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		w.Write([]byte(claims["sub"].(string)))
+	}))
+
+	tests := []struct {
+		name       string
+		bearer     string
+		apiKey     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"JWT", hs256Token(t, map[string]any{"sub": "alice"}), "", http.StatusOK, "alice"},
+		{"API key", "", "k1", http.StatusOK, "machine"},
+		{"bad JWT does not fall through", "garbage", "k1", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"malformed token"}}`},
+		{"bad API key", "", "nope", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"invalid API key"}}`},
+		{"neither", "", "", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"unauthorized"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Fatalf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[{"name":"billing","key":"k1","scope":"a b"},{"name":"reports","key":"k2","tier":"pro"}]`)
+	lookup, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, ok := lookup("k1"); !ok || claims["sub"] != "apikey:billing" || !HasScope(claims, "b") || claims["tier"] != nil {
+		t.Fatalf("lookup(k1) = %v, %v", claims, ok)
+	}
+	if claims, ok := lookup("k2"); !ok || claims["tier"] != "pro" {
+		t.Fatalf("lookup(k2) = %v, %v", claims, ok)
+	}
+
+	for _, bad := range []string{`[{"name":"x"}]`, `[{"name":"x","key":"k"},{"name":"y","key":"k"}]`, `{`} {
+		write(bad)
+		if _, err := LoadAPIKeys(path); err == nil {
+			t.Fatalf("LoadAPIKeys(%s) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readAudit(t *testing.T, buf *bytes.Buffer) []AuditEvent {
+	t.Helper()
+	var events []AuditEvent
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e AuditEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	now := time.Now()
+	valid := hs256Token(t, map[string]any{"sub": "user-1", "exp": now.Add(time.Hour).Unix()})
+	expired := hs256Token(t, map[string]any{"sub": "user-2", "exp": now.Add(-time.Hour).Unix()})
+	forged := replaceSegment(hs256Token(t, map[string]any{"sub": "user-3"}), 2, "c2lnbmF0dXJl")
+
+	tests := []struct {
+		name   string
+		token  string
+		allows bool
+		want   *AuditEvent
+	}{
+		{"no token", "", false, &AuditEvent{Decision: "deny", Reason: "no credentials"}},
+		{"expired token keeps subject", expired, false, &AuditEvent{Decision: "deny", Reason: "token expired", Subject: "user-2"}},
+		{"forged token has no subject", forged, false, &AuditEvent{Decision: "deny", Reason: "invalid token signature"}},
+		{"allow not recorded by default", valid, false, nil},
+		{"allow recorded", valid, true, &AuditEvent{Decision: "allow", Subject: "user-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			audit := NewAuditLog(AuditConfig{
+				Out:       &buf,
+				Allows:    tt.allows,
+				RequestID: func(context.Context) string { return "req-1" },
+				Route:     func(*http.Request) string { return "/api/v1/users" },
+			})
+			v := NewValidator(testSecret, WithAudit(audit), Skip("/healthz"))
+			h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+			req.RemoteAddr = "10.0.0.7:5000"
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			events := readAudit(t, &buf)
+			if tt.want == nil {
+				if len(events) != 0 {
+					t.Fatalf("got %+v, want no events", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1: %+v", len(events), events)
+			}
+			e := events[0]
+			if e.Decision != tt.want.Decision || e.Reason != tt.want.Reason || e.Subject != tt.want.Subject {
+				t.Fatalf("event = %+v, want %+v", e, *tt.want)
+			}
+			if e.ClientIP != "10.0.0.7" || e.RequestID != "req-1" || e.Route != "/api/v1/users" ||
+				e.Path != "/api/v1/users/42" || e.Method != http.MethodGet || e.Timestamp == "" {
+				t.Fatalf("event context = %+v", e)
+			}
+		})
+	}
+}
+
+func TestAuditLogAnyOf(t *testing.T) {
+	var buf bytes.Buffer
+	audit := NewAuditLog(AuditConfig{Out: &buf, Allows: true})
+	keys := NewAPIKeyValidator(StaticAPIKeys(map[string]map[string]any{"k-1": {"sub": "billing"}}))
+	h := audit.AnyOf(NewValidator(testSecret), keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(APIKeyHeader, "k-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(APIKeyHeader, "wrong")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	events := readAudit(t, &buf)
+	if len(events) != 2 || events[0].Decision != "allow" || events[0].Subject != "billing" ||
+		events[1].Decision != "deny" || events[1].Reason != ErrInvalidAPIKey.Error() {
+		t.Fatalf("events = %+v", events)
+	}
+}
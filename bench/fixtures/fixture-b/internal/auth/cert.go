@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"api-gateway/internal/apierr"
+)
+
+// ClientCert is the identity in a request's verified TLS client
+// certificate.
+type ClientCert struct {
+	CommonName     string
+	DNSNames       []string
+	URIs           []string
+	EmailAddresses []string
+}
+
+// Names returns the certificate's common name, if any, followed by its
+// subject alternative names.
+func (c ClientCert) Names() []string {
+	var names []string
+	if c.CommonName != "" {
+		names = append(names, c.CommonName)
+	}
+	names = append(names, c.DNSNames...)
+	names = append(names, c.URIs...)
+	return append(names, c.EmailAddresses...)
+}
+
+type clientCertKey struct{}
+
+// WithClientCert returns a copy of ctx carrying cert.
+func WithClientCert(ctx context.Context, cert ClientCert) context.Context {
+	return context.WithValue(ctx, clientCertKey{}, cert)
+}
+
+// ClientCertFromContext returns the identity stored by ClientCertificate,
+// if any.
+func ClientCertFromContext(ctx context.Context) (ClientCert, bool) {
+	cert, ok := ctx.Value(clientCertKey{}).(ClientCert)
+	return cert, ok
+}
+
+// ClientCertificate is middleware storing the identity of the request's
+// client certificate in its context, for RequireClientCert and handlers.
+// Only certificates the TLS handshake verified against the server's client
+// CAs count; requests over plain HTTP, or whose certificate wasn't
+// verified, pass through without one. Rejecting connections without a
+// valid certificate is the TLS layer's job: set tls.Config.ClientAuth.
+func ClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leaf := r.TLS.VerifiedChains[0][0]
+		cert := ClientCert{
+			CommonName:     leaf.Subject.CommonName,
+			DNSNames:       leaf.DNSNames,
+			EmailAddresses: leaf.EmailAddresses,
+		}
+		for _, u := range leaf.URIs {
+			cert.URIs = append(cert.URIs, u.String())
+		}
+		next.ServeHTTP(w, r.WithContext(WithClientCert(r.Context(), cert)))
+	})
+}
+
+// RequireClientCert returns middleware that admits only requests with a
+// verified client certificate naming one of names in its common name or
+// subject alternative names; "*" admits any verified certificate. It must
+// run after ClientCertificate: requests without a certificate get 401,
+// and certificates naming none of names get 403. Combined with a
+// Validator's Middleware it requires both a certificate and a token.
+func RequireClientCert(names ...string) func(http.Handler) http.Handler {
+	anyCert := slices.Contains(names, "*")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert, ok := ClientCertFromContext(r.Context())
+			if !ok {
+				apierr.Write(w, r, http.StatusUnauthorized, apierr.CodeUnauthorized, "client certificate required")
+				return
+			}
+			if !anyCert && !slices.ContainsFunc(cert.Names(), func(n string) bool { return slices.Contains(names, n) }) {
+				apierr.Write(w, r, http.StatusForbidden, apierr.CodeClientCertNotAllowed, "client certificate not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
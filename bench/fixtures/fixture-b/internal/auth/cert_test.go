@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func withClientCert(r *http.Request, cert *x509.Certificate, verified bool) *http.Request {
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return r
+}
+
+func TestClientCertificate(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	leaf := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"billing.internal"},
+		URIs:     []*url.URL{spiffe},
+	}
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		names  []string
+		want   int
+		wantCN string
+	}{
+		{"by common name", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true) }, []string{"billing"}, http.StatusOK, "billing"},
+		{"by DNS SAN", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true) }, []string{"billing.internal"}, http.StatusOK, "billing"},
+		{"by URI SAN", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true) }, []string{"spiffe://example.org/billing"}, http.StatusOK, "billing"},
+		{"any certificate", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true) }, []string{"*"}, http.StatusOK, "billing"},
+		{"name not allowed", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true) }, []string{"payments"}, http.StatusForbidden, ""},
+		{"unverified certificate", func() *http.Request { return withClientCert(httptest.NewRequest("GET", "/", nil), leaf, false) }, []string{"*"}, http.StatusUnauthorized, ""},
+		{"plain HTTP", func() *http.Request { return httptest.NewRequest("GET", "/", nil) }, []string{"*"}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotCN string
+			h := ClientCertificate(RequireClientCert(tt.names...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cert, _ := ClientCertFromContext(r.Context())
+				gotCN = cert.CommonName
+			})))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req())
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if gotCN != tt.wantCN {
+				t.Fatalf("CN in context = %q, want %q", gotCN, tt.wantCN)
+			}
+		})
+	}
+}
+
+func TestClientCertAndToken(t *testing.T) {
+	v := NewValidator(testSecret)
+	h := ClientCertificate(v.Middleware(RequireClientCert("billing")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("certificate without token: status = %d, want 401", rec.Code)
+	}
+
+	req := withClientCert(httptest.NewRequest("GET", "/", nil), leaf, true)
+	req.Header.Set("Authorization", "Bearer "+hs256Token(t, map[string]any{"sub": "billing"}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("certificate and token: status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "context"
+
+type claimsKey struct{}
+
+// ClaimsContextKey is the request context key under which Middleware stores
+// the validated token's claims.
+var ClaimsContextKey = claimsKey{}
+
+// WithClaims returns a copy of ctx carrying claims.
+func WithClaims(ctx context.Context, claims map[string]any) context.Context {
+	return context.WithValue(ctx, ClaimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by Middleware, if any.
+func ClaimsFromContext(ctx context.Context) (map[string]any, bool) {
+	claims, ok := ctx.Value(ClaimsContextKey).(map[string]any)
+	return claims, ok
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor pulls a raw token from a request, returning "" if the
+// request doesn't carry one in the place it looks.
+type TokenExtractor func(r *http.Request) string
+
+// BearerToken is the default extractor: the Authorization header with any
+// "Bearer " prefix removed.
+func BearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// CookieToken returns an extractor that reads the named cookie.
+func CookieToken(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// ExtractFrom adds a fallback extractor, consulted in order after the
+// Authorization header and any extractors added before it.
+func ExtractFrom(e TokenExtractor) Option {
+	return func(v *Validator) { v.extractors = append(v.extractors, e) }
+}
+
+// FromCookie falls back to the named cookie when no Bearer header is sent.
+func FromCookie(name string) Option {
+	return ExtractFrom(CookieToken(name))
+}
+
+func (v *Validator) extract(r *http.Request) string {
+	for _, e := range v.extractors {
+		if token := e(r); token != "" {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/logging"
+)
+
+var (
+	ErrInactiveToken       = errors.New("token inactive")
+	ErrIntrospectionFailed = errors.New("token introspection failed")
+)
+
+const (
+	defaultIntrospectionTTL = 5 * time.Minute
+	// inactiveIntrospectionTTL is how long an inactive verdict is kept, so
+	// a client retrying a revoked or junk token doesn't reach the auth
+	// server on every request.
+	inactiveIntrospectionTTL = 10 * time.Second
+	maxIntrospectionEntries  = 10000
+)
+
+// IntrospectionOption configures an IntrospectionValidator.
+type IntrospectionOption func(*IntrospectionValidator)
+
+// WithClientCredentials authenticates introspection requests with HTTP
+// Basic credentials, as most authorization servers require.
+func WithClientCredentials(id, secret string) IntrospectionOption {
+	return func(v *IntrospectionValidator) { v.clientID, v.clientSecret = id, secret }
+}
+
+// WithIntrospectionClient sets the client used to reach the endpoint.
+func WithIntrospectionClient(c *http.Client) IntrospectionOption {
+	return func(v *IntrospectionValidator) { v.client = c }
+}
+
+// WithMaxCacheTTL bounds how long an active token's introspection result
+// is reused, however distant its exp, so a revocation takes effect within
+// d. Defaults to 5 minutes.
+func WithMaxCacheTTL(d time.Duration) IntrospectionOption {
+	return func(v *IntrospectionValidator) { v.maxTTL = d }
+}
+
+// IntrospectionValidator validates opaque tokens by POSTing them to an RFC
+// 7662 introspection endpoint. The response's members, such as sub and
+// scope, become the claims. Results are cached until the token's exp,
+// capped by the max cache TTL, so a busy client costs the auth server one
+// request per token rather than one per call.
+type IntrospectionValidator struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	maxTTL       time.Duration
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspection
+}
+
+type introspection struct {
+	claims  Claims
+	err     error
+	expires time.Time
+}
+
+// NewIntrospectionValidator returns a validator querying endpoint.
+func NewIntrospectionValidator(endpoint string, opts ...IntrospectionOption) *IntrospectionValidator {
+	v := &IntrospectionValidator{
+		endpoint: endpoint,
+		maxTTL:   defaultIntrospectionTTL,
+		now:      time.Now,
+		cache:    make(map[[sha256.Size]byte]introspection),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.client == nil {
+		v.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return v
+}
+
+// ValidateToken returns raw's claims if the endpoint reports it active.
+// Tokens are cached by digest, so raw tokens aren't kept in memory.
+func (v *IntrospectionValidator) ValidateToken(ctx context.Context, raw string) (Claims, error) {
+	key := sha256.Sum256([]byte(raw))
+	now := v.now()
+	v.mu.Lock()
+	cached, ok := v.cache[key]
+	v.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.claims, cached.err
+	}
+
+	claims, err := v.introspect(ctx, raw)
+	if err != nil && !errors.Is(err, ErrInactiveToken) && !errors.Is(err, ErrTokenExpired) {
+		logging.Errorf("introspection: %s: %v", v.endpoint, err)
+		return nil, ErrIntrospectionFailed
+	}
+	entry := introspection{claims: claims, err: err, expires: now.Add(inactiveIntrospectionTTL)}
+	if err == nil {
+		entry.expires = now.Add(v.maxTTL)
+		if exp, ok, _ := timeClaim(claims, "exp"); ok && exp.Before(entry.expires) {
+			entry.expires = exp
+		}
+	}
+	v.store(key, entry, now)
+	return claims, err
+}
+
+func (v *IntrospectionValidator) introspect(ctx context.Context, raw string) (Claims, error) {
+	form := url.Values{"token": {raw}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if v.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(v.clientID), url.QueryEscape(v.clientSecret))
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var claims Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrInactiveToken
+	}
+	delete(claims, "active")
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return nil, fmt.Errorf("invalid exp %v", claims["exp"])
+	}
+	if ok && !v.now().Before(exp) {
+		return nil, rejectClaims(claims, ErrTokenExpired)
+	}
+	return claims, nil
+}
+
+func (v *IntrospectionValidator) store(key [sha256.Size]byte, entry introspection, now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.cache) >= maxIntrospectionEntries {
+		for k, e := range v.cache {
+			if !now.Before(e.expires) {
+				delete(v.cache, k)
+			}
+		}
+	}
+	if len(v.cache) >= maxIntrospectionEntries {
+		// Still full of live entries: forget an arbitrary one, which costs
+		// at most an extra introspection later.
+		for k := range v.cache {
+			delete(v.cache, k)
+			break
+		}
+	}
+	v.cache[key] = entry
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type introspectionServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	tokens   map[string]map[string]any
+	down     bool
+	requests int
+}
+
+func newIntrospectionServer(t *testing.T) *introspectionServer {
+	s := &introspectionServer{tokens: map[string]map[string]any{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if id, secret, _ := r.BasicAuth(); id != "gateway" || secret != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if s.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		resp := map[string]any{"active": false}
+		if claims, ok := s.tokens[r.PostFormValue("token")]; ok {
+			resp = map[string]any{"active": true}
+			for k, v := range claims {
+				resp[k] = v
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *introspectionServer) set(token string, claims map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if claims == nil {
+		delete(s.tokens, token)
+	} else {
+		s.tokens[token] = claims
+	}
+}
+
+func (s *introspectionServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestIntrospectionValidator(t *testing.T) {
+	srv := newIntrospectionServer(t)
+	now := time.Unix(1_700_000_000, 0)
+	v := NewIntrospectionValidator(srv.URL, WithClientCredentials("gateway", "s3cret"))
+	v.now = func() time.Time { return now }
+
+	srv.set("opaque-1", map[string]any{"sub": "alice", "scope": "users:read", "exp": float64(now.Add(time.Minute).Unix())})
+	claims, err := v.ValidateToken(context.Background(), "opaque-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "alice" || !HasScope(claims, "users:read") {
+		t.Fatalf("claims = %v", claims)
+	}
+	if _, ok := claims["active"]; ok {
+		t.Fatal("active leaked into claims")
+	}
+
+	// Revoked upstream, but the cached result holds until exp.
+	srv.set("opaque-1", nil)
+	if _, err := v.ValidateToken(context.Background(), "opaque-1"); err != nil || srv.count() != 1 {
+		t.Fatalf("cached lookup: err = %v, requests = %d", err, srv.count())
+	}
+	now = now.Add(time.Minute)
+	if _, err := v.ValidateToken(context.Background(), "opaque-1"); !errors.Is(err, ErrInactiveToken) || srv.count() != 2 {
+		t.Fatalf("after exp: err = %v, requests = %d", err, srv.count())
+	}
+
+	// Inactive verdicts are cached briefly too.
+	if _, err := v.ValidateToken(context.Background(), "opaque-1"); !errors.Is(err, ErrInactiveToken) || srv.count() != 2 {
+		t.Fatalf("cached inactive: err = %v, requests = %d", err, srv.count())
+	}
+
+	// Without exp, the max cache TTL bounds reuse.
+	srv.set("opaque-2", map[string]any{"sub": "bob"})
+	v.ValidateToken(context.Background(), "opaque-2")
+	now = now.Add(defaultIntrospectionTTL)
+	v.ValidateToken(context.Background(), "opaque-2")
+	if srv.count() != 4 {
+		t.Fatalf("requests = %d, want 4", srv.count())
+	}
+
+	// Failures aren't cached.
+	srv.mu.Lock()
+	srv.down = true
+	srv.mu.Unlock()
+	for range 2 {
+		if _, err := v.ValidateToken(context.Background(), "opaque-3"); !errors.Is(err, ErrIntrospectionFailed) {
+			t.Fatalf("err = %v, want ErrIntrospectionFailed", err)
+		}
+	}
+	if srv.count() != 6 {
+		t.Fatalf("requests = %d, want 6", srv.count())
+	}
+}
+
+func TestIntrospectionRejectsExpiredToken(t *testing.T) {
+	srv := newIntrospectionServer(t)
+	v := NewIntrospectionValidator(srv.URL, WithClientCredentials("gateway", "s3cret"))
+	srv.set("stale", map[string]any{"sub": "alice", "exp": float64(time.Now().Add(-time.Minute).Unix())})
+	if _, err := v.ValidateToken(context.Background(), "stale"); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("err = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestNewValidatorFor(t *testing.T) {
+	srv := newIntrospectionServer(t)
+	srv.set("opaque-1", map[string]any{"sub": "alice"})
+	v := NewValidatorFor(NewIntrospectionValidator(srv.URL, WithClientCredentials("gateway", "s3cret")), Skip("/healthz"))
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		sub, _ := claims["sub"].(string)
+		w.Write([]byte(sub))
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"active", "/", "opaque-1", http.StatusOK, "alice"},
+		{"inactive", "/", "opaque-2", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"token inactive"}}`},
+		{"no token", "/", "", http.StatusUnauthorized, `{"error":{"code":"unauthorized","message":"unauthorized"}}`},
+		{"skipped", "/healthz", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Fatalf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/internal/logging"
+)
+
+var (
+	ErrUnknownKeyID = errors.New("unknown token key id")
+	ErrMissingKeyID = errors.New("token has no key id")
+)
+
+const (
+	defaultJWKSTTL = time.Hour
+	// minJWKSRefresh bounds how often an unknown kid may trigger a refetch,
+	// so a stream of junk tokens can't turn into a stream of JWKS requests.
+	minJWKSRefresh = 10 * time.Second
+)
+
+// WithJWKSCacheTTL sets how long fetched keys are trusted before the key set
+// is refetched. It only affects validators built with NewJWKSValidator.
+func WithJWKSCacheTTL(d time.Duration) Option {
+	return func(v *Validator) { v.jwksTTL = d }
+}
+
+// WithHTTPClient sets the client used to reach the auth server.
+func WithHTTPClient(c *http.Client) Option {
+	return func(v *Validator) { v.httpClient = c }
+}
+
+// NewJWKSValidator returns a Validator for RS256 tokens whose keys are
+// published at jwksURL. Keys are fetched lazily, cached for the configured
+// TTL, and refetched early when a token names an unknown kid. If the
+// endpoint is unreachable, the last successfully fetched keys keep being
+// served.
+func NewJWKSValidator(jwksURL string, opts ...Option) *Validator {
+	v := newValidator("RS256", nil, opts)
+	c := &jwksCache{
+		url:    jwksURL,
+		ttl:    v.jwksTTL,
+		client: v.httpClient,
+		now:    func() time.Time { return v.now() },
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultJWKSTTL
+	}
+	if c.client == nil {
+		c.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	v.verify = c.verify
+	return v
+}
+
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	triedAt   time.Time
+	// refreshing is closed when the fetch in flight, if any, is done.
+	refreshing chan struct{}
+}
+
+func (c *jwksCache) verify(header tokenHeader, signingInput string, sig []byte) error {
+	key, err := c.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	return verifyRS256(key, signingInput, sig)
+}
+
+// key returns the key for kid, refetching the set when it is stale or
+// lacks kid. The fetch runs outside the lock, on the request that started
+// it: other requests go on with the cached keys meanwhile, and only those
+// for a kid the cache lacks wait to see whether the fetch brings it.
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	now := c.now()
+	stale := now.Sub(c.fetchedAt) >= c.ttl
+	_, known := c.lookup(kid)
+	inflight := c.refreshing
+	var done chan struct{}
+	if (stale || !known) && inflight == nil && now.Sub(c.triedAt) >= minJWKSRefresh {
+		c.triedAt = now
+		done = make(chan struct{})
+		c.refreshing = done
+	}
+	c.mu.Unlock()
+
+	switch {
+	case done != nil:
+		keys, err := c.fetch()
+		if err != nil {
+			logging.Warnf("jwks: refresh from %s failed, serving cached keys: %v", c.url, err)
+		}
+		c.mu.Lock()
+		if err == nil {
+			c.keys = keys
+			c.fetchedAt = now
+		}
+		c.refreshing = nil
+		c.mu.Unlock()
+		close(done)
+	case inflight != nil && !known:
+		<-inflight
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) == 0 {
+		return nil, ErrNoKey
+	}
+	if kid == "" && len(c.keys) > 1 {
+		return nil, ErrMissingKeyID
+	}
+	key, ok := c.lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// lookup finds the key for kid. A token without a kid matches only when the
+// set holds exactly one key.
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// fetch gets the key set from the endpoint.
+func (c *jwksCache) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		pub, err := jwk.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no RSA signing keys")
+	}
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid key parameters")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	down    bool
+	fetches int
+	// hold, if set, keeps fetches waiting until it is closed.
+	hold chan struct{}
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetches++
+		hold := s.hold
+		s.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestJWKSValidator(t *testing.T) {
+	srv := newJWKSServer(t)
+	k1 := srv.addKey(t, "k1")
+	now := time.Unix(1_700_000_000, 0)
+	v := NewJWKSValidator(srv.URL, WithJWKSCacheTTL(time.Hour))
+	v.now = func() time.Time { return now }
+	claims := map[string]any{"sub": "user-1"}
+
+	token := signRS256(t, k1, map[string]any{"alg": "RS256", "kid": "k1"}, claims)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Validate(token); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+	}
+	if got := srv.fetchCount(); got != 1 {
+		t.Fatalf("fetches after cached requests = %d, want 1", got)
+	}
+
+	// A rotated-in key is picked up on the first token that names it.
+	now = now.Add(time.Minute)
+	k2 := srv.addKey(t, "k2")
+	if _, err := v.Validate(signRS256(t, k2, map[string]any{"alg": "RS256", "kid": "k2"}, claims)); err != nil {
+		t.Fatalf("Validate() with rotated key error = %v", err)
+	}
+	if got := srv.fetchCount(); got != 2 {
+		t.Fatalf("fetches after unknown kid = %d, want 2", got)
+	}
+
+	// Unknown kids don't refetch more than once per refresh interval.
+	bogus := signRS256(t, k2, map[string]any{"alg": "RS256", "kid": "bogus"}, claims)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Validate(bogus); !errors.Is(err, ErrUnknownKeyID) {
+			t.Fatalf("Validate() error = %v, want %v", err, ErrUnknownKeyID)
+		}
+	}
+	if got := srv.fetchCount(); got != 2 {
+		t.Fatalf("fetches after repeated unknown kid = %d, want 2", got)
+	}
+
+	// An outage after the TTL expires keeps serving the stale keys.
+	srv.mu.Lock()
+	srv.down = true
+	srv.mu.Unlock()
+	now = now.Add(2 * time.Hour)
+	if _, err := v.Validate(token); err != nil {
+		t.Fatalf("Validate() during outage error = %v", err)
+	}
+	if got := srv.fetchCount(); got != 3 {
+		t.Fatalf("fetches after TTL expiry = %d, want 3", got)
+	}
+
+	// With more than one key published, a token must say which it used.
+	noKid := signRS256(t, k1, map[string]any{"alg": "RS256"}, claims)
+	if _, err := v.Validate(noKid); !errors.Is(err, ErrMissingKeyID) {
+		t.Fatalf("Validate() without kid error = %v, want %v", err, ErrMissingKeyID)
+	}
+}
+
+func TestJWKSValidatorServesCachedKeysDuringRefresh(t *testing.T) {
+	srv := newJWKSServer(t)
+	k1 := srv.addKey(t, "k1")
+	var mu sync.Mutex
+	now := time.Unix(1_700_000_000, 0)
+	v := NewJWKSValidator(srv.URL, WithJWKSCacheTTL(time.Hour))
+	v.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	token := signRS256(t, k1, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"sub": "user-1"})
+	if _, err := v.Validate(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys go stale and the endpoint stalls on the refetch.
+	hold := make(chan struct{})
+	srv.mu.Lock()
+	srv.hold = hold
+	srv.mu.Unlock()
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := v.Validate(token)
+		refreshed <- err
+	}()
+	for srv.fetchCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	validated := make(chan error, 1)
+	go func() {
+		_, err := v.Validate(token)
+		validated <- err
+	}()
+	select {
+	case err := <-validated:
+		if err != nil {
+			t.Fatalf("Validate() during refresh error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Validate() with a cached kid waited for the refresh")
+	}
+	close(hold)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("Validate() that refreshed error = %v", err)
+	}
+	if got := srv.fetchCount(); got != 2 {
+		t.Fatalf("fetches = %d, want 2", got)
+	}
+}
+
+func TestJWKSValidatorSingleKeyWithoutKid(t *testing.T) {
+	srv := newJWKSServer(t)
+	key := srv.addKey(t, "only")
+	v := NewJWKSValidator(srv.URL)
+
+	if _, err := v.Validate(signRS256(t, key, map[string]any{"alg": "RS256"}, map[string]any{"sub": "user-1"})); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+}
+
+func TestJWKSValidatorUnreachable(t *testing.T) {
+	srv := newJWKSServer(t)
+	key := srv.addKey(t, "k1")
+	srv.down = true
+	v := NewJWKSValidator(srv.URL)
+
+	_, err := v.Validate(signRS256(t, key, map[string]any{"alg": "RS256", "kid": "k1"}, map[string]any{"sub": "user-1"}))
+	if !errors.Is(err, ErrNoKey) {
+		t.Fatalf("Validate() error = %v, want %v", err, ErrNoKey)
+	}
+}
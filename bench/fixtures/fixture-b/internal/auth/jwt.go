@@ -1,26 +1,352 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"path"
 	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrUnsupportedAlg   = errors.New("unexpected token algorithm")
+	ErrNoKey            = errors.New("no verification key configured")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrTokenIssuedLater = errors.New("token issued in the future")
+	ErrMissingExpiry    = errors.New("token has no expiry")
+	ErrInvalidAudience  = errors.New("token audience mismatch")
+	ErrInvalidIssuer    = errors.New("token issuer mismatch")
 )
 
 type Validator struct {
-	secret string
+	alg        string
+	verify     verifyFunc
+	leeway     time.Duration
+	requireExp bool
+	audience   string
+	issuer     string
+	now        func() time.Time
+
+	jwksTTL    time.Duration
+	httpClient *http.Client
+
+	// fallbackSecrets are the HMAC secrets accepted besides NewValidator's.
+	fallbackSecrets [][]byte
+
+	skip       []string
+	extractors []TokenExtractor
+	// tokens checks extracted tokens; nil means the Validator's own JWT
+	// checks.
+	tokens TokenValidator
+	audit  *AuditLog
+}
+
+// Option configures a Validator.
+type Option func(*Validator)
+
+// WithLeeway tolerates clock skew of up to d when checking exp, nbf and iat.
+func WithLeeway(d time.Duration) Option {
+	return func(v *Validator) { v.leeway = d }
+}
+
+// RequireExpiry rejects tokens that carry no exp claim. By default such
+// tokens are accepted, as exp is optional per RFC 7519.
+func RequireExpiry() Option {
+	return func(v *Validator) { v.requireExp = true }
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyFunc checks sig against the token's signing input (header.payload).
+type verifyFunc func(header tokenHeader, signingInput string, sig []byte) error
+
+// NewValidator returns a Validator for HS256 tokens signed with secret, or
+// with one of the secrets given to WithFallbackSecrets.
+func NewValidator(secret string, opts ...Option) *Validator {
+	v := newValidator("HS256", nil, opts)
+	if secret != "" {
+		v.verify = hmacVerifier(append([][]byte{[]byte(secret)}, v.fallbackSecrets...))
+	}
+	return v
+}
+
+// WithFallbackSecrets also accepts HS256 tokens signed with any of
+// secrets, so that tokens issued under a previous secret stay valid while
+// the signing secret is rotated. Tokens should only ever be signed with
+// NewValidator's secret; drop a fallback once tokens signed with it have
+// expired. It has no effect without a primary secret, nor on RS256
+// validators.
+func WithFallbackSecrets(secrets ...string) Option {
+	return func(v *Validator) {
+		for _, s := range secrets {
+			v.fallbackSecrets = append(v.fallbackSecrets, []byte(s))
+		}
+	}
+}
+
+// NewRSAValidator returns a Validator for RS256 tokens signed by the private
+// half of pub.
+func NewRSAValidator(pub *rsa.PublicKey, opts ...Option) *Validator {
+	var verify verifyFunc
+	if pub != nil {
+		verify = rsaVerifier(pub)
+	}
+	return newValidator("RS256", verify, opts)
+}
+
+// WithAudience requires the aud claim, a string or an array of strings, to
+// include aud.
+func WithAudience(aud string) Option {
+	return func(v *Validator) { v.audience = aud }
+}
+
+// WithIssuer requires the iss claim to equal iss.
+func WithIssuer(iss string) Option {
+	return func(v *Validator) { v.issuer = iss }
+}
+
+// Skip exempts paths from authentication. Following http.ServeMux, a path
+// ending in "/" matches every request path under it; any other path must
+// match exactly. Skip("/healthz", "/api/v1/public/") exempts /healthz and
+// /api/v1/public/anything, but not /healthz/deep or /api/v1/public.
+func Skip(paths ...string) Option {
+	return func(v *Validator) { v.skip = append(v.skip, paths...) }
+}
+
+func (v *Validator) skipped(reqPath string) bool {
+	// Match against the cleaned path so "/api/v1/public/../users" can't
+	// borrow a public prefix.
+	clean := path.Clean(reqPath)
+	if strings.HasSuffix(reqPath, "/") && clean != "/" {
+		clean += "/"
+	}
+	if clean != reqPath {
+		return false
+	}
+	for _, p := range v.skip {
+		if strings.HasSuffix(p, "/") {
+			if strings.HasPrefix(reqPath, p) {
+				return true
+			}
+		} else if reqPath == p {
+			return true
+		}
+	}
+	return false
+}
+
+func newValidator(alg string, verify verifyFunc, opts []Option) *Validator {
+	v := &Validator{
+		alg:        alg,
+		verify:     verify,
+		now:        time.Now,
+		extractors: []TokenExtractor{BearerToken},
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// hmacVerifier accepts signatures by any of secrets.
+func hmacVerifier(secrets [][]byte) verifyFunc {
+	return func(_ tokenHeader, signingInput string, sig []byte) error {
+		for _, secret := range secrets {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(signingInput))
+			if hmac.Equal(sig, mac.Sum(nil)) {
+				return nil
+			}
+		}
+		return ErrInvalidSignature
+	}
 }
 
-func NewValidator(secret string) *Validator {
-	return &Validator{secret: secret}
+func rsaVerifier(pub *rsa.PublicKey) verifyFunc {
+	return func(_ tokenHeader, signingInput string, sig []byte) error {
+		return verifyRS256(pub, signingInput, sig)
+	}
+}
+
+func verifyRS256(pub *rsa.PublicKey, signingInput string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
 }
 
 func (v *Validator) Middleware(next http.Handler) http.Handler {
+	authed := authMiddleware(v, v.audit, next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if token == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if v.skipped(r.URL.Path) {
+			next.ServeHTTP(w, r)
 			return
 		}
-		// Token validation placeholder
-		next.ServeHTTP(w, r)
+		authed.ServeHTTP(w, r)
 	})
 }
+
+// Authenticate validates the token the request carries, returning
+// ErrNoCredentials if it has none.
+func (v *Validator) Authenticate(r *http.Request) (map[string]any, error) {
+	token := v.extract(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	if v.tokens != nil {
+		return v.tokens.ValidateToken(r.Context(), token)
+	}
+	return v.Validate(token)
+}
+
+// Validate verifies a token's signature with the configured key, checks its
+// time-based claims, and returns its decoded claims. Tokens whose alg header
+// differs from the Validator's algorithm are rejected outright, and a
+// Validator without a key rejects every token.
+func (v *Validator) Validate(token string) (map[string]any, error) {
+	if v.verify == nil {
+		return nil, ErrNoKey
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != v.alg {
+		return nil, ErrUnsupportedAlg
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.verify(header, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims == nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.checkTimes(claims); err != nil {
+		return nil, rejectClaims(claims, err)
+	}
+	if err := v.checkAudienceIssuer(claims); err != nil {
+		return nil, rejectClaims(claims, err)
+	}
+	return claims, nil
+}
+
+// rejectClaims wraps err, a check failed by a correctly signed token's
+// claims, with the token's subject for the audit log.
+func rejectClaims(claims map[string]any, err error) error {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return err
+	}
+	return &rejectedTokenError{err: err, sub: sub}
+}
+
+func (v *Validator) checkAudienceIssuer(claims map[string]any) error {
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return ErrInvalidIssuer
+		}
+	}
+	if v.audience == "" {
+		return nil
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == v.audience {
+			return nil
+		}
+	case []any:
+		for _, a := range aud {
+			if a == v.audience {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidAudience
+}
+
+func (v *Validator) checkTimes(claims map[string]any) error {
+	now := v.now()
+
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok && v.requireExp {
+		return ErrMissingExpiry
+	}
+	if ok && now.After(exp.Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	iat, ok, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(iat) {
+		return ErrTokenIssuedLater
+	}
+	return nil
+}
+
+// timeClaim reads a NumericDate claim. A present but non-numeric value is
+// reported as a malformed token.
+func timeClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	raw, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	secs, ok := raw.(float64)
+	if !ok {
+		return time.Time{}, false, ErrMalformedToken
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true, nil
+}
+
+func decodeSegment(seg string, dst any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
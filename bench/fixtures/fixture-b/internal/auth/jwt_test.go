@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func signHS256(t *testing.T, secret string, header, claims map[string]any) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hs256Token(t *testing.T, claims map[string]any) string {
+	return signHS256(t, testSecret, map[string]any{"alg": "HS256", "typ": "JWT"}, claims)
+}
+
+func TestValidate(t *testing.T) {
+	valid := hs256Token(t, map[string]any{"sub": "user-1"})
+	tests := []struct {
+		name    string
+		secret  string
+		token   string
+		wantErr error
+	}{
+		{"valid", testSecret, valid, nil},
+		{"wrong secret", testSecret, signHS256(t, "other", map[string]any{"alg": "HS256"}, map[string]any{"sub": "user-1"}), ErrInvalidSignature},
+		{"tampered payload", testSecret, replaceSegment(valid, 1, base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))), ErrInvalidSignature},
+		{"tampered signature", testSecret, valid[:len(valid)-2] + "AA", ErrInvalidSignature},
+		{"alg none", testSecret, signHS256(t, testSecret, map[string]any{"alg": "none"}, map[string]any{"sub": "user-1"}), ErrUnsupportedAlg},
+		{"two segments", testSecret, "abc.def", ErrMalformedToken},
+		{"four segments", testSecret, valid + ".extra", ErrMalformedToken},
+		{"bad base64 header", testSecret, replaceSegment(valid, 0, "!!!"), ErrMalformedToken},
+		{"bad base64 signature", testSecret, valid + "!", ErrMalformedToken},
+		{"garbage", testSecret, "not-a-token", ErrMalformedToken},
+		{"empty secret", "", valid, ErrNoKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewValidator(tt.secret).Validate(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFallbackSecrets(t *testing.T) {
+	header := map[string]any{"alg": "HS256"}
+	claims := map[string]any{"sub": "user-1"}
+	oldToken := signHS256(t, "old-secret", header, claims)
+	newToken := signHS256(t, "new-secret", header, claims)
+	tests := []struct {
+		name    string
+		v       *Validator
+		token   string
+		wantErr error
+	}{
+		{"old token during rotation", NewValidator("new-secret", WithFallbackSecrets("old-secret")), oldToken, nil},
+		{"new token during rotation", NewValidator("new-secret", WithFallbackSecrets("old-secret")), newToken, nil},
+		{"old token after rotation", NewValidator("new-secret"), oldToken, ErrInvalidSignature},
+		{"unknown secret", NewValidator("new-secret", WithFallbackSecrets("old-secret")), signHS256(t, "other", header, claims), ErrInvalidSignature},
+		{"fallback without primary", NewValidator("", WithFallbackSecrets("old-secret")), oldToken, ErrNoKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.v.Validate(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		want   int
+	}{
+		{"valid token", testSecret, "Bearer " + hs256Token(t, map[string]any{"sub": "user-1"}), http.StatusOK},
+		{"missing header", testSecret, "", http.StatusUnauthorized},
+		{"garbage token", testSecret, "Bearer garbage", http.StatusUnauthorized},
+		{"empty secret fails closed", "", "Bearer " + hs256Token(t, map[string]any{"sub": "user-1"}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			NewValidator(tt.secret).Middleware(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func replaceSegment(token string, i int, seg string) string {
+	parts := strings.Split(token, ".")
+	parts[i] = seg
+	return strings.Join(parts, ".")
+}
+
+func TestValidateTimeClaims(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+	tests := []struct {
+		name    string
+		opts    []Option
+		claims  map[string]any
+		wantErr error
+	}{
+		{"unexpired", nil, map[string]any{"exp": at(time.Minute)}, nil},
+		{"expired", nil, map[string]any{"exp": at(-time.Minute)}, ErrTokenExpired},
+		{"expired within leeway", []Option{WithLeeway(2 * time.Minute)}, map[string]any{"exp": at(-time.Minute)}, nil},
+		{"expired beyond leeway", []Option{WithLeeway(30 * time.Second)}, map[string]any{"exp": at(-time.Minute)}, ErrTokenExpired},
+		{"nbf in future", nil, map[string]any{"nbf": at(time.Minute)}, ErrTokenNotYetValid},
+		{"nbf within leeway", []Option{WithLeeway(2 * time.Minute)}, map[string]any{"nbf": at(time.Minute)}, nil},
+		{"nbf in past", nil, map[string]any{"nbf": at(-time.Minute)}, nil},
+		{"iat in future", nil, map[string]any{"iat": at(time.Hour)}, ErrTokenIssuedLater},
+		{"no exp allowed by default", nil, map[string]any{"sub": "user-1"}, nil},
+		{"no exp with RequireExpiry", []Option{RequireExpiry()}, map[string]any{"sub": "user-1"}, ErrMissingExpiry},
+		{"string exp", nil, map[string]any{"exp": "tomorrow"}, ErrMalformedToken},
+		{"boolean nbf", nil, map[string]any{"nbf": true}, ErrMalformedToken},
+		{"far future exp", nil, map[string]any{"exp": float64(32503680000)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(testSecret, tt.opts...)
+			v.now = func() time.Time { return now }
+			_, err := v.Validate(hs256Token(t, tt.claims))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddlewareExpiredBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+hs256Token(t, map[string]any{"exp": float64(time.Now().Add(-time.Hour).Unix())}))
+	rec := httptest.NewRecorder()
+	NewValidator(testSecret).Middleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"error":{"code":"unauthorized","message":"token expired"}}`; got != want {
+		t.Fatalf("body = %s, want %s", got, want)
+	}
+}
+
+func TestMiddlewareStoresClaims(t *testing.T) {
+	var got map[string]any
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ClaimsFromContext(r.Context())
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+hs256Token(t, map[string]any{"sub": "user-1"}))
+	NewValidator(testSecret).Middleware(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if got["sub"] != "user-1" {
+		t.Fatalf("sub claim = %v, want user-1", got["sub"])
+	}
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestRSAValidator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	claims := map[string]any{"sub": "user-1"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid RS256", signRS256(t, key, map[string]any{"alg": "RS256"}, claims), nil},
+		{"signed by other key", signRS256(t, other, map[string]any{"alg": "RS256"}, claims), ErrInvalidSignature},
+		{"HS256 token", hs256Token(t, claims), ErrUnsupportedAlg},
+		{"HS256 keyed with public key", signHS256(t, string(pubDER), map[string]any{"alg": "HS256"}, claims), ErrUnsupportedAlg},
+		{"alg none", signRS256(t, key, map[string]any{"alg": "none"}, claims), ErrUnsupportedAlg},
+	}
+	v := NewRSAValidator(&key.PublicKey)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewRSAValidator(nil).Validate(signRS256(t, key, map[string]any{"alg": "RS256"}, claims)); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("nil key: error = %v, want %v", err, ErrNoKey)
+	}
+}
+
+func TestHMACValidatorRejectsRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signRS256(t, key, map[string]any{"alg": "RS256"}, map[string]any{"sub": "user-1"})
+	if _, err := NewValidator(testSecret).Validate(token); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Fatalf("Validate() error = %v, want %v", err, ErrUnsupportedAlg)
+	}
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	v := NewValidator(testSecret, Skip("/healthz", "/api/v1/public/"))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/healthz/deep", http.StatusUnauthorized},
+		{"/healthzz", http.StatusUnauthorized},
+		{"/api/v1/public/", http.StatusOK},
+		{"/api/v1/public/docs", http.StatusOK},
+		{"/api/v1/public", http.StatusUnauthorized},
+		{"/api/v1/public/../users", http.StatusUnauthorized},
+		{"/api/v1/users", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			// A garbage credential on a skipped path must not be inspected.
+			req.Header.Set("Authorization", "Bearer garbage")
+			rec := httptest.NewRecorder()
+			v.Middleware(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareFromCookie(t *testing.T) {
+	valid := hs256Token(t, map[string]any{"sub": "cookie-user"})
+	headerToken := hs256Token(t, map[string]any{"sub": "header-user"})
+	tests := []struct {
+		name    string
+		header  string
+		cookie  string
+		want    int
+		wantSub string
+	}{
+		{"cookie only", "", valid, http.StatusOK, "cookie-user"},
+		{"header wins over cookie", "Bearer " + headerToken, valid, http.StatusOK, "header-user"},
+		{"bad header not rescued by cookie", "Bearer garbage", valid, http.StatusUnauthorized, ""},
+		{"bad cookie", "", "garbage", http.StatusUnauthorized, ""},
+		{"neither", "", "", http.StatusUnauthorized, ""},
+	}
+	v := NewValidator(testSecret, FromCookie("session"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sub any
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ := ClaimsFromContext(r.Context())
+				sub = claims["sub"]
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			v.Middleware(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.wantSub != "" && sub != tt.wantSub {
+				t.Fatalf("sub = %v, want %s", sub, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestValidateAudienceIssuer(t *testing.T) {
+	v := NewValidator(testSecret, WithAudience("api-gateway"), WithIssuer("https://auth.example.com"))
+	iss := "https://auth.example.com"
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr error
+	}{
+		{"string aud", map[string]any{"iss": iss, "aud": "api-gateway"}, nil},
+		{"array aud containing expected", map[string]any{"iss": iss, "aud": []any{"billing", "api-gateway", "reports"}}, nil},
+		{"string aud mismatch", map[string]any{"iss": iss, "aud": "billing"}, ErrInvalidAudience},
+		{"array aud without expected", map[string]any{"iss": iss, "aud": []any{"billing", "reports"}}, ErrInvalidAudience},
+		{"missing aud", map[string]any{"iss": iss}, ErrInvalidAudience},
+		{"numeric aud", map[string]any{"iss": iss, "aud": 42}, ErrInvalidAudience},
+		{"issuer mismatch", map[string]any{"iss": "https://evil.example.com", "aud": "api-gateway"}, ErrInvalidIssuer},
+		{"missing issuer", map[string]any{"aud": "api-gateway"}, ErrInvalidIssuer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(hs256Token(t, tt.claims))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewValidator(testSecret).Validate(hs256Token(t, map[string]any{"aud": "anything"})); err != nil {
+		t.Fatalf("unconfigured audience should be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/apierr"
+)
+
+// RequireScope returns middleware that admits only requests whose validated
+// claims grant scope, either in a space-delimited "scope" string or a
+// "scopes" array. It must run after a Validator's Middleware: requests with
+// no claims in context get 401, and claims that don't grant the scope —
+// including a missing or empty scope claim — get 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				apierr.Write(w, r, http.StatusUnauthorized, apierr.CodeUnauthorized, "unauthorized")
+				return
+			}
+			if !HasScope(claims, scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				apierr.Write(w, r, http.StatusForbidden, apierr.CodeInsufficientScope, "insufficient scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasScope reports whether claims grant scope.
+func HasScope(claims map[string]any, scope string) bool {
+	if s, ok := claims["scope"].(string); ok {
+		for _, granted := range strings.Fields(s) {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	if list, ok := claims["scopes"].([]any); ok {
+		for _, granted := range list {
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   int
+	}{
+		{"scope string", map[string]any{"scope": "users:read services:read"}, http.StatusOK},
+		{"scopes array", map[string]any{"scopes": []any{"users:read", "services:read"}}, http.StatusOK},
+		{"scope absent from string", map[string]any{"scope": "users:read"}, http.StatusForbidden},
+		{"scope prefix only", map[string]any{"scope": "services:read:all"}, http.StatusForbidden},
+		{"empty scope string", map[string]any{"scope": ""}, http.StatusForbidden},
+		{"empty scopes array", map[string]any{"scopes": []any{}}, http.StatusForbidden},
+		{"no scope claim", map[string]any{"sub": "user-1"}, http.StatusForbidden},
+		{"no claims in context", nil, http.StatusUnauthorized},
+	}
+	h := RequireScope("services:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/services", nil)
+			if tt.claims != nil {
+				req = req.WithContext(WithClaims(req.Context(), tt.claims))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
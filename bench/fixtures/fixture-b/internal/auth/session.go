@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-gateway/internal/apierr"
+)
+
+// DefaultSessionCookie is the cookie a Sessions issues unless
+// SessionCookieName says otherwise.
+const DefaultSessionCookie = "session"
+
+var (
+	ErrInvalidSession = errors.New("invalid session")
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// maxSessionCookie is the longest cookie value issued; browsers drop
+// cookies much over 4KB without telling anyone.
+const maxSessionCookie = 4000
+
+// Sessions issues and validates signed session cookies, so that browsers
+// can trade a JWT for an HttpOnly cookie once instead of carrying the raw
+// token. A cookie's value is a payload of the caller's claims and an expiry,
+// followed by an HMAC-SHA256 of the payload; it isn't encrypted, so the
+// claims are readable by whoever holds the cookie, as a JWT's are.
+//
+// Sessions is an Authenticator: combine it with a Validator through AnyOf
+// to accept either credential. A tampered or expired cookie is rejected
+// with 401 and cleared from the browser.
+type Sessions struct {
+	name   string
+	ttl    time.Duration
+	path   string
+	secure bool
+	now    func() time.Time
+
+	// secrets[0] signs; every secret verifies.
+	secrets [][]byte
+	verify  verifyFunc
+}
+
+// SessionOption configures Sessions.
+type SessionOption func(*Sessions)
+
+// SessionCookieName sets the cookie's name, DefaultSessionCookie by
+// default.
+func SessionCookieName(name string) SessionOption {
+	return func(s *Sessions) { s.name = name }
+}
+
+// SessionTTL sets how long an issued session lasts, 12 hours by default.
+// Sessions aren't extended by use; the browser logs in again once its
+// cookie expires.
+func SessionTTL(d time.Duration) SessionOption {
+	return func(s *Sessions) { s.ttl = d }
+}
+
+// SessionPath sets the cookie's Path attribute, "/" by default.
+func SessionPath(path string) SessionOption {
+	return func(s *Sessions) { s.path = path }
+}
+
+// InsecureSessionCookie leaves the Secure attribute off, so browsers send
+// the cookie over plain HTTP too. It is meant for local development only.
+func InsecureSessionCookie() SessionOption {
+	return func(s *Sessions) { s.secure = false }
+}
+
+// SessionFallbackSecrets also accepts cookies signed with any of secrets,
+// as WithFallbackSecrets does for tokens, so sessions survive a rotation
+// of the signing secret.
+func SessionFallbackSecrets(secrets ...string) SessionOption {
+	return func(s *Sessions) {
+		for _, secret := range secrets {
+			s.secrets = append(s.secrets, []byte(secret))
+		}
+	}
+}
+
+// NewSessions returns Sessions signing cookies with secret. The secret
+// should be a random value of its own rather than the JWT secret.
+func NewSessions(secret string, opts ...SessionOption) *Sessions {
+	s := &Sessions{
+		name:    DefaultSessionCookie,
+		ttl:     12 * time.Hour,
+		path:    "/",
+		secure:  true,
+		now:     time.Now,
+		secrets: [][]byte{[]byte(secret)},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.verify = hmacVerifier(s.secrets)
+	return s
+}
+
+// sessionPayload is what a session cookie's signature covers.
+type sessionPayload struct {
+	Claims map[string]any `json:"claims"`
+	Exp    int64          `json:"exp"`
+}
+
+// NewCookie returns a session cookie carrying claims, valid for the
+// configured TTL or until the claims' own exp, whichever comes first, so
+// trading a short-lived token for a session doesn't extend it. A cookie
+// too large for browsers to keep is an error.
+func (s *Sessions) NewCookie(claims map[string]any) (*http.Cookie, error) {
+	now := s.now()
+	exp := now.Add(s.ttl)
+	if tokenExp, ok, _ := timeClaim(claims, "exp"); ok && tokenExp.Before(exp) {
+		exp = tokenExp
+	}
+	payload, err := json.Marshal(sessionPayload{Claims: claims, Exp: exp.Unix()})
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	value := encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded))
+	if len(value) > maxSessionCookie {
+		return nil, fmt.Errorf("session: cookie of %d bytes exceeds %d", len(value), maxSessionCookie)
+	}
+	c := s.cookie(value)
+	c.Expires = exp
+	c.MaxAge = int(exp.Sub(now) / time.Second)
+	return c, nil
+}
+
+// Issue sets a session cookie carrying claims on w.
+func (s *Sessions) Issue(w http.ResponseWriter, claims map[string]any) error {
+	c, err := s.NewCookie(claims)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, c)
+	return nil
+}
+
+// Clear tells the browser to drop its session cookie.
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	c := s.cookie("")
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+func (s *Sessions) cookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.name,
+		Value:    value,
+		Path:     s.path,
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *Sessions) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secrets[0])
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Authenticate validates the request's session cookie, returning
+// ErrNoCredentials if it has none.
+func (s *Sessions) Authenticate(r *http.Request) (map[string]any, error) {
+	value := CookieToken(s.name)(r)
+	if value == "" {
+		return nil, ErrNoCredentials
+	}
+	return s.Validate(value)
+}
+
+// Validate checks a session cookie's value and returns its claims.
+func (s *Sessions) Validate(value string) (map[string]any, error) {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	if err := s.verify(tokenHeader{}, encoded, rawSig); err != nil {
+		return nil, ErrInvalidSession
+	}
+	var p sessionPayload
+	if err := decodeSegment(encoded, &p); err != nil || p.Claims == nil {
+		return nil, ErrInvalidSession
+	}
+	if !s.now().Before(time.Unix(p.Exp, 0)) {
+		return nil, rejectClaims(p.Claims, ErrSessionExpired)
+	}
+	return p.Claims, nil
+}
+
+// rejected clears the cookie behind a tampered or expired session.
+func (s *Sessions) rejected(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidSession) || errors.Is(err, ErrSessionExpired) {
+		s.Clear(w)
+	}
+}
+
+// Middleware rejects requests without a valid session cookie with 401.
+func (s *Sessions) Middleware(next http.Handler) http.Handler {
+	return authMiddleware(s, nil, next)
+}
+
+// Handler returns the endpoint browsers log in and out at. A POST
+// authenticated by login, normally the JWT Validator, is answered with 204
+// and a session cookie carrying the token's claims; a DELETE clears the
+// cookie. The login's own failures get 401 as its middleware would give.
+func (s *Sessions) Handler(login Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			claims, err := login.Authenticate(r)
+			if err != nil {
+				apierr.Write(w, r, http.StatusUnauthorized, apierr.CodeUnauthorized, authErrorMessage(err))
+				return
+			}
+			if err := s.Issue(w, claims); err != nil {
+				apierr.Write(w, r, http.StatusInternalServerError, apierr.CodeInternal, "can't issue session")
+				return
+			}
+		case http.MethodDelete:
+			s.Clear(w)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			apierr.Write(w, r, http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionsValidate(t *testing.T) {
+	now := time.Now()
+	s := NewSessions("session-secret", SessionTTL(time.Hour), SessionFallbackSecrets("old-secret"))
+	s.now = func() time.Time { return now }
+	c, err := s.NewCookie(map[string]any{"sub": "user-1", "scope": "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.MaxAge != 3600 {
+		t.Fatalf("cookie attributes = %+v", c)
+	}
+
+	old := NewSessions("old-secret")
+	old.now = s.now
+	oldCookie, err := old.NewCookie(map[string]any{"sub": "user-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewSessions("other-secret")
+	forged, err := other.NewCookie(map[string]any{"sub": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(c.Value, ".")
+	otherPayload, _, _ := strings.Cut(forged.Value, ".")
+
+	tests := []struct {
+		name    string
+		value   string
+		at      time.Time
+		wantSub string
+		wantErr error
+	}{
+		{"valid", c.Value, now, "user-1", nil},
+		{"fallback secret", oldCookie.Value, now, "user-2", nil},
+		{"wrong secret", forged.Value, now, "", ErrInvalidSession},
+		{"tampered payload", otherPayload + "." + sig, now, "", ErrInvalidSession},
+		{"tampered signature", payload + ".AAAA", now, "", ErrInvalidSession},
+		{"no signature", payload, now, "", ErrInvalidSession},
+		{"bad base64", payload + ".!!!", now, "", ErrInvalidSession},
+		{"expired", c.Value, now.Add(time.Hour), "", ErrSessionExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return tt.at }
+			claims, err := s.Validate(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if sub, _ := claims["sub"].(string); sub != tt.wantSub {
+				t.Fatalf("sub = %q, want %q", sub, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestSessionsExpireWithToken(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewSessions("session-secret")
+	s.now = func() time.Time { return now }
+
+	c, err := s.NewCookie(map[string]any{"sub": "user-1", "exp": float64(now.Add(5 * time.Minute).Unix())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxAge != 300 || !c.Expires.Equal(now.Add(5*time.Minute)) {
+		t.Fatalf("MaxAge = %d, Expires = %v, want the token's 5 minutes", c.MaxAge, c.Expires)
+	}
+	s.now = func() time.Time { return now.Add(5 * time.Minute) }
+	if _, err := s.Validate(c.Value); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("session outlived its token: err = %v", err)
+	}
+
+	// A token outliving the TTL still gets the TTL.
+	s.now = func() time.Time { return now }
+	c, err = s.NewCookie(map[string]any{"exp": float64(now.Add(48 * time.Hour).Unix())})
+	if err != nil || c.MaxAge != 12*60*60 {
+		t.Fatalf("long-lived token: MaxAge = %d, err = %v, want the 12h TTL", c.MaxAge, err)
+	}
+}
+
+func TestSessionsMiddleware(t *testing.T) {
+	s := NewSessions("session-secret", SessionCookieName("sid"))
+	valid, err := s.NewCookie(map[string]any{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ttl = -time.Minute
+	expired, err := s.NewCookie(map[string]any{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := AnyOf(NewValidator(testSecret), s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		w.Write([]byte(claims["sub"].(string)))
+	}))
+	tests := []struct {
+		name      string
+		cookie    string
+		bearer    string
+		want      int
+		wantClear bool
+	}{
+		{"valid", valid.Value, "", http.StatusOK, false},
+		{"no cookie", "", "", http.StatusUnauthorized, false},
+		{"tampered", valid.Value + "x", "", http.StatusUnauthorized, true},
+		{"expired", expired.Value, "", http.StatusUnauthorized, true},
+		{"bad jwt leaves cookie", valid.Value, "not-a-token", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "sid", Value: tt.cookie})
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && rec.Body.String() != "user-1" {
+				t.Fatalf("body = %q, want the session's subject", rec.Body.String())
+			}
+			cleared := false
+			for _, c := range rec.Result().Cookies() {
+				cleared = cleared || (c.Name == "sid" && c.MaxAge < 0 && c.Value == "")
+			}
+			if cleared != tt.wantClear {
+				t.Fatalf("Set-Cookie = %q, want cleared %v", rec.Header().Values("Set-Cookie"), tt.wantClear)
+			}
+		})
+	}
+}
+
+func TestSessionsHandler(t *testing.T) {
+	s := NewSessions("session-secret")
+	h := s.Handler(NewValidator(testSecret))
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+hs256Token(t, map[string]any{"sub": "user-1"}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusNoContent || len(cookies) != 1 || cookies[0].Name != DefaultSessionCookie {
+		t.Fatalf("login: status = %d, cookies = %+v", rec.Code, cookies)
+	}
+	claims, err := s.Validate(cookies[0].Value)
+	if err != nil || claims["sub"] != "user-1" {
+		t.Fatalf("issued session: claims = %v, err = %v", claims, err)
+	}
+
+	// A session isn't a login credential, so it can't be renewed forever.
+	req = httptest.NewRequest(http.MethodPost, "/auth/session", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("login without a token: status = %d, cookies = %+v", rec.Code, rec.Result().Cookies())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/auth/session", nil))
+	cookies = rec.Result().Cookies()
+	if rec.Code != http.StatusNoContent || len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("logout: status = %d, cookies = %+v", rec.Code, cookies)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/session", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST, DELETE" {
+		t.Fatalf("GET: status = %d, Allow = %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestSessionsCookieTooLarge(t *testing.T) {
+	s := NewSessions("session-secret")
+	if _, err := s.NewCookie(map[string]any{"sub": strings.Repeat("x", maxSessionCookie)}); err == nil {
+		t.Fatal("oversized session: no error")
+	}
+}
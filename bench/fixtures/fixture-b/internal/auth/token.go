@@ -0,0 +1,27 @@
+package auth
+
+import "context"
+
+// Claims are a validated token's claims, as stored in the request context.
+type Claims = map[string]any
+
+// TokenValidator checks a raw bearer token and returns its claims. Validator
+// implements it for JWTs and IntrospectionValidator for opaque tokens; either
+// can back a Validator's Middleware through NewValidatorFor.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, raw string) (Claims, error)
+}
+
+// ValidateToken validates raw as a JWT; see Validate.
+func (v *Validator) ValidateToken(_ context.Context, raw string) (Claims, error) {
+	return v.Validate(raw)
+}
+
+// NewValidatorFor returns a Validator whose Middleware and Authenticate
+// check tokens with tv instead of as JWTs. Skip and the extractor options
+// apply as usual; options for JWT claims and keys have no effect.
+func NewValidatorFor(tv TokenValidator, opts ...Option) *Validator {
+	v := newValidator("", nil, opts)
+	v.tokens = tv
+	return v
+}
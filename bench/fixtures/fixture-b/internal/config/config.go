@@ -0,0 +1,845 @@
+// Package config loads the gateway's settings from an optional JSON file
+// and the environment.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/internal/handler"
+	"api-gateway/internal/logging"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/redis"
+)
+
+// Config is the gateway's complete configuration.
+type Config struct {
+	// Addr is the listen address, a TCP address or "unix:" and the path of
+	// a Unix domain socket. Defaults to ":8080".
+	Addr string `json:"addr"`
+	// Listen lists more addresses, of either kind, to serve alongside
+	// Addr, such as "unix:/var/run/gw.sock" for a sidecar.
+	Listen []string `json:"listen,omitempty"`
+	// UnixSocketMode is the permissions of socket files, in octal.
+	// Defaults to "0660", so only the gateway's user and group connect.
+	UnixSocketMode string `json:"unix_socket_mode,omitempty"`
+	// ReusePort sets SO_REUSEPORT on the TCP listeners, so that several
+	// gateway processes can share a port and the kernel spreads new
+	// connections across them. Where the option isn't supported, the
+	// gateway listens without it.
+	ReusePort bool     `json:"reuse_port,omitempty"`
+	TLS       TLS      `json:"tls"`
+	Timeouts  Timeouts `json:"timeouts"`
+	Auth      Auth     `json:"auth"`
+	// TrustedProxies lists the CIDRs or addresses of load balancers whose
+	// X-Forwarded-For is believed; see middleware.RealIP.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// AllowedHosts, if set, limits the Host headers the gateway answers;
+	// see middleware.AllowedHosts.
+	AllowedHosts []string  `json:"allowed_hosts,omitempty"`
+	RateLimit    RateLimit `json:"rate_limit"`
+	// MaxInFlight, if set, caps the proxied requests in flight at once,
+	// refusing the excess with 503; see middleware.MaxInFlight.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+	// MaxConnsPerIP, if set, caps the connections one client address may
+	// hold open at once, closing the excess on accept; see
+	// connlimit.Limiter. Trusted proxies are exempt.
+	MaxConnsPerIP int `json:"max_conns_per_ip,omitempty"`
+	// MaxHeaderBytes caps the size of a request's line and headers;
+	// larger requests get 431. Defaults to 64KB.
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxURILength caps the length of a request's path and query; longer
+	// requests get 414 before they are logged. Defaults to 8KB.
+	MaxURILength int `json:"max_uri_length"`
+	// LogLevel is the least severe log line written, "debug", "info",
+	// "warn" or "error"; access log entries count as info, or warn and
+	// error for 4xx and 5xx responses. Defaults to "info". The admin API
+	// and SIGUSR1/SIGUSR2 change it while the gateway runs.
+	LogLevel string `json:"log_level"`
+	// CORS lists the CORS policies of route groups by path prefix. The
+	// longest matching prefix's policy applies; without a "/" policy the
+	// rest of the API allows any origin, as middleware.CORS does.
+	CORS      []CORSPolicy `json:"cors,omitempty"`
+	Tracing   Tracing      `json:"tracing"`
+	AccessLog AccessLog    `json:"access_log"`
+	Debug     Debug        `json:"debug"`
+	Admin     Admin        `json:"admin"`
+	Warmup    Warmup       `json:"warmup"`
+	// Middleware, if set, replaces the gateway's middleware stacks.
+	Middleware Middleware `json:"middleware"`
+	// Routes is the routing table. RoutesFile, if set, replaces it with the
+	// rules in that file.
+	Routes     []handler.Rule `json:"routes,omitempty"`
+	RoutesFile string         `json:"routes_file,omitempty"`
+}
+
+// TLS enables HTTPS when both CertFile and KeyFile are set.
+type TLS struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// RedirectAddr, if set with TLS enabled, serves redirects to HTTPS.
+	RedirectAddr string `json:"redirect_addr,omitempty"`
+	// ClientCAFile, if set, enables mutual TLS: client certificates are
+	// verified against the PEM-encoded CAs in the file. ClientAuth is
+	// "require", the default, which refuses connections without a valid
+	// certificate during the handshake, or "verify_if_given", which admits
+	// them and leaves routes to demand one with client_names.
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	ClientAuth   string `json:"client_auth,omitempty"`
+}
+
+// Enabled reports whether TLS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// ClientAuthType returns the tls.Config.ClientAuth for ClientAuth.
+func (t TLS) ClientAuthType() tls.ClientAuthType {
+	if t.ClientAuth == "verify_if_given" {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
+// Timeouts bounds each phase of a connection's life. See the README for
+// what each covers.
+type Timeouts struct {
+	ReadHeader    handler.Duration `json:"read_header"`
+	Read          handler.Duration `json:"read"`
+	Write         handler.Duration `json:"write"`
+	Idle          handler.Duration `json:"idle"`
+	Request       handler.Duration `json:"request"`
+	ShutdownDelay handler.Duration `json:"shutdown_delay"`
+	Shutdown      handler.Duration `json:"shutdown"`
+}
+
+// Auth configures request authentication.
+type Auth struct {
+	// JWTSecret verifies HS256 tokens. It is required unless API keys are
+	// configured or Disabled is set, so a missing secret fails the deploy
+	// instead of leaving every request unauthenticated.
+	JWTSecret string `json:"jwt_secret,omitempty"`
+	// JWTFallbackSecrets are previous secrets still accepted, but never
+	// signed with, while JWTSecret is rotated; see
+	// auth.WithFallbackSecrets.
+	JWTFallbackSecrets []string `json:"jwt_fallback_secrets,omitempty"`
+	// APIKeysFile, if set, also accepts X-API-Key credentials from the file;
+	// see auth.LoadAPIKeys.
+	APIKeysFile string `json:"api_keys_file,omitempty"`
+	// AuditLog, if set, records authentication decisions as JSON lines in
+	// the named file, or on stdout or stderr for "stdout" and "stderr";
+	// see auth.AuditLog. Denials are always recorded, allows only with
+	// AuditAllows.
+	AuditLog    string `json:"audit_log,omitempty"`
+	AuditAllows bool   `json:"audit_allows,omitempty"`
+	// ClaimHeaders maps claims to headers sent upstream, such as
+	// {"sub": "X-User-ID"}; clients' own copies of those headers are
+	// dropped. See handler.WithClaimHeaders.
+	ClaimHeaders map[string]string `json:"claim_headers,omitempty"`
+	// Session, once given a secret, lets browsers trade a JWT for a signed
+	// session cookie at /auth/session, accepted in its place.
+	Session Session `json:"session"`
+	// Disabled runs the gateway without authentication, for local
+	// development. It can't be combined with any credential, nor with
+	// routes that require a scope.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// Session configures signed session cookies; see auth.Sessions.
+type Session struct {
+	// Secret signs the cookies. It should be a value of its own, not
+	// jwt_secret.
+	Secret string `json:"secret,omitempty"`
+	// CookieName defaults to auth.DefaultSessionCookie.
+	CookieName string `json:"cookie_name,omitempty"`
+	// TTL is how long a session lasts, 12h if unset.
+	TTL handler.Duration `json:"ttl,omitempty"`
+	// Insecure leaves off the cookie's Secure attribute, for local
+	// development over plain HTTP.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// Enabled reports whether session cookies are issued and accepted.
+func (s Session) Enabled() bool { return s.Secret != "" }
+
+// RateLimit limits each client to RPS requests per second with bursts of
+// up to Burst.
+type RateLimit struct {
+	RPS   int `json:"rps"`
+	Burst int `json:"burst"`
+	// RedisURL, if set, shares the limit between replicas through Redis
+	// as Burst requests per Burst/RPS-second window. Otherwise each replica
+	// limits on its own.
+	RedisURL string `json:"redis_url,omitempty"`
+	// FailOpen admits requests while Redis is unreachable instead of
+	// refusing them with 503.
+	FailOpen bool `json:"fail_open,omitempty"`
+	// Tiers are limits of their own for authenticated clients whose
+	// TierClaim, "tier" by default, names one, such as {"pro": {"rps":
+	// 100, "burst": 200}}; everyone else gets RPS and Burst. See
+	// middleware.ClaimLimit.
+	Tiers     map[string]middleware.Limit `json:"tiers,omitempty"`
+	TierClaim string                      `json:"tier_claim,omitempty"`
+}
+
+// Window is the fixed window a shared store counts Burst requests in.
+func (rl RateLimit) Window() time.Duration {
+	return time.Duration(rl.Burst) * time.Second / time.Duration(rl.RPS)
+}
+
+// CORSPolicy is the CORS policy for requests under PathPrefix.
+type CORSPolicy struct {
+	PathPrefix     string   `json:"path_prefix"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods and AllowedHeaders default to those of
+	// middleware.CORS.
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge handler.Duration `json:"max_age,omitempty"`
+}
+
+// Middleware returns the policy as middleware.
+func (p CORSPolicy) Middleware() middleware.Middleware {
+	cfg := middleware.CORSConfig{
+		AllowedOrigins:   p.AllowedOrigins,
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           time.Duration(p.MaxAge),
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Content-Type", "Authorization"}
+	}
+	return middleware.NewCORS(cfg)
+}
+
+// Tracing exports request spans to an OpenTelemetry collector when
+// OTLPEndpoint is set.
+type Tracing struct {
+	// OTLPEndpoint is the collector's OTLP/HTTP base URL, e.g.
+	// "http://otel-collector:4318".
+	OTLPEndpoint string `json:"otlp_endpoint,omitempty"`
+	// ServiceName is reported as service.name. Defaults to "api-gateway".
+	ServiceName string `json:"service_name,omitempty"`
+	// SampleRatio is the fraction of new traces recorded. Defaults to 1.
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+// AccessLog samples the access log to cut its volume. Responses other than
+// 2xx are always logged; see middleware.NewSampledLogger.
+type AccessLog struct {
+	// SuccessSampleRate is the fraction of 2xx responses logged. Defaults
+	// to 1, logging every request.
+	SuccessSampleRate float64 `json:"success_sample_rate"`
+	// SlowThreshold, if set, logs every request taking at least this long
+	// while 2xx responses are sampled.
+	SlowThreshold handler.Duration `json:"slow_threshold,omitempty"`
+}
+
+// Debug holds troubleshooting switches, all off by default.
+type Debug struct {
+	// DumpBodies logs request and response bodies, up to DumpMaxBytes
+	// each (default 4KB), for routes with dump_body set. RedactFields
+	// names JSON and form fields to mask in addition to credentials; see
+	// middleware.NewDumpBody.
+	DumpBodies   bool     `json:"dump_bodies,omitempty"`
+	DumpMaxBytes int      `json:"dump_max_bytes,omitempty"`
+	RedactFields []string `json:"redact_fields,omitempty"`
+	// ServerTiming adds a Server-Timing header showing time spent in
+	// auth, the upstream, and the gateway itself.
+	ServerTiming bool `json:"server_timing,omitempty"`
+}
+
+// Admin starts the admin API on a listener of its own when Addr is set;
+// see handler.NewAdmin.
+type Admin struct {
+	// Addr is the admin listen address, such as "127.0.0.1:9090". It
+	// must differ from the gateway's own.
+	Addr string `json:"addr,omitempty"`
+	// Token is required as a Bearer token on admin requests. It may only
+	// be left empty when Addr is a loopback address.
+	Token string `json:"token,omitempty"`
+}
+
+// Middleware lists the middleware to run by the names they are
+// registered under; see middleware.Registry. A stack left empty keeps the
+// gateway's default, which the README lists.
+type Middleware struct {
+	// Global wraps every request, the operational endpoints' included.
+	Global []middleware.Spec `json:"global,omitempty"`
+	// API wraps the proxied routes, inside Global.
+	API []middleware.Spec `json:"api,omitempty"`
+}
+
+// Warmup answers proxied requests with 503 and Retry-After after startup,
+// until Duration has passed or every readiness check passes; see
+// middleware.NewWarmup. It is off while Duration is zero.
+type Warmup struct {
+	Duration handler.Duration `json:"duration,omitempty"`
+	// PathPrefixes limits warmup to the routes under these prefixes.
+	// Empty means every proxied route.
+	PathPrefixes []string `json:"path_prefixes,omitempty"`
+}
+
+// operationalPaths are the gateway's own endpoints, which sit outside the
+// API's middleware and so can't take a CORS policy.
+var operationalPaths = []string{"/healthz", "/readyz", "/metrics"}
+
+// minAdminTokenLen is the shortest admin token accepted, so a guessable
+// password can't stand in for a generated token.
+const minAdminTokenLen = 32
+
+// minSessionSecretLen is the shortest session secret accepted.
+const minSessionSecretLen = 32
+
+// maxSocketPath is the longest Unix socket path every platform takes;
+// sun_path holds 104 bytes on the BSDs, with the terminating NUL.
+const maxSocketPath = 103
+
+// UnixSocket reports whether addr names a Unix domain socket,
+// "unix:/path/to.sock", and returns its path.
+func UnixSocket(addr string) (path string, ok bool) {
+	return strings.CutPrefix(addr, "unix:")
+}
+
+// SocketMode returns UnixSocketMode parsed. Validate has already rejected
+// a malformed mode.
+func (cfg *Config) SocketMode() fs.FileMode {
+	mode, _ := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	return fs.FileMode(mode)
+}
+
+// Level returns LogLevel parsed. Validate has already rejected unknown
+// levels.
+func (cfg *Config) Level() logging.Level {
+	l, _ := logging.ParseLevel(cfg.LogLevel)
+	return l
+}
+
+// TrustedPrefixes returns TrustedProxies parsed for middleware.RealIP.
+// Validate has already rejected malformed entries.
+func (cfg *Config) TrustedPrefixes() []netip.Prefix {
+	prefixes, _ := middleware.ParsePrefixes(strings.Join(cfg.TrustedProxies, ","))
+	return prefixes
+}
+
+// Default returns the configuration used when neither file nor
+// environment says otherwise.
+func Default() *Config {
+	return &Config{
+		Addr:           ":8080",
+		UnixSocketMode: "0660",
+		RateLimit:      RateLimit{RPS: 50, Burst: 100, TierClaim: "tier"},
+		Tracing:        Tracing{ServiceName: "api-gateway", SampleRatio: 1},
+		AccessLog:      AccessLog{SuccessSampleRate: 1},
+		LogLevel:       "info",
+		MaxHeaderBytes: 64 << 10,
+		MaxURILength:   middleware.DefaultMaxURILength,
+		Timeouts: Timeouts{
+			ReadHeader:    handler.Duration(5 * time.Second),
+			Read:          handler.Duration(10 * time.Second),
+			Write:         handler.Duration(30 * time.Second),
+			Idle:          handler.Duration(60 * time.Second),
+			Request:       handler.Duration(25 * time.Second),
+			ShutdownDelay: handler.Duration(5 * time.Second),
+			Shutdown:      handler.Duration(15 * time.Second),
+		},
+	}
+}
+
+// Load builds the configuration from the file named by CONFIG_FILE, if
+// any, then applies environment overrides and validates the result.
+func Load() (*Config, error) {
+	return load(os.Getenv)
+}
+
+func load(getenv func(string) string) (*Config, error) {
+	cfg := Default()
+	if path := getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadRoutes(getenv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile merges the JSON file at path over cfg. Unknown keys are
+// rejected so a misspelt setting doesn't silently fall back to its default.
+func (cfg *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) applyEnv(getenv func(string) string) error {
+	if port := getenv("PORT"); port != "" {
+		cfg.Addr = ":" + port
+	}
+	for env, dst := range map[string]*string{
+		"TLS_CERT_FILE":               &cfg.TLS.CertFile,
+		"TLS_KEY_FILE":                &cfg.TLS.KeyFile,
+		"HTTP_REDIRECT_ADDR":          &cfg.TLS.RedirectAddr,
+		"TLS_CLIENT_CA_FILE":          &cfg.TLS.ClientCAFile,
+		"TLS_CLIENT_AUTH":             &cfg.TLS.ClientAuth,
+		"JWT_SECRET":                  &cfg.Auth.JWTSecret,
+		"API_KEYS_FILE":               &cfg.Auth.APIKeysFile,
+		"AUTH_AUDIT_LOG":              &cfg.Auth.AuditLog,
+		"ROUTES_FILE":                 &cfg.RoutesFile,
+		"LOG_LEVEL":                   &cfg.LogLevel,
+		"RATE_LIMIT_REDIS_URL":        &cfg.RateLimit.RedisURL,
+		"RATE_LIMIT_TIER_CLAIM":       &cfg.RateLimit.TierClaim,
+		"OTEL_EXPORTER_OTLP_ENDPOINT": &cfg.Tracing.OTLPEndpoint,
+		"OTEL_SERVICE_NAME":           &cfg.Tracing.ServiceName,
+		"ADMIN_ADDR":                  &cfg.Admin.Addr,
+		"ADMIN_TOKEN":                 &cfg.Admin.Token,
+		"UNIX_SOCKET_MODE":            &cfg.UnixSocketMode,
+		"SESSION_SECRET":              &cfg.Auth.Session.Secret,
+		"SESSION_COOKIE_NAME":         &cfg.Auth.Session.CookieName,
+	} {
+		if v := getenv(env); v != "" {
+			*dst = v
+		}
+	}
+	if v := getenv("LISTEN"); v != "" {
+		cfg.Listen = strings.Split(v, ",")
+	}
+	if v := getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.TrustedProxies = strings.Split(v, ",")
+	}
+	if v := getenv("JWT_FALLBACK_SECRETS"); v != "" {
+		cfg.Auth.JWTFallbackSecrets = strings.Split(v, ",")
+	}
+	if v := getenv("CLAIM_HEADERS"); v != "" {
+		cfg.Auth.ClaimHeaders = map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			claim, header, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("CLAIM_HEADERS: want claim=Header pairs, got %q", pair)
+			}
+			cfg.Auth.ClaimHeaders[strings.TrimSpace(claim)] = strings.TrimSpace(header)
+		}
+	}
+	if v := getenv("RATE_LIMIT_TIERS"); v != "" {
+		cfg.RateLimit.Tiers = map[string]middleware.Limit{}
+		for _, entry := range strings.Split(v, ",") {
+			name, limit, ok := strings.Cut(entry, "=")
+			rps, burst, ok2 := strings.Cut(limit, ":")
+			r, err := strconv.Atoi(rps)
+			b, err2 := strconv.Atoi(burst)
+			if !ok || !ok2 || err != nil || err2 != nil {
+				return fmt.Errorf("RATE_LIMIT_TIERS: want name=rps:burst entries, got %q", entry)
+			}
+			cfg.RateLimit.Tiers[strings.TrimSpace(name)] = middleware.Limit{RPS: r, Burst: b}
+		}
+	}
+	if v := getenv("ALLOWED_HOSTS"); v != "" {
+		cfg.AllowedHosts = strings.Split(v, ",")
+	}
+	if v := getenv("ACCESS_LOG_SUCCESS_SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("ACCESS_LOG_SUCCESS_SAMPLE_RATE: invalid number %q", v)
+		}
+		cfg.AccessLog.SuccessSampleRate = rate
+	}
+	if v := getenv("MAX_IN_FLIGHT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_IN_FLIGHT: invalid number %q", v)
+		}
+		cfg.MaxInFlight = n
+	}
+	if v := getenv("MAX_CONNS_PER_IP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_CONNS_PER_IP: invalid number %q", v)
+		}
+		cfg.MaxConnsPerIP = n
+	}
+	if v := getenv("MAX_HEADER_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_HEADER_BYTES: invalid number %q", v)
+		}
+		cfg.MaxHeaderBytes = n
+	}
+	if v := getenv("MAX_URI_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_URI_LENGTH: invalid number %q", v)
+		}
+		cfg.MaxURILength = n
+	}
+	if v := getenv("OTEL_TRACES_SAMPLER_ARG"); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("OTEL_TRACES_SAMPLER_ARG: invalid number %q", v)
+		}
+		cfg.Tracing.SampleRatio = ratio
+	}
+	for env, dst := range map[string]*bool{
+		"RATE_LIMIT_FAIL_OPEN": &cfg.RateLimit.FailOpen,
+		"AUTH_AUDIT_ALLOWS":    &cfg.Auth.AuditAllows,
+		"DEBUG_DUMP_BODIES":    &cfg.Debug.DumpBodies,
+		"SERVER_TIMING":        &cfg.Debug.ServerTiming,
+		"SESSION_INSECURE":     &cfg.Auth.Session.Insecure,
+		"AUTH_DISABLED":        &cfg.Auth.Disabled,
+		"REUSE_PORT":           &cfg.ReusePort,
+	} {
+		raw := getenv(env)
+		if raw == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", env, raw)
+		}
+		*dst = on
+	}
+
+	for env, dst := range map[string]*handler.Duration{
+		"READ_HEADER_TIMEOUT":       &cfg.Timeouts.ReadHeader,
+		"READ_TIMEOUT":              &cfg.Timeouts.Read,
+		"WRITE_TIMEOUT":             &cfg.Timeouts.Write,
+		"IDLE_TIMEOUT":              &cfg.Timeouts.Idle,
+		"REQUEST_TIMEOUT":           &cfg.Timeouts.Request,
+		"SHUTDOWN_DELAY":            &cfg.Timeouts.ShutdownDelay,
+		"SHUTDOWN_TIMEOUT":          &cfg.Timeouts.Shutdown,
+		"WARMUP_DURATION":           &cfg.Warmup.Duration,
+		"ACCESS_LOG_SLOW_THRESHOLD": &cfg.AccessLog.SlowThreshold,
+		"SESSION_TTL":               &cfg.Auth.Session.TTL,
+	} {
+		raw := getenv(env)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", env, raw)
+		}
+		*dst = handler.Duration(d)
+	}
+	return nil
+}
+
+// loadRoutes resolves the routing table: RoutesFile if set, else the
+// file's routes, else the legacy UPSTREAM_*_URL variables.
+func (cfg *Config) loadRoutes(getenv func(string) string) error {
+	if cfg.RoutesFile != "" {
+		rules, err := handler.LoadRules(cfg.RoutesFile)
+		if err != nil {
+			return err
+		}
+		cfg.Routes = rules
+		return nil
+	}
+	if len(cfg.Routes) > 0 {
+		return nil
+	}
+	if u := getenv("UPSTREAM_USERS_URL"); u != "" {
+		cfg.Routes = append(cfg.Routes, handler.Rule{PathPrefix: "/api/v1/users", UpstreamURL: u})
+	}
+	if u := getenv("UPSTREAM_SERVICES_URL"); u != "" {
+		cfg.Routes = append(cfg.Routes, handler.Rule{PathPrefix: "/api/v1/services", UpstreamURL: u, Scope: "services:read"})
+	}
+	return nil
+}
+
+// Validate reports every problem with cfg at once, so a bad deploy fails
+// at startup with the full list rather than one error per attempt.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("addr: must not be empty"))
+	} else if err := validateListenAddr(cfg.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+	for i, addr := range cfg.Listen {
+		switch {
+		case addr == cfg.Addr || slices.Contains(cfg.Listen[:i], addr):
+			errs = append(errs, fmt.Errorf("listen: %q is listed twice", addr))
+		default:
+			if err := validateListenAddr(addr); err != nil {
+				errs = append(errs, fmt.Errorf("listen: %w", err))
+			}
+		}
+	}
+	if mode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32); err != nil || mode > 0o777 {
+		errs = append(errs, fmt.Errorf("unix_socket_mode: want octal permissions such as 0660, got %q", cfg.UnixSocketMode))
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if cfg.TLS.RedirectAddr != "" && !cfg.TLS.Enabled() {
+		errs = append(errs, errors.New("tls: redirect_addr requires cert_file and key_file"))
+	}
+	if _, unix := UnixSocket(cfg.Addr); unix && cfg.TLS.RedirectAddr != "" {
+		errs = append(errs, errors.New("tls: redirect_addr requires a TCP addr to redirect to"))
+	}
+	switch tc := cfg.TLS; {
+	case tc.ClientCAFile != "" && !tc.Enabled():
+		errs = append(errs, errors.New("tls: client_ca_file requires cert_file and key_file"))
+	case tc.ClientAuth != "" && tc.ClientCAFile == "":
+		errs = append(errs, errors.New("tls: client_auth requires client_ca_file"))
+	case tc.ClientAuth != "" && tc.ClientAuth != "require" && tc.ClientAuth != "verify_if_given":
+		errs = append(errs, fmt.Errorf(`tls.client_auth: want "require" or "verify_if_given", got %q`, tc.ClientAuth))
+	}
+	t := cfg.Timeouts
+	for _, d := range []struct {
+		name string
+		d    handler.Duration
+	}{
+		{"read_header", t.ReadHeader}, {"read", t.Read}, {"write", t.Write}, {"idle", t.Idle},
+		{"request", t.Request}, {"shutdown_delay", t.ShutdownDelay}, {"shutdown", t.Shutdown},
+	} {
+		if d.d < 0 {
+			errs = append(errs, fmt.Errorf("timeouts.%s: must not be negative", d.name))
+		}
+	}
+	if cfg.RateLimit.RPS <= 0 || cfg.RateLimit.Burst <= 0 {
+		errs = append(errs, errors.New("rate_limit: rps and burst must be positive"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.RateLimit.Tiers)) {
+		if l := cfg.RateLimit.Tiers[name]; name == "" || l.RPS <= 0 || l.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.tiers.%s: needs a name, and positive rps and burst", name))
+		}
+	}
+	if len(cfg.RateLimit.Tiers) > 0 && cfg.RateLimit.TierClaim == "" {
+		errs = append(errs, errors.New("rate_limit.tier_claim: required with tiers"))
+	}
+	if cfg.MaxInFlight < 0 {
+		errs = append(errs, errors.New("max_in_flight: must not be negative"))
+	}
+	if cfg.MaxConnsPerIP < 0 {
+		errs = append(errs, errors.New("max_conns_per_ip: must not be negative"))
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("max_header_bytes: must be positive"))
+	}
+	if cfg.MaxURILength <= 0 {
+		errs = append(errs, errors.New("max_uri_length: must be positive"))
+	}
+	if u := cfg.RateLimit.RedisURL; u != "" {
+		if _, err := redis.NewClient(u, 0); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit.redis_url: %w", err))
+		}
+	}
+	if endpoint := cfg.Tracing.OTLPEndpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("tracing.otlp_endpoint: invalid URL %q", endpoint))
+		}
+	}
+	if r := cfg.Tracing.SampleRatio; r < 0 || r > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio: must be between 0 and 1"))
+	}
+	if r := cfg.AccessLog.SuccessSampleRate; r < 0 || r > 1 {
+		errs = append(errs, errors.New("access_log.success_sample_rate: must be between 0 and 1"))
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if cfg.AccessLog.SlowThreshold < 0 {
+		errs = append(errs, errors.New("access_log.slow_threshold: must not be negative"))
+	}
+	if cfg.Debug.DumpMaxBytes < 0 {
+		errs = append(errs, errors.New("debug.dump_max_bytes: must not be negative"))
+	}
+	seen := map[string]bool{}
+	for _, p := range cfg.CORS {
+		prefix := strings.TrimSuffix(p.PathPrefix, "/")
+		switch {
+		case !strings.HasPrefix(p.PathPrefix, "/"):
+			errs = append(errs, fmt.Errorf("cors: path_prefix %q must start with /", p.PathPrefix))
+		case seen[prefix]:
+			errs = append(errs, fmt.Errorf("cors: duplicate path_prefix %q", p.PathPrefix))
+		case slices.Contains(operationalPaths, prefix),
+			cfg.Auth.Session.Enabled() && prefix == handler.SessionPath:
+			errs = append(errs, fmt.Errorf("cors: path_prefix %q is a gateway endpoint", p.PathPrefix))
+		case len(p.AllowedOrigins) == 0:
+			errs = append(errs, fmt.Errorf("cors: policy for %q allows no origins", p.PathPrefix))
+		case p.MaxAge < 0:
+			errs = append(errs, fmt.Errorf("cors: max_age for %q must not be negative", p.PathPrefix))
+		}
+		seen[prefix] = true
+	}
+	if cfg.Warmup.Duration < 0 {
+		errs = append(errs, errors.New("warmup.duration: must not be negative"))
+	}
+	for _, p := range cfg.Warmup.PathPrefixes {
+		if !strings.HasPrefix(p, "/") {
+			errs = append(errs, fmt.Errorf("warmup: path_prefix %q must start with /", p))
+		} else if slices.Contains(operationalPaths, strings.TrimSuffix(p, "/")) {
+			errs = append(errs, fmt.Errorf("warmup: path_prefix %q is a gateway endpoint", p))
+		}
+	}
+	for _, stack := range []struct {
+		name  string
+		specs []middleware.Spec
+	}{{"global", cfg.Middleware.Global}, {"api", cfg.Middleware.API}} {
+		for i, spec := range stack.specs {
+			if spec.Name == "" {
+				errs = append(errs, fmt.Errorf("middleware.%s[%d]: missing name", stack.name, i))
+			}
+		}
+	}
+	if a := cfg.Admin; a.Addr != "" {
+		host, _, err := net.SplitHostPort(a.Addr)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("admin.addr: %w", err))
+		case a.Addr == cfg.Addr:
+			errs = append(errs, errors.New("admin.addr: must differ from addr"))
+		case a.Token == "" && !isLoopback(host):
+			errs = append(errs, errors.New("admin.token: required unless admin.addr is a loopback address"))
+		}
+		if a.Token != "" && len(a.Token) < minAdminTokenLen {
+			errs = append(errs, fmt.Errorf("admin.token: must be at least %d characters", minAdminTokenLen))
+		}
+	}
+	if _, err := middleware.ParsePrefixes(strings.Join(cfg.TrustedProxies, ",")); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	if err := middleware.ValidateHosts(cfg.AllowedHosts); err != nil {
+		errs = append(errs, fmt.Errorf("allowed_hosts: %w", err))
+	}
+	switch a := cfg.Auth; {
+	case a.Disabled && (a.JWTSecret != "" || a.APIKeysFile != "" || a.Session.Enabled()):
+		errs = append(errs, errors.New("auth.disabled: set alongside credentials"))
+	case a.Disabled:
+		for _, rule := range cfg.Routes {
+			if rule.Scope != "" {
+				errs = append(errs, fmt.Errorf("auth.disabled: route %s requires scope %q", rule.PathPrefix, rule.Scope))
+			}
+		}
+	case a.JWTSecret == "" && a.APIKeysFile == "":
+		errs = append(errs, errors.New("auth.jwt_secret: required unless api_keys_file or auth.disabled is set"))
+	}
+	if len(cfg.Auth.JWTFallbackSecrets) > 0 && cfg.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("auth.jwt_fallback_secrets: set without jwt_secret"))
+	}
+	if slices.Contains(cfg.Auth.JWTFallbackSecrets, "") {
+		errs = append(errs, errors.New("auth.jwt_fallback_secrets: empty secret"))
+	}
+	switch sc := cfg.Auth.Session; {
+	case !sc.Enabled() && (sc.CookieName != "" || sc.TTL != 0 || sc.Insecure):
+		errs = append(errs, errors.New("auth.session: set without secret"))
+	case sc.Enabled() && len(sc.Secret) < minSessionSecretLen:
+		errs = append(errs, fmt.Errorf("auth.session.secret: must be at least %d characters", minSessionSecretLen))
+	case sc.Enabled() && sc.Secret == cfg.Auth.JWTSecret:
+		errs = append(errs, errors.New("auth.session.secret: must differ from jwt_secret"))
+	case sc.CookieName != "" && (&http.Cookie{Name: sc.CookieName, Value: "x"}).Valid() != nil:
+		errs = append(errs, fmt.Errorf("auth.session.cookie_name: invalid cookie name %q", sc.CookieName))
+	case sc.TTL < 0:
+		errs = append(errs, errors.New("auth.session.ttl: must not be negative"))
+	}
+	if cfg.Auth.Session.Enabled() && cfg.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("auth.session: requires jwt_secret to log in with"))
+	}
+	if err := handler.ValidateClaimHeaders(cfg.Auth.ClaimHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("auth.claim_headers: %w", err))
+	}
+	if err := handler.ValidateRules(cfg.Routes); err != nil {
+		errs = append(errs, fmt.Errorf("routes: %w", err))
+	}
+	for _, rule := range cfg.Routes {
+		if len(rule.ClientNames) > 0 && cfg.TLS.ClientCAFile == "" {
+			errs = append(errs, fmt.Errorf("routes: route %q: client_names requires tls.client_ca_file", rule.PathPrefix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateListenAddr checks an Addr or Listen entry.
+func validateListenAddr(addr string) error {
+	if path, ok := UnixSocket(addr); ok {
+		switch {
+		case path == "":
+			return fmt.Errorf("%q: missing socket path", addr)
+		case len(path) > maxSocketPath:
+			return fmt.Errorf("%q: socket path longer than %d bytes", addr, maxSocketPath)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return err
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// Redacted returns a copy of cfg safe to display: secrets are masked, as
+// are passwords in URLs.
+func (cfg *Config) Redacted() *Config {
+	c := *cfg
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	mask(&c.Auth.JWTSecret)
+	c.Auth.JWTFallbackSecrets = slices.Clone(cfg.Auth.JWTFallbackSecrets)
+	for i := range c.Auth.JWTFallbackSecrets {
+		mask(&c.Auth.JWTFallbackSecrets[i])
+	}
+	mask(&c.Auth.Session.Secret)
+	mask(&c.Admin.Token)
+	c.RateLimit.RedisURL = redactURL(c.RateLimit.RedisURL)
+	c.Tracing.OTLPEndpoint = redactURL(c.Tracing.OTLPEndpoint)
+	c.Routes = make([]handler.Rule, len(cfg.Routes))
+	for i, rule := range cfg.Routes {
+		c.Routes[i] = rule.Redacted()
+	}
+	return &c
+}
+
+const redacted = "REDACTED"
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/handler"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(env(map[string]string{"JWT_SECRET": "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" || time.Duration(cfg.Timeouts.Write) != 30*time.Second || cfg.TLS.Enabled() {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+}
+
+func TestLoadFileWithEnvOverrides(t *testing.T) {
+	path := writeFile(t, "gateway.json", `{
+		"addr": ":9000",
+		"timeouts": {"write": "45s", "request": "40s"},
+		"auth": {"jwt_secret": "from-file"},
+		"trusted_proxies": ["10.0.0.0/8"],
+		"routes": [{"path_prefix": "/api", "upstream_url": "http://users:3001"}]
+	}`)
+	cfg, err := load(env(map[string]string{
+		"CONFIG_FILE":   path,
+		"JWT_SECRET":    "from-env",
+		"WRITE_TIMEOUT": "50s",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9000" {
+		t.Errorf("Addr = %q, want the file's", cfg.Addr)
+	}
+	if cfg.Auth.JWTSecret != "from-env" {
+		t.Errorf("JWTSecret = %q, want the env override", cfg.Auth.JWTSecret)
+	}
+	if time.Duration(cfg.Timeouts.Write) != 50*time.Second || time.Duration(cfg.Timeouts.Request) != 40*time.Second {
+		t.Errorf("timeouts = %+v", cfg.Timeouts)
+	}
+	if time.Duration(cfg.Timeouts.Idle) != 60*time.Second {
+		t.Errorf("unset timeout lost its default: %v", time.Duration(cfg.Timeouts.Idle))
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].UpstreamURL != "http://users:3001" || len(cfg.TrustedPrefixes()) != 1 {
+		t.Errorf("routes = %+v, proxies = %v", cfg.Routes, cfg.TrustedProxies)
+	}
+}
+
+func TestLoadRouteSources(t *testing.T) {
+	routes := writeFile(t, "routes.json", `[{"path_prefix":"/from-file","upstream_url":"http://a:1"}]`)
+	cfg, err := load(env(map[string]string{"ROUTES_FILE": routes, "UPSTREAM_USERS_URL": "http://u:1", "JWT_SECRET": "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].PathPrefix != "/from-file" {
+		t.Fatalf("ROUTES_FILE: routes = %+v", cfg.Routes)
+	}
+
+	cfg, err = load(env(map[string]string{"UPSTREAM_USERS_URL": "http://u:1", "UPSTREAM_SERVICES_URL": "http://s:1", "JWT_SECRET": "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Routes) != 2 || cfg.Routes[1].Scope != "services:read" {
+		t.Fatalf("UPSTREAM_*_URL: routes = %+v", cfg.Routes)
+	}
+}
+
+func TestLoadRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"unknown key", `{"adr": ":1"}`, nil, `unknown field "adr"`},
+		{"malformed upstream", `{"routes":[{"path_prefix":"/api","upstream_url":"users:3001"}]}`, nil, "invalid upstream URL"},
+		{"half TLS", `{"tls":{"cert_file":"c.pem"}}`, nil, "cert_file and key_file"},
+		{"negative timeout", `{"timeouts":{"idle":"-1s"}}`, nil, "timeouts.idle"},
+		{"bad proxy", `{}`, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/99"}, "trusted_proxies"},
+		{"bad env duration", `{}`, map[string]string{"READ_TIMEOUT": "soon"}, "READ_TIMEOUT"},
+		{"bad allowed host", `{"allowed_hosts":["example.com:443"]}`, nil, "allowed_hosts"},
+		{"bad redis url", `{"rate_limit":{"rps":1,"burst":1,"redis_url":"localhost:6379"}}`, nil, "rate_limit.redis_url"},
+		{"zero rate", `{"rate_limit":{"rps":0,"burst":1}}`, nil, "rate_limit"},
+		{"bad otlp endpoint", `{"tracing":{"otlp_endpoint":"collector:4318"}}`, nil, "tracing.otlp_endpoint"},
+		{"sample ratio out of range", `{}`, map[string]string{"OTEL_TRACES_SAMPLER_ARG": "2"}, "tracing.sample_ratio"},
+		{"bad env boolean", `{}`, map[string]string{"DEBUG_DUMP_BODIES": "maybe"}, "DEBUG_DUMP_BODIES"},
+		{"public admin without token", `{"admin":{"addr":":9090"}}`, nil, "admin.token"},
+		{"short admin token", `{"admin":{"addr":":9090","token":"hunter2"}}`, nil, "at least 32"},
+		{"admin on gateway addr", `{"addr":":8080","admin":{"addr":":8080"}}`, nil, "must differ"},
+		{"relative cors prefix", `{"cors":[{"path_prefix":"partner","allowed_origins":["*"]}]}`, nil, "must start with /"},
+		{"duplicate cors prefix", `{"cors":[{"path_prefix":"/p","allowed_origins":["*"]},{"path_prefix":"/p/","allowed_origins":["*"]}]}`, nil, "duplicate path_prefix"},
+		{"cors without origins", `{"cors":[{"path_prefix":"/p"}]}`, nil, "allows no origins"},
+		{"cors on healthz", `{"cors":[{"path_prefix":"/healthz","allowed_origins":["*"]}]}`, nil, "gateway endpoint"},
+		{"client ca without tls", `{"tls":{"client_ca_file":"ca.pem"}}`, nil, "client_ca_file requires"},
+		{"bad client auth", `{"tls":{"cert_file":"c","key_file":"k","client_ca_file":"ca.pem","client_auth":"optional"}}`, nil, "tls.client_auth"},
+		{"client names without client ca", `{"routes":[{"path_prefix":"/internal","upstream_url":"http://a:1","client_names":["billing"]}]}`, nil, "client_names requires"},
+		{"negative max in flight", `{}`, map[string]string{"MAX_IN_FLIGHT": "-1"}, "max_in_flight"},
+		{"bad env max in flight", `{}`, map[string]string{"MAX_IN_FLIGHT": "lots"}, "MAX_IN_FLIGHT"},
+		{"negative max conns per ip", `{"max_conns_per_ip": -1}`, nil, "max_conns_per_ip"},
+		{"bad env max conns per ip", `{}`, map[string]string{"MAX_CONNS_PER_IP": "many"}, "MAX_CONNS_PER_IP"},
+		{"unnamed middleware", `{"middleware":{"api":[{"name":"gzip"},{"options":{}}]}}`, nil, "middleware.api[1]"},
+		{"zero max header bytes", `{"max_header_bytes":0}`, nil, "max_header_bytes"},
+		{"bad env max header bytes", `{}`, map[string]string{"MAX_HEADER_BYTES": "64k"}, "MAX_HEADER_BYTES"},
+		{"zero max uri length", `{"max_uri_length":0}`, nil, "max_uri_length"},
+		{"bad env max uri length", `{}`, map[string]string{"MAX_URI_LENGTH": "8k"}, "MAX_URI_LENGTH"},
+		{"unix socket without path", `{"addr":"unix:"}`, nil, "missing socket path"},
+		{"listen address twice", `{"listen":["unix:/run/gw.sock","unix:/run/gw.sock"]}`, nil, "listed twice"},
+		{"bad listen address", `{}`, map[string]string{"LISTEN": "unix:/run/gw.sock,8080"}, "listen:"},
+		{"bad socket mode", `{"unix_socket_mode":"rw-rw----"}`, nil, "unix_socket_mode"},
+		{"redirect to unix socket", `{"addr":"unix:/run/gw.sock","tls":{"cert_file":"c","key_file":"k","redirect_addr":":80"}}`, nil, "tls: redirect_addr"},
+		{"tier without burst", `{"rate_limit":{"rps":50,"burst":100,"tiers":{"pro":{"rps":100}}}}`, nil, "rate_limit.tiers.pro"},
+		{"bad env tiers", `{}`, map[string]string{"RATE_LIMIT_TIERS": "free=10"}, "RATE_LIMIT_TIERS"},
+		{"tiers without claim", `{"rate_limit":{"rps":50,"burst":100,"tier_claim":"","tiers":{"pro":{"rps":100,"burst":200}}}}`, nil, "rate_limit.tier_claim"},
+		{"unknown log level", `{}`, map[string]string{"LOG_LEVEL": "verbose"}, "log_level"},
+		{"access log rate out of range", `{"access_log":{"success_sample_rate":1.5}}`, nil, "access_log.success_sample_rate"},
+		{"fallback secrets without primary", `{"auth":{"jwt_fallback_secrets":["old"]}}`, nil, "auth.jwt_fallback_secrets"},
+		{"empty fallback secret", `{}`, map[string]string{"JWT_SECRET": "new", "JWT_FALLBACK_SECRETS": "old,"}, "auth.jwt_fallback_secrets"},
+		{"claim header pair without =", `{}`, map[string]string{"CLAIM_HEADERS": "sub"}, "CLAIM_HEADERS"},
+		{"claim mapped to hop-by-hop header", `{"auth":{"claim_headers":{"sub":"Connection"}}}`, nil, "auth.claim_headers"},
+		{"session options without secret", `{"auth":{"session":{"ttl":"1h"}}}`, nil, "auth.session: set without secret"},
+		{"no jwt secret", `{}`, nil, "auth.jwt_secret: required"},
+		{"disabled with secret", `{"auth":{"disabled":true}}`, map[string]string{"JWT_SECRET": "secret"}, "auth.disabled: set alongside credentials"},
+		{"disabled with scoped route", `{}`, map[string]string{"AUTH_DISABLED": "true", "UPSTREAM_SERVICES_URL": "http://s:1"}, "requires scope"},
+		{"session without jwt secret", `{"auth":{"api_keys_file":"keys.json","session":{"secret":"` + strings.Repeat("s", 32) + `"}}}`, nil, "auth.session: requires jwt_secret"},
+		{"short session secret", `{}`, map[string]string{"SESSION_SECRET": "short"}, "auth.session.secret"},
+		{"bad session cookie name", `{}`, map[string]string{"SESSION_SECRET": strings.Repeat("s", 32), "SESSION_COOKIE_NAME": "my session"}, "auth.session.cookie_name"},
+		{"negative session ttl", `{}`, map[string]string{"SESSION_SECRET": strings.Repeat("s", 32), "SESSION_TTL": "-1h"}, "auth.session.ttl"},
+		{"cors on session endpoint", `{"auth":{"session":{"secret":"` + strings.Repeat("s", 32) + `"}},"cors":[{"path_prefix":"/auth/session","allowed_origins":["*"]}]}`, nil, "gateway endpoint"},
+		{"negative warmup", `{}`, map[string]string{"WARMUP_DURATION": "-5s"}, "warmup.duration"},
+		{"warmup on readyz", `{"warmup":{"duration":"10s","path_prefixes":["/readyz"]}}`, nil, "gateway endpoint"},
+		{"dump on sensitive route", `{"routes":[{"path_prefix":"/login","upstream_url":"http://a:1","dump_body":true,"sensitive":true}]}`, nil, "sensitive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"CONFIG_FILE": writeFile(t, "gateway.json", tt.file)}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			_, err := load(env(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.Auth.JWTFallbackSecrets = []string{"old-jwt-secret"}
+	cfg.Auth.Session.Secret = strings.Repeat("s", 32)
+	cfg.Admin = Admin{Addr: "127.0.0.1:9090", Token: strings.Repeat("t", 32)}
+	cfg.RateLimit.RedisURL = "redis://:redis-pass@redis:6379/0"
+	cfg.Routes = []handler.Rule{{PathPrefix: "/api", Upstreams: []handler.Upstream{{URL: "http://u:up-pass@a:1"}}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(cfg.Redacted())
+	for _, secret := range []string{"jwt-secret", "old-jwt-secret", cfg.Auth.Session.Secret, cfg.Admin.Token, "redis-pass", "up-pass"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("redacted config contains %q: %s", secret, data)
+		}
+	}
+	if cfg.Auth.JWTSecret != "jwt-secret" || cfg.Auth.JWTFallbackSecrets[0] != "old-jwt-secret" ||
+		cfg.Routes[0].Upstreams[0].URL != "http://u:up-pass@a:1" {
+		t.Fatal("Redacted modified the original")
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := Default()
+	cfg.Addr = ""
+	cfg.TLS.KeyFile = "k.pem"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "addr") || !strings.Contains(err.Error(), "tls") {
+		t.Fatalf("err = %v, want both addr and tls reported", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package connlimit caps the connections each client address may hold open
+// to the gateway at once.
+package connlimit
+
+import (
+	"cmp"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"api-gateway/internal/metrics"
+)
+
+var (
+	openGauge = metrics.Default.NewGauge("gateway_client_connections",
+		"Client connections open and counted against the per-address limit.")
+	refusedCounter = metrics.Default.NewCounter("gateway_client_connections_refused_total",
+		"Client connections closed on accept because their address was at its limit.")
+)
+
+// Limiter counts open connections by client IP across the listeners it
+// wraps, and closes new ones from an address already holding its maximum.
+// Connections from trusted proxies aren't counted: a load balancer carries
+// many clients over its connections, and those clients are only known
+// once a request's X-Forwarded-For is read, after the connection is
+// accepted. Connections without an IP, such as over a Unix socket, aren't
+// counted either.
+type Limiter struct {
+	max     int
+	trusted []netip.Prefix
+
+	mu     sync.Mutex
+	counts map[netip.Addr]int
+}
+
+// New returns a Limiter allowing max connections per client address,
+// exempting addresses in trusted.
+func New(max int, trusted []netip.Prefix) *Limiter {
+	return &Limiter{max: max, trusted: trusted, counts: map[netip.Addr]int{}}
+}
+
+// Listener returns ln with its connections counted against l. A
+// connection over the limit is closed as soon as it is accepted, before
+// anything is read from it, and Accept waits for the next one.
+func (l *Limiter) Listener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, limiter: l}
+}
+
+// ClientCount is one address's share of the open connections.
+type ClientCount struct {
+	IP          string `json:"ip"`
+	Connections int    `json:"connections"`
+}
+
+// Top returns the n addresses holding the most connections, most first.
+func (l *Limiter) Top(n int) []ClientCount {
+	l.mu.Lock()
+	counts := make([]ClientCount, 0, len(l.counts))
+	for ip, c := range l.counts {
+		counts = append(counts, ClientCount{IP: ip.String(), Connections: c})
+	}
+	l.mu.Unlock()
+	slices.SortFunc(counts, func(a, b ClientCount) int {
+		return cmp.Or(b.Connections-a.Connections, cmp.Compare(a.IP, b.IP))
+	})
+	return counts[:min(n, len(counts))]
+}
+
+// Max returns the per-address limit.
+func (l *Limiter) Max() int {
+	return l.max
+}
+
+// acquire counts a connection from ip, reporting false if ip is at the
+// limit.
+func (l *Limiter) acquire(ip netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	openGauge.Add(1)
+	return true
+}
+
+func (l *Limiter) release(ip netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[ip]--; l.counts[ip] <= 0 {
+		delete(l.counts, ip)
+	}
+	openGauge.Add(-1)
+}
+
+// counted reports the address a connection is counted under, if any.
+func (l *Limiter) counted(conn net.Conn) (netip.Addr, bool) {
+	tcp, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return netip.Addr{}, false
+		}
+	}
+	return ip, true
+}
+
+type listener struct {
+	net.Listener
+	limiter *Limiter
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip, ok := ln.limiter.counted(conn)
+		if !ok {
+			return conn, nil
+		}
+		if !ln.limiter.acquire(ip) {
+			refusedCounter.Inc()
+			conn.Close()
+			continue
+		}
+		return &countedConn{Conn: conn, release: sync.OnceFunc(func() { ln.limiter.release(ip) })}, nil
+	}
+}
+
+// countedConn gives back its slot when first closed.
+type countedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *countedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,113 @@
+package connlimit
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+// serve accepts connections from l's listener on a loopback port and
+// hands each to the returned channel. Refused connections never arrive.
+func serve(t *testing.T, l *Limiter) (string, <-chan net.Conn) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := l.Listener(inner)
+	t.Cleanup(func() { ln.Close() })
+	accepted := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			accepted <- conn
+		}
+	}()
+	return inner.Addr().String(), accepted
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// refused reports whether the gateway closed conn on accept.
+func refused(t *testing.T, conn net.Conn) bool {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestLimiter(t *testing.T) {
+	l := New(2, nil)
+	addr, accepted := serve(t, l)
+
+	a := dial(t, addr)
+	serverA := <-accepted
+	dial(t, addr)
+	<-accepted
+	if over := dial(t, addr); !refused(t, over) {
+		t.Fatal("third connection from one address was accepted")
+	}
+	if refused(t, a) {
+		t.Fatal("connection within the limit was closed")
+	}
+	if got, want := l.Top(10), []ClientCount{{IP: "127.0.0.1", Connections: 2}}; !slices.Equal(got, want) {
+		t.Fatalf("Top = %+v, want %+v", got, want)
+	}
+
+	// Closing a connection, even twice, frees exactly one slot.
+	serverA.Close()
+	serverA.Close()
+	dial(t, addr)
+	<-accepted
+	if over := dial(t, addr); !refused(t, over) {
+		t.Fatal("connection over the limit accepted after a slot was freed")
+	}
+}
+
+func TestLimiterExemptsTrustedProxies(t *testing.T) {
+	l := New(1, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+	addr, accepted := serve(t, l)
+	for range 3 {
+		dial(t, addr)
+		select {
+		case <-accepted:
+		case <-time.After(time.Second):
+			t.Fatal("connection from a trusted proxy wasn't accepted")
+		}
+	}
+	if top := l.Top(10); len(top) != 0 {
+		t.Fatalf("Top = %+v, want trusted connections uncounted", top)
+	}
+}
+
+func TestLimiterTop(t *testing.T) {
+	l := New(10, nil)
+	for _, ip := range []string{"10.0.0.2", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.3"} {
+		l.acquire(netip.MustParseAddr(ip))
+	}
+	want := []ClientCount{{"10.0.0.2", 2}, {"10.0.0.3", 2}}
+	if got := l.Top(2); !slices.Equal(got, want) {
+		t.Fatalf("Top(2) = %+v, want %+v", got, want)
+	}
+	l.release(netip.MustParseAddr("10.0.0.1"))
+	if got := l.Top(10); len(got) != 2 {
+		t.Fatalf("Top after release = %+v, want 10.0.0.1 gone", got)
+	}
+}
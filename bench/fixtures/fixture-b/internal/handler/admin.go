@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/connlimit"
+	"api-gateway/internal/logging"
+)
+
+// adminTopClients is how many client addresses /admin/connections lists.
+const adminTopClients = 100
+
+// AdminConfig configures NewAdmin.
+type AdminConfig struct {
+	Router *Router
+	// Config returns the effective configuration for /admin/config. It
+	// must already be redacted; the handler serves it as is.
+	Config func() any
+	// Connections, if set, is the per-address connection limiter whose
+	// counts /admin/connections reports.
+	Connections *connlimit.Limiter
+	// Token, if set, must be presented as a Bearer token on every request.
+	Token string
+}
+
+// NewAdmin returns the admin API, a view of the gateway's live state:
+//
+//	GET  /admin/routes       the current routing table
+//	GET  /admin/upstreams    each upstream's circuit breaker and health check
+//	GET  /admin/config       the effective configuration, secrets masked
+//	GET  /admin/connections  the client addresses holding most connections
+//	GET  /admin/loglevel     the current log level
+//	POST /admin/loglevel     sets it from a body of {"level": "debug"}
+//
+// Setting the log level is the only change it can make.
+//
+// It is a handler of its own rather than routes on the gateway's Mux, so
+// it can only be reached through a listener started for it.
+func NewAdmin(cfg AdminConfig) http.Handler {
+	mux := NewMux()
+	handle(mux.ServeMux, "GET /admin/routes", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := cfg.Router.Rules()
+		for i := range rules {
+			rules[i] = rules[i].Redacted()
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"routes": rules})
+	}))
+	handle(mux.ServeMux, "GET /admin/upstreams", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreams := cfg.Router.Upstreams()
+		for i := range upstreams {
+			upstreams[i].URL = redactURL(upstreams[i].URL)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"upstreams": upstreams})
+	}))
+	if cfg.Config != nil {
+		handle(mux.ServeMux, "GET /admin/config", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, cfg.Config())
+		}))
+	}
+	if l := cfg.Connections; l != nil {
+		handle(mux.ServeMux, "GET /admin/connections", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]any{"max_per_ip": l.Max(), "clients": l.Top(adminTopClients)})
+		}))
+	}
+	handle(mux.ServeMux, "GET /admin/loglevel", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"level": logging.CurrentLevel().String()})
+	}))
+	handle(mux.ServeMux, "POST /admin/loglevel", http.HandlerFunc(setLogLevel))
+	if cfg.Token == "" {
+		return mux
+	}
+	return requireToken(cfg.Token, mux)
+}
+
+// setLogLevel sets the log level from a body of {"level": "..."}. The
+// change is logged whatever the old and new levels, so there is a record
+// of who turned logging down.
+func setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+		apierr.Write(w, r, http.StatusBadRequest, apierr.CodeBadRequest, `body must be {"level": "debug|info|warn|error"}`)
+		return
+	}
+	level, err := logging.ParseLevel(body.Level)
+	if err != nil {
+		apierr.Write(w, r, http.StatusBadRequest, apierr.CodeBadRequest, err.Error())
+		return
+	}
+	previous := logging.SetLevel(level)
+	log.Printf("admin: log level set to %s, was %s, by %s", level, previous, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"level": level.String(), "previous": previous.String()})
+}
+
+// requireToken rejects requests without "Authorization: Bearer token" with
+// 401. Digests are compared so the check's timing reveals nothing about
+// the token, not even its length.
+func requireToken(token string, next http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		sum := sha256.Sum256([]byte(got))
+		if !ok || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apierr.Write(w, r, http.StatusUnauthorized, apierr.CodeUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+
+	"api-gateway/internal/middleware"
+)
+
+// Upstream is one target of a load-balanced route.
+type Upstream struct {
+	URL string `json:"url"`
+	// Weight sets the upstream's share of traffic relative to the route's
+	// other upstreams. Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+}
+
+// balancer spreads requests across upstreams with smooth weighted
+// round-robin, the scheme nginx uses: each pick adds every upstream's weight
+// to its running score, takes the highest scorer, then charges the winner
+// the total weight. Over a cycle each upstream is picked in proportion to its
+// weight, interleaved rather than in bursts.
+//
+// Upstreams whose circuit breaker is open, or that fail active health
+// checks, are left out of the rotation. When every upstream is out the pick
+// falls back to the full set so the request still gets the breaker's 503
+// and Retry-After, or the upstream's own error.
+type balancer struct {
+	// healthy, if set, reports an upstream's active health check result.
+	healthy func(url string) bool
+
+	mu      sync.Mutex
+	targets []*target
+}
+
+type target struct {
+	url     string
+	handler http.Handler
+	breaker *middleware.CircuitBreaker
+	weight  int
+	current int
+}
+
+func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.pick().handler.ServeHTTP(w, r)
+}
+
+func (b *balancer) pick() *target {
+	if len(b.targets) == 1 {
+		return b.targets[0]
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if best := b.next(b.available); best != nil {
+		return best
+	}
+	return b.next(func(*target) bool { return true })
+}
+
+// anyAvailable reports whether any upstream is in rotation.
+func (b *balancer) anyAvailable() bool {
+	for _, t := range b.targets {
+		if b.available(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *balancer) available(t *target) bool {
+	if b.healthy != nil && !b.healthy(t.url) {
+		return false
+	}
+	return t.breaker.Available()
+}
+
+func (b *balancer) next(eligible func(*target) bool) *target {
+	var best *target
+	total := 0
+	for _, t := range b.targets {
+		if !eligible(t) {
+			continue
+		}
+		t.current += t.weight
+		total += t.weight
+		if best == nil || t.current > best.current {
+			best = t
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
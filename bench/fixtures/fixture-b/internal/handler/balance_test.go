@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/health"
+)
+
+func TestBalancerWeightedDistribution(t *testing.T) {
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", Upstreams: []Upstream{
+		{URL: namedUpstream(t, "a"), Weight: 3},
+		{URL: namedUpstream(t, "b"), Weight: 1},
+		{URL: namedUpstream(t, "c")},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	var order []string
+	for range 50 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+		counts[rec.Body.String()]++
+		order = append(order, rec.Body.String())
+	}
+	if counts["a"] != 30 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Fatalf("counts = %v, want a:30 b:10 c:10", counts)
+	}
+	// Smooth WRR interleaves rather than sending a's share in one burst.
+	for i := 2; i < len(order); i++ {
+		if order[i] == "a" && order[i-1] == "a" && order[i-2] == "a" {
+			t.Fatalf("three consecutive picks of a in %v", order[:10])
+		}
+	}
+}
+
+func TestBalancerSkipsOpenBreaker(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	rt, err := NewRouter([]Rule{{
+		PathPrefix:       "/api",
+		BreakerThreshold: 1,
+		Upstreams:        []Upstream{{URL: down.URL}, {URL: namedUpstream(t, "up")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 10 {
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+	}
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "up" {
+			t.Fatalf("request %d: %d %q, want the healthy upstream", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	// With every breaker open the route fails fast with the breaker's 503.
+	rt.Breakers()[1].Record(false)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("all open: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestBalancerSkipsUnhealthyUpstream(t *testing.T) {
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "sick")
+	}))
+	defer sick.Close()
+	rules := []Rule{{
+		PathPrefix:     "/api",
+		Upstreams:      []Upstream{{URL: sick.URL}, {URL: namedUpstream(t, "well")}},
+		HealthPath:     "/healthz",
+		HealthInterval: Duration(time.Hour),
+	}}
+	checker := health.NewChecker(HealthTargets(rules))
+	rt, err := NewRouter(rules, WithHealthChecker(checker))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first probe round runs immediately; wait for it to mark sick down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.Run(ctx)
+	for deadline := time.Now().Add(2 * time.Second); checker.Healthy(sick.URL); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("sick upstream never marked down")
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		if rec.Body.String() != "well" {
+			t.Fatalf("request %d went to %q", i, rec.Body.String())
+		}
+	}
+}
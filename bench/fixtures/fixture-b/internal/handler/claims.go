@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/auth"
+)
+
+// WithClaimHeaders sends the request's validated claims upstream as
+// headers, mapping claim names to header names: {"sub": "X-User-ID"} sends
+// the token's sub as X-User-ID. The mapped headers are first removed from
+// what the client sent, so a client can't pass itself off as another user
+// even on requests without a token. A claim the token lacks, or whose value
+// isn't a string, number, boolean or list of those, leaves the header
+// unset; lists are joined with commas. The claims are those stored in the
+// request context by auth's middleware.
+func WithClaimHeaders(claimHeaders map[string]string) ProxyOption {
+	return func(c *proxyConfig) { c.claims = claimHeaders }
+}
+
+// setClaimHeaders applies a WithClaimHeaders mapping to the outbound h.
+func setClaimHeaders(claimHeaders map[string]string, claims auth.Claims, h http.Header) {
+	for _, name := range claimHeaders {
+		h.Del(name)
+	}
+	for claim, name := range claimHeaders {
+		if v, ok := claimHeaderValue(claims[claim]); ok {
+			h.Set(name, v)
+		}
+	}
+}
+
+func claimHeaderValue(v any) (string, bool) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, elem := range v {
+			if _, nested := elem.([]any); nested {
+				return "", false
+			}
+			p, ok := claimHeaderValue(elem)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, p)
+		}
+		s = strings.Join(parts, ",")
+	default:
+		return "", false
+	}
+	// A claim can't smuggle extra headers in, nor break the request.
+	if s == "" || strings.ContainsAny(s, "\r\n\x00") {
+		return "", false
+	}
+	return s, true
+}
+
+// ValidateClaimHeaders checks a WithClaimHeaders mapping: header names must
+// be valid, distinct, and not among those the proxy manages itself.
+func ValidateClaimHeaders(claimHeaders map[string]string) error {
+	seen := map[string]string{}
+	for claim, name := range claimHeaders {
+		canonical := http.CanonicalHeaderKey(name)
+		switch {
+		case claim == "":
+			return fmt.Errorf("header %s: empty claim name", name)
+		case !validHeaderName(name):
+			return fmt.Errorf("claim %s: invalid header name %q", claim, name)
+		case unsettable[canonical]:
+			return fmt.Errorf("claim %s: header %s can't be set", claim, canonical)
+		case seen[canonical] != "":
+			return fmt.Errorf("claims %s and %s both map to %s", seen[canonical], claim, canonical)
+		}
+		seen[canonical] = claim
+	}
+	return nil
+}
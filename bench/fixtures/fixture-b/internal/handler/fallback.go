@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+
+	"api-gateway/internal/middleware"
+)
+
+// Fallback is the response a route serves to GET and HEAD requests while
+// its upstream is unavailable; see middleware.NewFallback.
+type Fallback struct {
+	// Status defaults to 200, ContentType to "application/json".
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Body is the response body, or BodyFile names a file holding it,
+	// read whenever the routes are loaded.
+	Body     string `json:"body,omitempty"`
+	BodyFile string `json:"body_file,omitempty"`
+}
+
+func validateFallback(f *Fallback) error {
+	if f == nil {
+		return nil
+	}
+	switch {
+	case f.Body != "" && f.BodyFile != "":
+		return errors.New("fallback: set body or body_file, not both")
+	case f.Body == "" && f.BodyFile == "":
+		return errors.New("fallback: body or body_file is required")
+	case f.Status != 0 && (f.Status < 200 || f.Status > 599):
+		return fmt.Errorf("fallback: invalid status %d", f.Status)
+	}
+	if f.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(f.ContentType); err != nil {
+			return fmt.Errorf("fallback: invalid content_type %q", f.ContentType)
+		}
+	}
+	return nil
+}
+
+// load returns the middleware configuration for f, reading BodyFile.
+func (f *Fallback) load() (middleware.FallbackConfig, error) {
+	cfg := middleware.FallbackConfig{Status: f.Status, ContentType: f.ContentType, Body: []byte(f.Body)}
+	if f.BodyFile != "" {
+		body, err := os.ReadFile(f.BodyFile)
+		if err != nil {
+			return cfg, fmt.Errorf("fallback: %w", err)
+		}
+		cfg.Body = body
+	}
+	return cfg, nil
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/middleware"
+)
+
+// RouteGroup registers routes under a common path prefix, wrapping each in
+// the group's middleware stack.
+type RouteGroup struct {
+	mux    *http.ServeMux
+	prefix string
+	mws    []middleware.Middleware
+}
+
+// Group returns a RouteGroup mounting routes under prefix on mux. The prefix
+// has no trailing slash: Group(mux, "/api", ...).Handle("/v1/users", h)
+// registers "/api/v1/users". An empty prefix groups routes at the root.
+func Group(mux *http.ServeMux, prefix string, mws ...middleware.Middleware) *RouteGroup {
+	return &RouteGroup{mux: mux, prefix: strings.TrimSuffix(prefix, "/"), mws: mws}
+}
+
+// Group returns a nested group whose stack runs inside this group's.
+func (g *RouteGroup) Group(prefix string, mws ...middleware.Middleware) *RouteGroup {
+	return &RouteGroup{
+		mux:    g.mux,
+		prefix: g.prefix + strings.TrimSuffix(prefix, "/"),
+		mws:    append(append([]middleware.Middleware(nil), g.mws...), mws...),
+	}
+}
+
+// Handle registers h for pattern under the group's prefix. pattern follows
+// http.ServeMux syntax and may start with a method, as in "GET /v1/users".
+func (g *RouteGroup) Handle(pattern string, h http.Handler) {
+	full := g.prefix + pattern
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		full = method + " " + g.prefix + strings.TrimLeft(path, " ")
+	}
+	handle(g.mux, full, middleware.Chain(g.mws...)(h))
+}
+
+// HandleFunc is Handle for plain functions.
+func (g *RouteGroup) HandleFunc(pattern string, h http.HandlerFunc) {
+	g.Handle(pattern, h)
+}
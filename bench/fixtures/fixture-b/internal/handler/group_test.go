@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/middleware"
+)
+
+// tag returns middleware that appends name to the X-Stack response header.
+func tag(name string) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Stack", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouteGroups(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux.Handle("/healthz", ok)
+	api := Group(mux, "/api", tag("cors"))
+	api.Handle("/v1/services", ok)
+	users := api.Group("/v1/users", tag("auth"), tag("ratelimit"))
+	users.Handle("/", ok)
+	users.Handle("GET /export", ok)
+
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/healthz", ""},
+		{"GET", "/api/v1/services", "cors"},
+		{"GET", "/api/v1/users/42", "cors,auth,ratelimit"},
+		{"GET", "/api/v1/users/export", "cors,auth,ratelimit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := strings.Join(rec.Header().Values("X-Stack"), ","); got != tt.want {
+				t.Fatalf("stack = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterRoutesInSeveralGroups(t *testing.T) {
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/partner", UpstreamURL: namedUpstream(t, "partner")},
+		{PathPrefix: "/api", UpstreamURL: namedUpstream(t, "api")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewMux()
+	RegisterRoutes(Group(mux.ServeMux, "/partner", tag("partner-cors")), rt)
+	RegisterRoutes(Group(mux.ServeMux, "", tag("public-cors")), rt)
+
+	tests := []struct {
+		path      string
+		wantStack string
+		wantBody  string
+	}{
+		{"/api/v1/users", "public-cors", "api"},
+		{"/partner", "partner-cors", "partner"},
+		{"/partner/v1/orders", "partner-cors", "partner"},
+		{"/partnership", "public-cors", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := strings.Join(rec.Header().Values("X-Stack"), ","); got != tt.wantStack {
+				t.Fatalf("stack = %q, want %q", got, tt.wantStack)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Fatalf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/middleware"
+)
+
+// maxGRPCWebRequest caps a translated request's messages, as gRPC servers
+// cap what they receive by default.
+const maxGRPCWebRequest = 4 << 20
+
+// gRPC status codes the translation answers with itself.
+const (
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+)
+
+// grpcTrailerFrame flags the frame carrying a gRPC-Web response's
+// trailers; message frames have the flag clear.
+const grpcTrailerFrame = 0x80
+
+// grpcStatusHeaders are the trailers a trailers-only gRPC response sends as
+// headers.
+var grpcStatusHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+
+// grpcWebType parses a gRPC-Web content type: application/grpc-web or
+// application/grpc-web-text, optionally with a codec suffix such as
+// "+proto". It reports the suffix and whether the body is base64 text.
+func grpcWebType(ct string) (suffix string, text, ok bool) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", false, false
+	}
+	rest, ok := strings.CutPrefix(mt, "application/grpc-web")
+	if !ok {
+		return "", false, false
+	}
+	rest, text = strings.CutPrefix(rest, "-text")
+	if rest != "" && (rest[0] != '+' || len(rest) == 1) {
+		return "", false, false
+	}
+	return rest, text, true
+}
+
+// grpcType reports the codec suffix of a native gRPC content type.
+func grpcType(ct string) (suffix string, ok bool) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(mt, "application/grpc")
+	if !ok || rest != "" && (rest[0] != '+' || len(rest) == 1) {
+		return "", false
+	}
+	return rest, true
+}
+
+// grpcWeb translates gRPC-Web requests into gRPC for next, a proxy to an
+// HTTP/2 upstream, and the upstream's gRPC responses back into gRPC-Web.
+// Requests of any other content type pass through untouched.
+//
+// Message frames are the same in both protocols; what differs is the
+// content type, and that gRPC sends its status as HTTP/2 trailers, which
+// browsers can't read, where gRPC-Web appends them to the body as a final
+// frame. Bodies of application/grpc-web-text are base64 in both
+// directions. Only unary and server-streaming calls can be translated: the
+// request must be a single message, and one carrying more is refused as
+// client streaming with UNIMPLEMENTED. Responses that aren't gRPC, such
+// as the gateway's own 502, are passed on as they are.
+func grpcWeb(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suffix, text, ok := grpcWebType(r.Header.Get("Content-Type"))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &grpcWebWriter{ResponseWriter: w, suffix: suffix, text: text}
+		if r.Method != http.MethodPost {
+			gw.fail(grpcUnimplemented, "gRPC-Web calls must be POST")
+			return
+		}
+		limit := int64(maxGRPCWebRequest)
+		if text {
+			limit = int64(base64.StdEncoding.EncodedLen(maxGRPCWebRequest))
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge) || int64(len(body)) > limit:
+			gw.fail(grpcResourceExhausted, "request message too large")
+			return
+		case err != nil:
+			// The client went away mid-body; nobody will read an answer.
+			w.WriteHeader(middleware.StatusClientClosedRequest)
+			return
+		}
+		if text {
+			if body, err = decodeGRPCWebText(body); err != nil {
+				gw.fail(grpcInvalidArgument, "malformed base64 request body")
+				return
+			}
+		}
+		switch n, ok := countFrames(body); {
+		case !ok:
+			gw.fail(grpcInvalidArgument, "malformed gRPC-Web request body")
+			return
+		case n == 0:
+			gw.fail(grpcInvalidArgument, "request has no message")
+			return
+		case n > 1:
+			gw.fail(grpcUnimplemented, "client streaming isn't supported for gRPC-Web")
+			return
+		}
+
+		out := r.Clone(r.Context())
+		out.Header.Set("Content-Type", "application/grpc"+suffix)
+		// gRPC servers refuse requests that don't promise to read trailers.
+		out.Header.Set("Te", "trailers")
+		out.Header.Del("X-Grpc-Web")
+		out.Header.Del("Content-Length")
+		out.ContentLength = int64(len(body))
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(gw, out)
+		gw.finish()
+	})
+}
+
+// countFrames counts the length-prefixed messages in a request body,
+// reporting false if it ends mid-frame or holds a trailer frame.
+func countFrames(b []byte) (int, bool) {
+	n := 0
+	for len(b) > 0 {
+		if len(b) < 5 || b[0]&grpcTrailerFrame != 0 {
+			return n, false
+		}
+		size := binary.BigEndian.Uint32(b[1:5])
+		if uint64(len(b)-5) < uint64(size) {
+			return n, false
+		}
+		b = b[5+size:]
+		n++
+	}
+	return n, true
+}
+
+// decodeGRPCWebText decodes a grpc-web-text body. Clients may send several
+// padded base64 chunks back to back, so it decodes four characters at a
+// time rather than as one string.
+func decodeGRPCWebText(b []byte) ([]byte, error) {
+	if len(b)%4 != 0 {
+		return nil, base64.CorruptInputError(len(b))
+	}
+	out := make([]byte, 0, base64.StdEncoding.DecodedLen(len(b)))
+	var buf [3]byte
+	for i := 0; i < len(b); i += 4 {
+		n, err := base64.StdEncoding.Decode(buf[:], b[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf[:n]...)
+	}
+	return out, nil
+}
+
+// grpcWebWriter turns the proxy's gRPC response into gRPC-Web: it renames
+// the content type when the header is written and, in finish, writes the
+// trailers the proxy set after the body as the body's final frame.
+type grpcWebWriter struct {
+	http.ResponseWriter
+	suffix string
+	text   bool
+
+	wroteHeader bool
+	// translate is set once the upstream has answered with gRPC.
+	translate bool
+	// declared are the trailers the proxy announced in the Trailer header.
+	declared []string
+	// status holds a trailers-only response's status headers.
+	status http.Header
+}
+
+func (gw *grpcWebWriter) contentType(suffix string) string {
+	if gw.text {
+		return "application/grpc-web-text" + suffix
+	}
+	return "application/grpc-web" + suffix
+}
+
+func (gw *grpcWebWriter) WriteHeader(code int) {
+	if gw.wroteHeader || code < 200 {
+		gw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	gw.wroteHeader = true
+	h := gw.Header()
+	if suffix, ok := grpcType(h.Get("Content-Type")); ok {
+		gw.translate = true
+		h.Set("Content-Type", gw.contentType(cmp.Or(suffix, gw.suffix)))
+		for _, v := range h.Values("Trailer") {
+			for name := range strings.SplitSeq(v, ",") {
+				gw.declared = append(gw.declared, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+			}
+		}
+		h.Del("Trailer")
+		h.Del("Content-Length")
+		// A trailers-only response's status goes in the trailer frame
+		// too, where browsers can read it without CORS exposing headers.
+		for _, name := range grpcStatusHeaders {
+			if vs := h.Values(name); len(vs) > 0 {
+				if gw.status == nil {
+					gw.status = http.Header{}
+				}
+				gw.status[name] = vs
+				h.Del(name)
+			}
+		}
+	}
+	gw.ResponseWriter.WriteHeader(code)
+}
+
+func (gw *grpcWebWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if !gw.translate || !gw.text {
+		return gw.ResponseWriter.Write(b)
+	}
+	// Each write is encoded, padding and all, on its own, so that
+	// streamed messages reach the client as they arrive.
+	if _, err := io.WriteString(gw.ResponseWriter, base64.StdEncoding.EncodeToString(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (gw *grpcWebWriter) Flush() {
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *grpcWebWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// fail answers with a gRPC-Web error of the gateway's own: status 200, no
+// messages, and code and message in the trailer frame.
+func (gw *grpcWebWriter) fail(code int, message string) {
+	gw.Header().Set("Content-Type", gw.contentType(cmp.Or(gw.suffix, "+proto")))
+	gw.wroteHeader = true
+	gw.translate = true
+	gw.status = http.Header{
+		"Grpc-Status":  {strconv.Itoa(code)},
+		"Grpc-Message": {message},
+	}
+	gw.ResponseWriter.WriteHeader(http.StatusOK)
+	gw.finish()
+}
+
+// finish writes the trailer frame once the proxy has copied the body and
+// set the upstream's trailers on the header map.
+func (gw *grpcWebWriter) finish() {
+	if !gw.translate {
+		return
+	}
+	trailers := gw.status
+	if trailers == nil {
+		trailers = http.Header{}
+	}
+	h := gw.Header()
+	for k, vs := range h {
+		name, prefixed := strings.CutPrefix(k, http.TrailerPrefix)
+		if !prefixed && !slices.Contains(gw.declared, k) {
+			continue
+		}
+		trailers[http.CanonicalHeaderKey(name)] = vs
+		// Nothing was announced, so net/http would otherwise send
+		// prefixed keys as trailers of its own.
+		delete(h, k)
+	}
+	var block bytes.Buffer
+	for _, k := range slices.Sorted(maps.Keys(trailers)) {
+		for _, v := range trailers[k] {
+			fmt.Fprintf(&block, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcTrailerFrame
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	gw.Write(append(frame, block.Bytes()...))
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// grpcFrame frames payload as a gRPC message, or as a gRPC-Web trailer
+// block if flag is grpcTrailerFrame.
+func grpcFrame(flag byte, payload string) []byte {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+// grpcUpstream starts a cleartext HTTP/2 gRPC server. Unary answers
+// "hello " and the request's message, Stream sends two messages, Missing
+// answers trailers-only with NOT_FOUND, and any other request is answered
+// with the content type it arrived with.
+func grpcUpstream(t *testing.T, release <-chan struct{}) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if _, ok := grpcType(r.Header.Get("Content-Type")); !ok {
+			io.WriteString(w, r.Header.Get("Content-Type"))
+			return
+		}
+		if r.ProtoMajor != 2 || r.Header.Get("Te") != "trailers" {
+			t.Errorf("upstream got %s with TE %q", r.Proto, r.Header.Get("Te"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		switch r.URL.Path {
+		case "/svc/Unary":
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.Write(grpcFrame(0, "hello "+string(body[5:])))
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set("Grpc-Message", "ok")
+		case "/svc/Stream":
+			w.Write(grpcFrame(0, "one"))
+			w.(http.Flusher).Flush()
+			if release != nil {
+				<-release
+			}
+			w.Write(grpcFrame(0, "two"))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		case "/svc/Missing":
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "no such thing")
+		}
+	}))
+	up.Config.Protocols = new(http.Protocols)
+	up.Config.Protocols.SetHTTP1(true)
+	up.Config.Protocols.SetUnencryptedHTTP2(true)
+	up.Start()
+	t.Cleanup(up.Close)
+	return up.URL, &calls
+}
+
+func grpcWebRouter(t *testing.T, upstream string) *Router {
+	t.Helper()
+	rt, err := NewRouter([]Rule{{
+		PathPrefix:       "/svc",
+		UpstreamURL:      upstream,
+		UpstreamProtocol: ProtocolHTTP2,
+		GRPCWeb:          true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func TestGRPCWeb(t *testing.T) {
+	upstream, calls := grpcUpstream(t, nil)
+	rt := grpcWebRouter(t, upstream)
+	textBody := func(frames ...[]byte) string {
+		var s string
+		for _, f := range frames {
+			s += base64.StdEncoding.EncodeToString(f)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		ct       string
+		body     string
+		wantCT   string
+		wantBody string
+		upstream bool
+	}{
+		{"unary", "/svc/Unary", "application/grpc-web+proto", string(grpcFrame(0, "world")),
+			"application/grpc-web+proto",
+			string(grpcFrame(0, "hello world")) + string(grpcFrame(grpcTrailerFrame, "grpc-message: ok\r\ngrpc-status: 0\r\n")), true},
+		{"unary without codec", "/svc/Unary", "application/grpc-web", string(grpcFrame(0, "world")),
+			"application/grpc-web+proto",
+			string(grpcFrame(0, "hello world")) + string(grpcFrame(grpcTrailerFrame, "grpc-message: ok\r\ngrpc-status: 0\r\n")), true},
+		{"server streaming", "/svc/Stream", "application/grpc-web+proto", string(grpcFrame(0, "")),
+			"application/grpc-web+proto",
+			string(grpcFrame(0, "one")) + string(grpcFrame(0, "two")) + string(grpcFrame(grpcTrailerFrame, "grpc-status: 0\r\n")), true},
+		{"trailers only", "/svc/Missing", "application/grpc-web+proto", string(grpcFrame(0, "")),
+			"application/grpc-web+proto",
+			string(grpcFrame(grpcTrailerFrame, "grpc-message: no such thing\r\ngrpc-status: 5\r\n")), true},
+		{"text", "/svc/Unary", "application/grpc-web-text+proto", textBody(grpcFrame(0, "world")),
+			"application/grpc-web-text+proto",
+			string(grpcFrame(0, "hello world")) + string(grpcFrame(grpcTrailerFrame, "grpc-message: ok\r\ngrpc-status: 0\r\n")), true},
+		{"client streaming", "/svc/Unary", "application/grpc-web+proto", string(grpcFrame(0, "a")) + string(grpcFrame(0, "b")),
+			"application/grpc-web+proto",
+			string(grpcFrame(grpcTrailerFrame, "grpc-message: client streaming isn't supported for gRPC-Web\r\ngrpc-status: 12\r\n")), false},
+		{"text client streaming", "/svc/Unary", "application/grpc-web-text", textBody(grpcFrame(0, "a"), grpcFrame(0, "b")),
+			"application/grpc-web-text+proto",
+			string(grpcFrame(grpcTrailerFrame, "grpc-message: client streaming isn't supported for gRPC-Web\r\ngrpc-status: 12\r\n")), false},
+		{"truncated frame", "/svc/Unary", "application/grpc-web+proto", string(grpcFrame(0, "world"))[:7],
+			"application/grpc-web+proto",
+			string(grpcFrame(grpcTrailerFrame, "grpc-message: malformed gRPC-Web request body\r\ngrpc-status: 3\r\n")), false},
+		{"not gRPC-Web", "/svc/Unary", "application/json", "{}", "", "application/json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.ct)
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if tt.wantCT != "" && rec.Header().Get("Content-Type") != tt.wantCT {
+				t.Fatalf("Content-Type = %q, want %q", rec.Header().Get("Content-Type"), tt.wantCT)
+			}
+			body := rec.Body.Bytes()
+			if strings.Contains(tt.wantCT, "-text") {
+				var err error
+				if body, err = decodeGRPCWebText(body); err != nil {
+					t.Fatalf("body %q: %v", rec.Body, err)
+				}
+			}
+			if !bytes.Equal(body, []byte(tt.wantBody)) {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+			if reached := calls.Load() > before; reached != tt.upstream {
+				t.Fatalf("reached upstream = %v, want %v", reached, tt.upstream)
+			}
+			if rec.Result().Trailer.Get("Grpc-Status") != "" {
+				t.Fatal("grpc-status sent as an HTTP trailer")
+			}
+		})
+	}
+}
+
+func TestGRPCWebStreamsMessages(t *testing.T) {
+	release := make(chan struct{})
+	upstream, _ := grpcUpstream(t, release)
+	gw := httptest.NewServer(grpcWebRouter(t, upstream))
+	t.Cleanup(gw.Close)
+
+	resp, err := http.Post(gw.URL+"/svc/Stream", "application/grpc-web+proto", bytes.NewReader(grpcFrame(0, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The first message must arrive while the upstream holds back the
+	// second.
+	first := make([]byte, len(grpcFrame(0, "one")))
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	rest, _ := io.ReadAll(resp.Body)
+	if want := string(grpcFrame(0, "two")) + string(grpcFrame(grpcTrailerFrame, "grpc-status: 0\r\n")); string(rest) != want {
+		t.Fatalf("rest of body = %q, want %q", rest, want)
+	}
+}
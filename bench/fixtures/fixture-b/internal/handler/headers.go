@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// unsettable are headers a rule may not set: hop-by-hop headers, which
+// describe a single connection and are stripped before the edits run, and
+// the framing headers the proxy manages itself.
+var unsettable = map[string]bool{
+	"Connection":          true,
+	"Proxy-Connection":    true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Host":                true,
+}
+
+// validateHeaderEdits checks a rule's header edits: names must be valid
+// header field names, set values can't contain line breaks, and the
+// headers in unsettable can't be set.
+func validateHeaderEdits(rule Rule) error {
+	for _, set := range []map[string]string{rule.SetRequestHeaders, rule.SetResponseHeaders} {
+		for name, v := range set {
+			if !validHeaderName(name) {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+			if unsettable[http.CanonicalHeaderKey(name)] {
+				return fmt.Errorf("header %s can't be set", http.CanonicalHeaderKey(name))
+			}
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return fmt.Errorf("header %s: value contains a line break", name)
+			}
+		}
+	}
+	for _, remove := range [][]string{rule.RemoveRequestHeaders, rule.RemoveResponseHeaders} {
+		for _, name := range remove {
+			if !validHeaderName(name) {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 9110 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
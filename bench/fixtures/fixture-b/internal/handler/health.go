@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadinessCheck reports whether a dependency is usable.
+type ReadinessCheck func(ctx context.Context) error
+
+const readinessTimeout = 2 * time.Second
+
+// Health backs the /healthz and /readyz endpoints.
+type Health struct {
+	ready atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]ReadinessCheck
+}
+
+// RegisterHealth wires /healthz, which always answers 200 while the process
+// is serving, and /readyz, which answers 200 only once SetReady(true) has
+// been called and every registered check passes. Both paths are meant to be
+// exempted from authentication.
+func RegisterHealth(mux *http.ServeMux) *Health {
+	h := &Health{checks: map[string]ReadinessCheck{}}
+	handle(mux, "/healthz", http.HandlerFunc(h.serveLiveness))
+	handle(mux, "/readyz", http.HandlerFunc(h.serveReadiness))
+	return h
+}
+
+// SetReady flips the readiness flag.
+func (h *Health) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// AddCheck registers a named readiness check, replacing any check already
+// registered under name.
+func (h *Health) AddCheck(name string, check ReadinessCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// RemoveCheck unregisters the named readiness check.
+func (h *Health) RemoveCheck(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+// Healthy reports whether every registered check passes, regardless of
+// the readiness flag.
+func (h *Health) Healthy(ctx context.Context) bool {
+	return len(h.runChecks(ctx)) == 0
+}
+
+func (h *Health) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Health) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+
+	failures := h.runChecks(r.Context())
+	if len(failures) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "checks": failures})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// runChecks runs every check concurrently and returns the failures by name.
+func (h *Health) runChecks(ctx context.Context) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures = map[string]string{}
+	)
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failures[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// UpstreamReachable returns a check that succeeds when a TCP connection to
+// the host of any of rawURLs can be opened, so a load-balanced route stays
+// ready while at least one of its upstreams is up.
+func UpstreamReachable(rawURLs ...string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		var errs []error
+		for _, rawURL := range rawURLs {
+			err := dialUpstream(ctx, rawURL)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+}
+
+func dialUpstream(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return fmt.Errorf("upstream unreachable: %w", err)
+	}
+	return conn.Close()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	mux := http.NewServeMux()
+	h := RegisterHealth(mux)
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if got := get("/healthz"); got != http.StatusOK {
+		t.Fatalf("/healthz = %d, want 200", got)
+	}
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz before ready = %d, want 503", got)
+	}
+
+	h.SetReady(true)
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Fatalf("/readyz when ready = %d, want 200", got)
+	}
+
+	var fail error = errors.New("down")
+	h.AddCheck("users", func(ctx context.Context) error { return fail })
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz with failing check = %d, want 503", got)
+	}
+	fail = nil
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Fatalf("/readyz with passing check = %d, want 200", got)
+	}
+	if got := get("/healthz"); got != http.StatusOK {
+		t.Fatalf("/healthz = %d, want 200", got)
+	}
+}
+
+func TestUpstreamReachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	check := UpstreamReachable(srv.URL)
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("reachable upstream: %v", err)
+	}
+	srv.Close()
+	if err := check(context.Background()); err == nil {
+		t.Fatal("closed upstream reported reachable")
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"net/http"
+
+	"api-gateway/internal/apierr"
+)
+
+// Mux is an http.ServeMux whose 404 and 405 responses are JSON errors like
+// the rest of the API, or come from NotFound and MethodNotAllowed when set.
+// Register on the embedded ServeMux as usual; the helpers in this package
+// take it directly:
+//
+//	mux := handler.NewMux()
+//	handler.RegisterRoutes(handler.Group(mux.ServeMux, ""), rt)
+type Mux struct {
+	*http.ServeMux
+	// NotFound serves requests no pattern matches.
+	NotFound http.Handler
+	// MethodNotAllowed serves requests whose path matches only patterns
+	// for other methods. The Allow header is already set when it runs.
+	MethodNotAllowed http.Handler
+}
+
+// NewMux returns an empty Mux with the default JSON error handlers.
+func NewMux() *Mux {
+	return &Mux{ServeMux: http.NewServeMux()}
+}
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := m.Handler(r)
+	if pattern != "" {
+		m.ServeMux.ServeHTTP(w, r)
+		return
+	}
+	// No pattern means ServeMux's own error handler. Its status tells 404
+	// from 405; intercept that and substitute ours.
+	h.ServeHTTP(&muxErrorWriter{ResponseWriter: w, r: r, mux: m}, r)
+}
+
+func (m *Mux) errorHandler(status int) http.Handler {
+	switch status {
+	case http.StatusNotFound:
+		if m.NotFound != nil {
+			return m.NotFound
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apierr.Write(w, r, http.StatusNotFound, apierr.CodeNotFound, "not found")
+		})
+	case http.StatusMethodNotAllowed:
+		if m.MethodNotAllowed != nil {
+			return m.MethodNotAllowed
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apierr.Write(w, r, http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed, "method not allowed")
+		})
+	}
+	return nil
+}
+
+// muxErrorWriter replaces a 404 or 405 from ServeMux with the Mux's handler
+// and discards ServeMux's plain-text body.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	mux      *Mux
+	replaced bool
+}
+
+func (w *muxErrorWriter) WriteHeader(code int) {
+	if h := w.mux.errorHandler(code); h != nil {
+		w.replaced = true
+		// Drop http.Error's text/plain headers; keep Allow.
+		w.Header().Del("Content-Type")
+		w.Header().Del("X-Content-Type-Options")
+		h.ServeHTTP(w.ResponseWriter, w.r)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
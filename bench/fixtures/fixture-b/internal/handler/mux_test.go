@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/apierr"
+)
+
+func TestMuxJSONErrors(t *testing.T) {
+	mux := NewMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "item "+r.PathValue("id"))
+	})
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+		wantType     string
+		wantAllow    string
+	}{
+		{http.MethodGet, "/items/7", http.StatusOK, "item 7", "text/plain; charset=utf-8", ""},
+		{http.MethodGet, "/nope", http.StatusNotFound, `{"error":{"code":"not_found","message":"not found"}}`, "application/json", ""},
+		{http.MethodDelete, "/items/7", http.StatusMethodNotAllowed, `{"error":{"code":"method_not_allowed","message":"method not allowed"}}`, "application/json", "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Fatalf("body = %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Fatalf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestMuxKeepsRedirects(t *testing.T) {
+	mux := NewMux()
+	mux.HandleFunc("/dir/", func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dir", nil))
+	if rec.Code/100 != 3 || rec.Header().Get("Location") != "/dir/" {
+		t.Fatalf("status = %d, Location = %q, want a redirect to /dir/", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestMuxCustomHandlers(t *testing.T) {
+	mux := NewMux()
+	mux.HandleFunc("POST /things", func(w http.ResponseWriter, r *http.Request) {})
+	mux.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierr.Write(w, r, http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed, "use "+w.Header().Get("Allow"))
+	})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("NotFound: status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/things", nil))
+	if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusMethodNotAllowed || got != `{"error":{"code":"method_not_allowed","message":"use POST"}}` {
+		t.Fatalf("MethodNotAllowed: %d %q", rec.Code, got)
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"api-gateway/internal/metrics"
+)
+
+var upstreamConnsGauge = metrics.Default.NewGauge("gateway_upstream_connections",
+	"Connections open to each upstream address, by state: active ones carrying a request, the rest idle.",
+	"upstream", "state")
+
+// pools counts the connections open to, and the requests in flight on,
+// each upstream address across every route's transport.
+var pools = &poolStats{counts: map[string]*poolCount{}}
+
+type poolStats struct {
+	mu     sync.Mutex
+	counts map[string]*poolCount
+}
+
+type poolCount struct {
+	open, active int
+}
+
+// add adjusts addr's counts and republishes its gauges. A connection
+// carrying several HTTP/2 streams is active once per stream, so idle is
+// only exact for HTTP/1.1.
+func (p *poolStats) add(addr string, open, active int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.counts[addr]
+	if c == nil {
+		c = &poolCount{}
+		p.counts[addr] = c
+	}
+	c.open += open
+	c.active += active
+	upstreamConnsGauge.Set(float64(c.active), addr, "active")
+	upstreamConnsGauge.Set(float64(max(c.open-c.active, 0)), addr, "idle")
+}
+
+// countingDialer wraps dial so the connections it opens are counted in
+// pools until closed.
+func countingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		pools.add(addr, 1, 0)
+		return &countedConn{Conn: conn, addr: addr}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	addr string
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { pools.add(c.addr, -1, 0) })
+	return c.Conn.Close()
+}
+
+// poolTransport counts each request as active on its upstream from the
+// round trip until its response body is closed.
+type poolTransport struct {
+	*http.Transport
+}
+
+func (t poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := hostPort(req.URL)
+	pools.add(addr, 0, 1)
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		pools.add(addr, 0, -1)
+		return nil, err
+	}
+	resp.Body = &countedBody{ReadCloser: resp.Body, addr: addr}
+	return resp, nil
+}
+
+// countedBody ends its request's active count on Close. It passes writes
+// through to bodies that take them, as the body of a 101 Switching
+// Protocols response does, so upgraded connections still work.
+type countedBody struct {
+	io.ReadCloser
+	addr string
+	once sync.Once
+}
+
+func (b *countedBody) Write(p []byte) (int, error) {
+	w, ok := b.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, http.ErrNotSupported
+	}
+	return w.Write(p)
+}
+
+func (b *countedBody) Close() error {
+	b.once.Do(func() { pools.add(b.addr, 0, -1) })
+	return b.ReadCloser.Close()
+}
+
+// hostPort is u's host with the scheme's default port filled in, the way
+// a transport names the address it dials.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
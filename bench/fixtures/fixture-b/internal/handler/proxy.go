@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/logging"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/tracing"
+)
+
+// proxyFlushInterval bounds how long streamed bytes of a response with a
+// Content-Length wait in buffers before reaching the client.
+const proxyFlushInterval = 100 * time.Millisecond
+
+// ProxyOption configures NewProxy.
+type ProxyOption func(*proxyConfig)
+
+type proxyConfig struct {
+	transport http.RoundTripper
+	retry     RetryConfig
+	request   headerEdit
+	response  headerEdit
+	claims    map[string]string
+	transform *responseTransform
+	limit     *responseLimit
+}
+
+// headerEdit removes headers, then sets others, replacing any values
+// already there.
+type headerEdit struct {
+	set    map[string]string
+	remove []string
+}
+
+func (e headerEdit) apply(h http.Header) {
+	for _, name := range e.remove {
+		h.Del(name)
+	}
+	for name, v := range e.set {
+		h.Set(name, v)
+	}
+}
+
+// WithRetry retries idempotent requests that fail to connect or get a 502
+// or 503 from the upstream. See RetryConfig for how retries interact with
+// the request's deadline.
+func WithRetry(cfg RetryConfig) ProxyOption {
+	return func(c *proxyConfig) { c.retry = cfg }
+}
+
+// WithTransport sets the transport used to reach the upstream.
+func WithTransport(rt http.RoundTripper) ProxyOption {
+	return func(c *proxyConfig) { c.transport = rt }
+}
+
+// WithRequestHeaders removes the remove headers from requests sent to the
+// upstream, then sets set's, replacing whatever the client sent under
+// those names. Only the outbound copy is edited: middleware in front of
+// the proxy, such as authentication, still sees the client's headers.
+func WithRequestHeaders(set map[string]string, remove []string) ProxyOption {
+	return func(c *proxyConfig) { c.request = headerEdit{set: set, remove: remove} }
+}
+
+// WithResponseHeaders edits the upstream's response headers the same way
+// before they reach the client. Errors the gateway produces itself, such
+// as a 502, are left alone.
+func WithResponseHeaders(set map[string]string, remove []string) ProxyOption {
+	return func(c *proxyConfig) { c.response = headerEdit{set: set, remove: remove} }
+}
+
+// NewProxy returns a handler forwarding requests to target. The inbound path
+// is appended to target's path, the query and body pass through untouched,
+// and X-Forwarded-For/-Host/-Proto are set from the inbound request. Upstream
+// failures produce a 502 JSON error rather than Go's default error text.
+// Hop-by-hop headers, those named in Connection and the fixed set such as
+// Keep-Alive and Transfer-Encoding, are stripped in both directions.
+//
+// The upstream call runs under the request's context: a client disconnect
+// cancels it, and a deadline, such as one set by middleware.Timeout, aborts
+// it with a 504. The span context set by middleware.Tracing, if any, is
+// sent upstream as traceparent, and the time until the upstream's response
+// header, retries included, is reported to middleware.ServerTiming as
+// "upstream".
+//
+// Response bodies are streamed rather than buffered: chunked and
+// event-stream bodies are flushed to the client after every read from the
+// upstream, and bodies of known length at least every proxyFlushInterval,
+// so large downloads use constant memory and slow upstreams still deliver
+// early bytes. Middleware wrapping the proxy must pass Flush through.
+//
+// Upgrade requests, such as WebSocket handshakes, are forwarded with their
+// Connection and Upgrade headers. If the upstream answers 101 the client
+// connection is hijacked and bytes are copied both ways until either side
+// closes, so every ResponseWriter wrapping the proxy must implement
+// http.Hijacker or Unwrap.
+func NewProxy(target *url.URL, opts ...ProxyOption) http.Handler {
+	cfg := proxyConfig{transport: http.DefaultTransport}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	transport := cfg.transport
+	if cfg.retry.Attempts > 1 {
+		transport = newRetryTransport(transport, cfg.retry)
+	}
+	transport = timedTransport{transport}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// The server fills in the inbound trailers' values once the
+			// body has been read; Out's copy of the map would stay empty.
+			pr.Out.Trailer = pr.In.Trailer
+			cfg.request.apply(pr.Out.Header)
+			if cfg.claims != nil {
+				claims, _ := auth.ClaimsFromContext(pr.In.Context())
+				setClaimHeaders(cfg.claims, claims, pr.Out.Header)
+			}
+			tracing.Inject(pr.In.Context(), pr.Out.Header)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			cfg.response.apply(resp.Header)
+			if cfg.transform != nil {
+				cfg.transform.apply(resp)
+			}
+			if cfg.limit != nil {
+				return cfg.limit.apply(resp)
+			}
+			return nil
+		},
+		Transport:     transport,
+		FlushInterval: proxyFlushInterval,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				// The client left and the upstream call was abandoned.
+				// Nobody will read this status, but it keeps the middleware
+				// above from caching or replaying an empty 200.
+				w.WriteHeader(middleware.StatusClientClosedRequest)
+				return
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				apierr.Write(w, r, http.StatusGatewayTimeout, apierr.CodeGatewayTimeout, "gateway timeout")
+				return
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierr.Write(w, r, http.StatusRequestEntityTooLarge, apierr.CodeBodyTooLarge, "request body too large")
+				return
+			}
+			logging.Warnf("proxy: %s %s -> %s: %v", r.Method, r.URL.Path, target.Host, err)
+			if errors.Is(err, errResponseTooLarge) {
+				apierr.Write(w, r, http.StatusBadGateway, apierr.CodeBadGateway, "upstream response too large")
+				return
+			}
+			apierr.Write(w, r, http.StatusBadGateway, apierr.CodeBadGateway, "bad gateway")
+		},
+	}
+}
+
+// timedTransport reports each round trip to middleware.ServerTiming.
+type timedTransport struct {
+	base http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer middleware.StartTiming(req.Context(), "upstream")()
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,662 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"api-gateway/internal/auth"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/tracing"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestProxyForwardsRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":  r.URL.Path,
+			"query": r.URL.RawQuery,
+			"body":  string(body),
+			"xff":   r.Header.Get("X-Forwarded-For"),
+		})
+	}))
+	defer upstream.Close()
+
+	h := NewProxy(mustParse(t, upstream.URL+"/svc"))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/42?expand=true", strings.NewReader(`{"name":"a"}`))
+	req.RemoteAddr = "203.0.113.7:5555"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("status %d, body %q: %v", rec.Code, rec.Body.String(), err)
+	}
+	want := map[string]string{
+		"path":  "/svc/api/v1/users/42",
+		"query": "expand=true",
+		"body":  `{"name":"a"}`,
+		"xff":   "203.0.113.7",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestProxyUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target := mustParse(t, upstream.URL)
+	upstream.Close()
+
+	rec := httptest.NewRecorder()
+	NewProxy(target).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"error":{"code":"bad_gateway","message":"bad gateway"}}` {
+		t.Fatalf("body = %s", got)
+	}
+}
+
+func TestProxyChunkedBodyOverLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	h := middleware.MaxBodyBytes(16)(NewProxy(mustParse(t, upstream.URL)))
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.MultiReader(strings.NewReader(strings.Repeat("a", 64))))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestProxyTimeoutCancelsUpstream(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	h := middleware.Timeout(50 * time.Millisecond)(NewProxy(mustParse(t, upstream.URL)))
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"error":{"code":"gateway_timeout","message":"gateway timeout"}}` {
+		t.Fatalf("body = %s", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request took %v, want it cut off at the deadline", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestProxyClientDisconnectCancelsUpstream(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	rec := httptest.NewRecorder()
+	NewProxy(mustParse(t, upstream.URL)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+	if rec.Code != middleware.StatusClientClosedRequest || rec.Body.Len() != 0 {
+		t.Fatalf("status = %d, body = %q; want a bare 499", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestProxyInjectsTraceparent(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	h := NewProxy(mustParse(t, upstream.URL))
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Traceparent", incoming)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.Get("Traceparent") != incoming {
+		t.Fatalf("untraced request: traceparent = %q, want it passed through", got.Get("Traceparent"))
+	}
+
+	sc, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01")
+	req = req.WithContext(tracing.ContextWithSpanContext(req.Context(), sc))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.Get("Traceparent") != sc.Traceparent() {
+		t.Fatalf("traced request: traceparent = %q, want the gateway span's %q", got.Get("Traceparent"), sc.Traceparent())
+	}
+}
+
+func TestProxyEditsHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Server", "users/1.4")
+		w.Header().Set("X-Frame-Options", "ALLOW")
+		w.Header().Set("Connection", "X-Debug")
+		w.Header().Set("X-Debug", "internal")
+	}))
+	defer upstream.Close()
+
+	var authSaw string
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authSaw = r.Header.Get("Authorization")
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := auth(NewProxy(mustParse(t, upstream.URL),
+		WithRequestHeaders(map[string]string{"X-Internal-Auth": "shared-secret"}, []string{"Authorization", "Cookie"}),
+		WithResponseHeaders(map[string]string{"X-Frame-Options": "DENY"}, []string{"Server"}),
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("X-Internal-Auth", "spoofed")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if authSaw != "Bearer client-token" {
+		t.Fatalf("auth saw Authorization %q, want the client's", authSaw)
+	}
+	if v := got.Values("X-Internal-Auth"); len(v) != 1 || v[0] != "shared-secret" {
+		t.Fatalf("upstream X-Internal-Auth = %q, want only the configured value", v)
+	}
+	for _, name := range []string{"Authorization", "Cookie", "X-Hop", "Keep-Alive"} {
+		if v := got.Get(name); v != "" {
+			t.Errorf("upstream got %s: %q", name, v)
+		}
+	}
+	if req.Header.Get("Authorization") == "" {
+		t.Error("edits leaked into the inbound request")
+	}
+
+	res := rec.Result()
+	if res.Header.Get("X-Frame-Options") != "DENY" || res.Header.Get("Server") != "" {
+		t.Fatalf("response headers = %v", res.Header)
+	}
+	if res.Header.Get("X-Debug") != "" || res.Header.Get("Connection") != "" {
+		t.Fatalf("hop-by-hop response headers passed through: %v", res.Header)
+	}
+}
+
+func TestProxyClaimHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	h := NewProxy(mustParse(t, upstream.URL), WithClaimHeaders(map[string]string{
+		"sub":   "X-User-ID",
+		"email": "X-User-Email",
+		"roles": "X-User-Roles",
+		"admin": "X-User-Admin",
+	}))
+
+	tests := []struct {
+		name   string
+		claims auth.Claims
+		want   map[string]string
+	}{
+		{"no token strips spoofed headers", nil, map[string]string{"X-User-ID": "", "X-User-Email": ""}},
+		{"claims mapped", auth.Claims{"sub": "user-1", "email": "a@example.com", "roles": []any{"admin", "ops"}, "admin": true},
+			map[string]string{"X-User-ID": "user-1", "X-User-Email": "a@example.com", "X-User-Roles": "admin,ops", "X-User-Admin": "true"}},
+		{"missing claim omits header", auth.Claims{"sub": "user-2"}, map[string]string{"X-User-ID": "user-2", "X-User-Email": ""}},
+		{"numeric subject", auth.Claims{"sub": float64(1700000000)}, map[string]string{"X-User-ID": "1700000000"}},
+		{"unsafe value dropped", auth.Claims{"sub": "user-3\r\nX-Admin: 1", "roles": []any{"a", map[string]any{}}},
+			map[string]string{"X-User-ID": "", "X-User-Roles": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User-ID", "spoofed")
+			req.Header.Set("X-User-Email", "spoofed@example.com")
+			req.Header.Set("X-User-Roles", "spoofed")
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithClaims(req.Context(), tt.claims))
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			for name, want := range tt.want {
+				if v := got.Get(name); v != want {
+					t.Errorf("upstream %s = %q, want %q", name, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateClaimHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping map[string]string
+		wantErr bool
+	}{
+		{"valid", map[string]string{"sub": "X-User-ID", "email": "X-User-Email"}, false},
+		{"empty claim", map[string]string{"": "X-User-ID"}, true},
+		{"invalid header name", map[string]string{"sub": "X User"}, true},
+		{"hop-by-hop header", map[string]string{"sub": "Connection"}, true},
+		{"duplicate header", map[string]string{"sub": "X-User-ID", "uid": "x-user-id"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateClaimHeaders(tt.mapping); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyResponseTransformer(t *testing.T) {
+	const original = `{"user_name":"ada"}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		if enc := r.URL.Query().Get("encoding"); enc != "" {
+			w.Header().Set("Content-Encoding", enc)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, original)
+	}))
+	defer upstream.Close()
+
+	rename := func(contentType string, body io.Reader) (io.Reader, error) {
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(strings.ReplaceAll(string(b), `"user_name"`, `"username"`)), nil
+	}
+	tests := []struct {
+		name      string
+		transform Transformer
+		query     string
+		want      string
+	}{
+		{"json renamed", rename, "type=application/json%3B+charset=utf-8", `{"username":"ada"}`},
+		{"other content type", rename, "type=text/plain", original},
+		{"encoded body", rename, "type=application/json&encoding=br", original},
+		{"transformer fails", func(string, io.Reader) (io.Reader, error) {
+			return nil, errors.New("bad schema")
+		}, "type=application/json", original},
+		{"transformer output fails", func(string, io.Reader) (io.Reader, error) {
+			return io.MultiReader(strings.NewReader(`{"user`), iotest.ErrReader(errors.New("truncated"))), nil
+		}, "type=application/json", original},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewProxy(mustParse(t, upstream.URL), WithResponseTransformer("users-v2", tt.transform))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1?"+tt.query, nil))
+
+			if rec.Body.String() != tt.want {
+				t.Fatalf("body = %q, want %q", rec.Body, tt.want)
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(len(tt.want)) {
+				t.Fatalf("Content-Length = %s for a %d-byte body", cl, len(tt.want))
+			}
+			wantETag := `"v1"`
+			if tt.want != original {
+				wantETag = `W/"v1"`
+			}
+			if etag := rec.Header().Get("ETag"); etag != wantETag {
+				t.Fatalf("ETag = %s, want %s", etag, wantETag)
+			}
+		})
+	}
+}
+
+func TestProxyReportsUpstreamTiming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	rec := httptest.NewRecorder()
+	middleware.ServerTiming(NewProxy(mustParse(t, upstream.URL))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	v := rec.Header().Get("Server-Timing")
+	var ms float64
+	if _, err := fmt.Sscanf(v, "upstream;dur=%g", &ms); err != nil || ms < 20 {
+		t.Fatalf("Server-Timing = %q, want upstream of at least 20ms first", v)
+	}
+}
+
+func TestProxyStreamsIncrementally(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength bool
+	}{
+		{"chunked", false},
+		{"content length", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			first, second := strings.Repeat("a", 64), strings.Repeat("b", 64)
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if tt.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(first)+len(second)))
+				}
+				io.WriteString(w, first)
+				w.(http.Flusher).Flush()
+				<-release
+				io.WriteString(w, second)
+			}))
+			defer upstream.Close()
+			defer close(release)
+
+			// The same wrappers the gateway puts around the proxy, including
+			// Gzip, which must not hold back the small first chunk.
+			h := middleware.Chain(
+				middleware.Recover,
+				middleware.ServerTiming,
+				middleware.NewLogger(middleware.TextFormat, io.Discard),
+				middleware.Metrics,
+				middleware.Timeout(5*time.Second),
+				middleware.Gzip,
+			)(NewProxy(mustParse(t, upstream.URL)))
+			gateway := httptest.NewServer(h)
+			defer gateway.Close()
+
+			resp, err := http.Get(gateway.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			got := make(chan string)
+			go func() {
+				buf := make([]byte, len(first))
+				n, _ := io.ReadFull(resp.Body, buf)
+				got <- string(buf[:n])
+			}()
+			select {
+			case chunk := <-got:
+				if chunk != first {
+					t.Fatalf("first chunk = %q", chunk)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("first chunk not delivered while the upstream was still writing")
+			}
+
+			release <- struct{}{}
+			rest, err := io.ReadAll(resp.Body)
+			if err != nil || string(rest) != second {
+				t.Fatalf("rest = %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+// watchedBody is a request body recording whether it has been read.
+type watchedBody struct {
+	r    io.Reader
+	read atomic.Bool
+	done func()
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	n, err := b.r.Read(p)
+	if err == io.EOF && b.done != nil {
+		b.done()
+		b.done = nil
+	}
+	return n, err
+}
+
+func TestProxyExpectContinueAndTrailers(t *testing.T) {
+	var sawExpect atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawExpect.Store(r.Header.Get("Expect"))
+		if r.URL.Path == "/too-large" {
+			// Refuse before reading, so the client's body is never asked for.
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "X-Upstream-Checksum")
+		fmt.Fprintf(w, "%s with %s", body, r.Trailer.Get("X-Checksum"))
+		w.Header().Set("X-Upstream-Checksum", "sum-2")
+	}))
+	defer upstream.Close()
+
+	target := mustParse(t, upstream.URL)
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"proxy", NewProxy(target)},
+		// Retries would buffer the body, reading it before the upstream
+		// agreed to take it.
+		{"with retries", NewProxy(target, WithRetry(RetryConfig{Attempts: 3}))},
+		{"behind middleware", middleware.Chain(
+			middleware.Recover,
+			middleware.ServerTiming,
+			middleware.NewLogger(middleware.TextFormat, io.Discard),
+			middleware.Metrics,
+			middleware.Timeout(5*time.Second),
+			middleware.Gzip,
+		)(NewProxy(target))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := httptest.NewServer(tt.handler)
+			defer gateway.Close()
+			client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+
+			put := func(path string) (*http.Response, *watchedBody) {
+				t.Helper()
+				body := &watchedBody{r: strings.NewReader("big payload")}
+				req, err := http.NewRequest(http.MethodPut, gateway.URL+path, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.ContentLength = -1
+				req.Header.Set("Expect", "100-continue")
+				req.Trailer = http.Header{"X-Checksum": nil}
+				body.done = func() { req.Trailer.Set("X-Checksum", "sum-1") }
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp, body
+			}
+
+			resp, body := put("/too-large")
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusRequestEntityTooLarge || body.read.Load() {
+				t.Fatalf("refused upload: status = %d, body read = %v; want 413 before the body is sent",
+					resp.StatusCode, body.read.Load())
+			}
+			if sawExpect.Load() != "100-continue" {
+				t.Fatalf("upstream saw Expect %q", sawExpect.Load())
+			}
+
+			resp, body = put("/items/1")
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || !body.read.Load() || string(got) != "big payload with sum-1" {
+				t.Fatalf("accepted upload: status = %d, body = %q; want the body and its trailer upstream",
+					resp.StatusCode, got)
+			}
+			if v := resp.Trailer.Get("X-Upstream-Checksum"); v != "sum-2" {
+				t.Fatalf("response trailer = %q, want sum-2", v)
+			}
+		})
+	}
+}
+
+func TestProxyMaxResponseBytes(t *testing.T) {
+	// /length/N answers N bytes with a Content-Length, /chunked/N streams
+	// them without one, and /gzip sends a body it compressed itself.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gzip" {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(bytes.Repeat([]byte("a"), 1000))
+			zw.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(buf.Bytes())
+			return
+		}
+		mode, size, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		n, _ := strconv.Atoi(size)
+		body := strings.Repeat("x", n)
+		if mode == "length" {
+			w.Header().Set("Content-Length", size)
+			io.WriteString(w, body)
+			return
+		}
+		for i := range n {
+			io.WriteString(w, body[i:i+1])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		policy     string
+		path       string
+		wantStatus int
+		wantBody   int
+		wantErr    bool
+	}{
+		{"abort under limit", LimitAbort, "/length/5", http.StatusOK, 5, false},
+		{"abort over Content-Length", LimitAbort, "/length/20", http.StatusBadGateway, -1, false},
+		{"abort streamed at limit", LimitAbort, "/chunked/10", http.StatusOK, 10, false},
+		{"abort streamed over limit", LimitAbort, "/chunked/20", http.StatusOK, -1, true},
+		{"default policy aborts", "", "/chunked/20", http.StatusOK, -1, true},
+		{"truncate over Content-Length", LimitTruncate, "/length/20", http.StatusOK, 10, false},
+		{"truncate streamed", LimitTruncate, "/chunked/20", http.StatusOK, 10, false},
+		{"compressed body counted compressed", LimitAbort, "/gzip", http.StatusOK, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := int64(10)
+			if tt.path == "/gzip" {
+				limit = 100
+			}
+			gateway := httptest.NewServer(NewProxy(mustParse(t, upstream.URL), WithMaxResponseBytes(limit, tt.policy)))
+			defer gateway.Close()
+			req, _ := http.NewRequest(http.MethodGet, gateway.URL+tt.path, nil)
+			// Asking for gzip ourselves keeps the client from decoding it.
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reading body: err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantBody >= 0 && len(body) != tt.wantBody {
+				t.Fatalf("body is %d bytes, want %d", len(body), tt.wantBody)
+			}
+			if tt.path == "/gzip" && resp.Header.Get("Content-Encoding") != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want the upstream's gzip", resp.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestMaxResponseBytesKeepsBreakerClosed(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 20)
+		if r.URL.Path == "/length" {
+			w.Header().Set("Content-Length", "20")
+			io.WriteString(w, body)
+			return
+		}
+		for i := range body {
+			io.WriteString(w, body[i:i+1])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+	rt, err := NewRouter([]Rule{{PathPrefix: "/", UpstreamURL: upstream.URL, MaxResponseBytes: 10, BreakerThreshold: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := httptest.NewServer(rt)
+	defer gateway.Close()
+
+	// Both a 502 for the Content-Length and a body cut off mid-stream.
+	for _, path := range []string{"/length", "/length", "/length", "/chunked", "/chunked", "/chunked"} {
+		resp, err := http.Get(gateway.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if path == "/length" && resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("%s: status = %d, want 502", path, resp.StatusCode)
+		}
+	}
+	if state := rt.Breakers()[0].State(); state != middleware.BreakerClosed {
+		t.Fatalf("breaker = %s after oversized responses, want closed", state)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"api-gateway/internal/logging"
+	"api-gateway/internal/middleware"
+)
+
+// What the proxy does with a response body over a rule's
+// MaxResponseBytes.
+const (
+	// LimitAbort answers 502 if the upstream's Content-Length is over the
+	// limit, and otherwise breaks off the response once the limit is
+	// passed, so the client sees it fail rather than take it as complete.
+	LimitAbort = "abort"
+	// LimitTruncate sends the first MaxResponseBytes of the body as if
+	// they were all of it, and logs a warning.
+	LimitTruncate = "truncate"
+)
+
+// errResponseTooLarge fails a response over the limit under LimitAbort.
+var errResponseTooLarge = errors.New("upstream response body too large")
+
+// responseLimit caps response bodies as they are streamed.
+type responseLimit struct {
+	max    int64
+	policy string
+}
+
+// WithMaxResponseBytes caps the body of each upstream response at max
+// bytes, counted as the body leaves the proxy, after any response
+// transform, and handled by policy, LimitAbort or LimitTruncate. Bodies
+// aren't buffered to be counted. A body the upstream compressed is counted
+// compressed, as it passes through; compression the gateway applies
+// itself, by middleware.Gzip in front of the proxy, comes after the
+// limit, so those bodies are counted uncompressed.
+func WithMaxResponseBytes(max int64, policy string) ProxyOption {
+	return func(c *proxyConfig) { c.limit = &responseLimit{max: max, policy: policy} }
+}
+
+// validateResponseLimit checks a rule's max_response_bytes and
+// response_limit_policy.
+func validateResponseLimit(rule Rule) error {
+	if rule.MaxResponseBytes < 0 {
+		return errors.New("max_response_bytes must not be negative")
+	}
+	switch rule.ResponseLimitPolicy {
+	case "", LimitAbort:
+	case LimitTruncate:
+		// The cache would keep the truncated body and serve it as whole.
+		if rule.CacheTTL > 0 {
+			return errors.New(`response_limit_policy "truncate" can't be combined with cache_ttl`)
+		}
+	default:
+		return fmt.Errorf(`response_limit_policy: want "abort" or "truncate", got %q`, rule.ResponseLimitPolicy)
+	}
+	if rule.ResponseLimitPolicy != "" && rule.MaxResponseBytes == 0 {
+		return errors.New("response_limit_policy without max_response_bytes")
+	}
+	return nil
+}
+
+// apply wraps resp's body to enforce the limit. A Content-Length over it
+// under LimitAbort is an error for the proxy's ErrorHandler; under
+// LimitTruncate the header is cut to the limit.
+func (l *responseLimit) apply(resp *http.Response) error {
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	if resp.ContentLength > l.max {
+		if l.policy != LimitTruncate {
+			// Answering with a large body is no sign of a failing upstream.
+			middleware.UpstreamHealthy(resp.Request.Context())
+			return fmt.Errorf("%w: Content-Length %d over %d", errResponseTooLarge, resp.ContentLength, l.max)
+		}
+		l.warn(resp)
+		resp.ContentLength = l.max
+		resp.Header.Set("Content-Length", strconv.FormatInt(l.max, 10))
+		resp.Body = readCloser{io.LimitReader(resp.Body, l.max), resp.Body}
+		return nil
+	}
+	if resp.ContentLength >= 0 {
+		return nil
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limit: l, resp: resp, left: l.max}
+	return nil
+}
+
+func (l *responseLimit) warn(resp *http.Response) {
+	logging.Warnf("proxy: %s %s -> %s: response body over %d bytes, truncated",
+		resp.Request.Method, resp.Request.URL.Path, resp.Request.URL.Host, l.max)
+}
+
+// limitedBody counts a body of unknown length down from the limit. Once it
+// runs out, the next byte from the upstream ends the body under
+// LimitTruncate and fails it under LimitAbort.
+type limitedBody struct {
+	io.ReadCloser
+	limit *responseLimit
+	resp  *http.Response
+	left  int64
+	done  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	if b.left == 0 {
+		// Read one byte past the limit to tell a body that ends exactly
+		// on it from one that goes on.
+		var one [1]byte
+		n, err := b.ReadCloser.Read(one[:])
+		if n == 0 {
+			return 0, err
+		}
+		if b.limit.policy != LimitTruncate {
+			logging.Warnf("proxy: %s %s -> %s: response body over %d bytes, aborted",
+				b.resp.Request.Method, b.resp.Request.URL.Path, b.resp.Request.URL.Host, b.limit.max)
+			middleware.UpstreamHealthy(b.resp.Request.Context())
+			return 0, errResponseTooLarge
+		}
+		b.limit.warn(b.resp)
+		b.done = true
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
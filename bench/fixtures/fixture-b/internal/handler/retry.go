@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-gateway/internal/logging"
+)
+
+// RetryConfig controls how the proxy retries failed idempotent requests.
+//
+// Retries run inside the request's context, so they share its deadline: a
+// middleware.Timeout budget or client disconnect cuts retries and backoff
+// short rather than extending the request. Size Attempts and Backoff so
+// that the worst case, Attempts upstream calls plus the backoffs between
+// them, fits inside that budget.
+type RetryConfig struct {
+	// Attempts is the total number of tries, including the first. Values
+	// below 2 disable retries.
+	Attempts int
+	// Backoff is the wait before the first retry, doubling on each retry up
+	// to MaxBackoff. Defaults to 100ms.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxBodyBytes caps how much request body is buffered for replay.
+	// Requests with larger bodies are sent once. Defaults to 64KB.
+	MaxBodyBytes int64
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryTransport retries connection errors and 502/503 responses for
+// idempotent methods with exponential backoff.
+type retryTransport struct {
+	base http.RoundTripper
+	cfg  RetryConfig
+}
+
+func newRetryTransport(base http.RoundTripper, cfg RetryConfig) http.RoundTripper {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = 2 * time.Second
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 64 << 10
+	}
+	return &retryTransport{base: base, cfg: cfg}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.cfg.Attempts < 2 || !idempotent(req.Method) || expectsContinue(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		buf, err := io.ReadAll(io.LimitReader(req.Body, t.cfg.MaxBodyBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(buf)) > t.cfg.MaxBodyBytes {
+			// Too big to replay; send what we've read followed by the rest.
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+			return t.base.RoundTrip(req)
+		}
+		req.Body.Close()
+		body = buf
+	}
+
+	backoff := t.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		try := req.Clone(req.Context())
+		if body != nil {
+			try.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.base.RoundTrip(try)
+		if attempt == t.cfg.Attempts || !retryable(req.Context(), resp, err) {
+			return resp, err
+		}
+		logging.Debugf("retry: %s %s: attempt %d of %d: %s; retrying in %v",
+			req.Method, req.URL.Redacted(), attempt, t.cfg.Attempts, outcome(resp, err), backoff)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, t.cfg.MaxBackoff)
+	}
+}
+
+// expectsContinue reports whether the client waits for the upstream's 100
+// Continue before sending its body. Buffering the body for retries would
+// ask for it straight away, so such requests are sent once.
+func expectsContinue(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody &&
+		strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		return !errors.As(err, &tooLarge)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// outcome describes a failed attempt for the debug log.
+func outcome(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyUpstream fails the first n requests with status, then echoes the body.
+func flakyUpstream(t *testing.T, n int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= n {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestProxyRetries(t *testing.T) {
+	retry := WithRetry(RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBodyBytes: 16})
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		failures  int32
+		status    int
+		wantCode  int
+		wantCalls int32
+	}{
+		{"GET recovers after 503s", http.MethodGet, "", 2, http.StatusServiceUnavailable, http.StatusOK, 3},
+		{"GET gives up after attempts", http.MethodGet, "", 5, http.StatusBadGateway, http.StatusBadGateway, 3},
+		{"PUT replays buffered body", http.MethodPut, "payload", 1, http.StatusBadGateway, http.StatusOK, 2},
+		{"POST is not retried", http.MethodPost, "payload", 1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 1},
+		{"500 is not retried", http.MethodGet, "", 1, http.StatusInternalServerError, http.StatusInternalServerError, 1},
+		{"oversized body sent once", http.MethodPut, strings.Repeat("x", 32), 1, http.StatusServiceUnavailable, http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := flakyUpstream(t, tt.failures, tt.status)
+			rec := httptest.NewRecorder()
+			NewProxy(mustParse(t, srv.URL), retry).ServeHTTP(rec, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != tt.body {
+				t.Fatalf("upstream saw body %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffRespectsDeadline(t *testing.T) {
+	srv, calls := flakyUpstream(t, 100, http.StatusServiceUnavailable)
+	rt := newRetryTransport(http.DefaultTransport, RetryConfig{Attempts: 5, Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	_, err := rt.RoundTrip(req)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second || calls.Load() != 1 {
+		t.Fatalf("backoff outlived the deadline: %v, %d calls", time.Since(start), calls.Load())
+	}
+}
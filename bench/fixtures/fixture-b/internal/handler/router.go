@@ -0,0 +1,748 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/health"
+	"api-gateway/internal/middleware"
+)
+
+// Rule maps requests under PathPrefix to an upstream: the single
+// UpstreamURL, a weighted set of Upstreams, or a traffic split between
+// Variants, exactly one of them.
+type Rule struct {
+	PathPrefix string `json:"path_prefix"`
+	// Methods, if set, limits the rule to those request methods; GET also
+	// admits HEAD. Several rules may share a prefix with disjoint methods,
+	// and a request for a method none of them accept gets 405. A rule
+	// without Methods takes every method its siblings don't claim.
+	Methods     []string   `json:"methods,omitempty"`
+	UpstreamURL string     `json:"upstream_url,omitempty"`
+	Upstreams   []Upstream `json:"upstreams,omitempty"`
+	// Variants splits the route's traffic by percentage between versions
+	// of its upstream, for canary releases. Sticky, if set, keeps a client
+	// on one variant: "sub" keys on the token's sub claim, "cookie:NAME"
+	// on a cookie's value. See splitter.
+	Variants []Variant `json:"variants,omitempty"`
+	Sticky   string    `json:"sticky,omitempty"`
+	// Timeout, if set, replaces the gateway's request timeout for the
+	// route; see Router.Timeout.
+	Timeout Duration `json:"timeout,omitempty"`
+	// MaxInFlight, if set, caps the route's concurrent requests; see
+	// middleware.NewMaxInFlight.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+	// UpstreamProtocol selects the protocol spoken to the rule's upstreams,
+	// ProtocolHTTP1 or ProtocolHTTP2; empty negotiates as
+	// http.DefaultTransport does. MaxIdleConns, MaxIdleConnsPerHost and
+	// IdleConnTimeout tune connection reuse, defaulting to 100, 32 and 90s,
+	// and DialTimeout and TLSHandshakeTimeout bound connecting, both
+	// defaulting to 5s.
+	UpstreamProtocol    string   `json:"upstream_protocol,omitempty"`
+	MaxIdleConns        int      `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     Duration `json:"idle_conn_timeout,omitempty"`
+	DialTimeout         Duration `json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout Duration `json:"tls_handshake_timeout,omitempty"`
+	// GRPCWeb translates gRPC-Web requests from browsers into gRPC for the
+	// rule's upstreams, which must be reached over HTTP/2; see grpcWeb.
+	GRPCWeb bool `json:"grpc_web,omitempty"`
+	// Scope, if set, is a token scope required to reach the route.
+	Scope string `json:"scope,omitempty"`
+	// ClientNames, if set, requires a verified TLS client certificate
+	// naming one of them in its common name or SANs, "*" accepting any;
+	// see auth.RequireClientCert. It applies on top of token auth.
+	ClientNames []string `json:"client_names,omitempty"`
+	// AllowIPs, if set, admits only clients whose address is in one of its
+	// CIDRs, and DenyIPs turns away those in any of its own; both answer
+	// 403 ahead of the route's other checks. See middleware.IPAllowList.
+	AllowIPs []string `json:"allow_ips,omitempty"`
+	DenyIPs  []string `json:"deny_ips,omitempty"`
+	// BreakerThreshold and BreakerCooldown tune the circuit breaker kept
+	// for each upstream; zero values take the breaker defaults.
+	BreakerThreshold int      `json:"breaker_threshold,omitempty"`
+	BreakerCooldown  Duration `json:"breaker_cooldown,omitempty"`
+	// RetryAttempts, if above 1, retries failed idempotent requests with
+	// exponential backoff starting at RetryBackoff.
+	RetryAttempts int      `json:"retry_attempts,omitempty"`
+	RetryBackoff  Duration `json:"retry_backoff,omitempty"`
+	// HealthPath, if set, enables active health checks: each upstream is
+	// probed with GET HealthPath every HealthInterval (default 10s), each
+	// probe bounded by HealthTimeout (default 2s).
+	HealthPath     string   `json:"health_path,omitempty"`
+	HealthInterval Duration `json:"health_interval,omitempty"`
+	HealthTimeout  Duration `json:"health_timeout,omitempty"`
+	// CacheTTL, if set, caches the route's GET responses, for CacheTTL when
+	// the upstream doesn't give a max-age. Caching sits behind the scope
+	// check, so a cached response is only served to authorized clients.
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+	// DumpBody logs the route's request and response bodies when the
+	// router has a dumper; see WithBodyDump. Sensitive routes are never
+	// dumped, and a rule can't set both.
+	DumpBody  bool `json:"dump_body,omitempty"`
+	Sensitive bool `json:"sensitive,omitempty"`
+	// SetRequestHeaders and RemoveRequestHeaders edit the request sent
+	// upstream, removals first, so a set header always replaces what the
+	// client sent; SetResponseHeaders and RemoveResponseHeaders do the
+	// same to the upstream's response. See WithRequestHeaders.
+	SetRequestHeaders     map[string]string `json:"set_request_headers,omitempty"`
+	RemoveRequestHeaders  []string          `json:"remove_request_headers,omitempty"`
+	SetResponseHeaders    map[string]string `json:"set_response_headers,omitempty"`
+	RemoveResponseHeaders []string          `json:"remove_response_headers,omitempty"`
+	// ResponseTransform names a Transformer, given to the router with
+	// WithTransformer, that rewrites the route's response bodies of
+	// TransformContentTypes, "application/json" by default. See
+	// WithResponseTransformer.
+	ResponseTransform     string   `json:"response_transform,omitempty"`
+	TransformContentTypes []string `json:"transform_content_types,omitempty"`
+	// MaxResponseBytes, if set, caps the bodies of the upstream's responses
+	// as they stream through, a longer one being handled according to
+	// ResponseLimitPolicy, LimitAbort by default or LimitTruncate. See
+	// WithMaxResponseBytes.
+	MaxResponseBytes    int64  `json:"max_response_bytes,omitempty"`
+	ResponseLimitPolicy string `json:"response_limit_policy,omitempty"`
+	// Fallback, if set, is served to GET and HEAD requests in place of a
+	// 502, 503 or 504, such as while the route's circuit is open.
+	Fallback *Fallback `json:"fallback,omitempty"`
+	// Shadow, if set, mirrors a share of the route's requests to a second
+	// upstream whose responses are discarded; see shadower.
+	Shadow *Shadow `json:"shadow,omitempty"`
+}
+
+// Name identifies the rule in metrics and health checks: its prefix,
+// preceded by its methods when it has any.
+func (rule Rule) Name() string {
+	if len(rule.Methods) == 0 {
+		return rule.PathPrefix
+	}
+	return strings.Join(rule.Methods, ",") + " " + rule.PathPrefix
+}
+
+// Redacted returns rule with any passwords in its upstream URLs, its
+// shadow's included, and the values of its set request headers, which
+// often carry shared secrets, masked for display.
+func (rule Rule) Redacted() Rule {
+	rule.UpstreamURL = redactURL(rule.UpstreamURL)
+	if rule.SetRequestHeaders != nil {
+		masked := make(map[string]string, len(rule.SetRequestHeaders))
+		for name := range rule.SetRequestHeaders {
+			masked[name] = "xxxxx"
+		}
+		rule.SetRequestHeaders = masked
+	}
+	rule.Upstreams = redactUpstreams(rule.Upstreams)
+	rule.Variants = slices.Clone(rule.Variants)
+	for i := range rule.Variants {
+		v := &rule.Variants[i]
+		v.UpstreamURL = redactURL(v.UpstreamURL)
+		v.Upstreams = redactUpstreams(v.Upstreams)
+	}
+	if rule.Shadow != nil {
+		shadow := *rule.Shadow
+		shadow.UpstreamURL = redactURL(shadow.UpstreamURL)
+		rule.Shadow = &shadow
+	}
+	return rule
+}
+
+func redactUpstreams(ups []Upstream) []Upstream {
+	ups = slices.Clone(ups)
+	for i := range ups {
+		ups[i].URL = redactURL(ups[i].URL)
+	}
+	return ups
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// Targets returns the rule's upstreams, treating UpstreamURL as a single
+// upstream of weight 1. A split rule's are those of all its variants.
+func (rule Rule) Targets() []Upstream {
+	if rule.UpstreamURL != "" {
+		return []Upstream{{URL: rule.UpstreamURL, Weight: 1}}
+	}
+	if len(rule.Variants) > 0 {
+		var targets []Upstream
+		for _, v := range rule.Variants {
+			targets = append(targets, v.Targets()...)
+		}
+		return targets
+	}
+	return rule.Upstreams
+}
+
+// HealthTargets returns the upstreams of rules that enable active health
+// checks, ready for health.NewChecker.
+func HealthTargets(rules []Rule) []health.Target {
+	var targets []health.Target
+	for _, rule := range rules {
+		if rule.HealthPath == "" {
+			continue
+		}
+		for _, up := range rule.Targets() {
+			targets = append(targets, health.Target{
+				URL:      up.URL,
+				Path:     rule.HealthPath,
+				Interval: time.Duration(rule.HealthInterval),
+				Timeout:  time.Duration(rule.HealthTimeout),
+			})
+		}
+	}
+	return targets
+}
+
+// Router proxies each request to the upstream of the longest matching rule.
+// A prefix matches its own path and anything below it on a segment boundary:
+// "/api/v1/users" matches "/api/v1/users" and "/api/v1/users/42" but not
+// "/api/v1/usersearch".
+type Router struct {
+	checker *health.Checker
+	cache   middleware.CacheStore
+	dump    middleware.Middleware
+	claims  map[string]string
+	// transformers are the Transformers rules can name.
+	transformers map[string]Transformer
+
+	mu    sync.Mutex // serializes Update
+	table atomic.Pointer[routeTable]
+}
+
+type routeTable struct {
+	rules      []Rule
+	routes     []route
+	breakers   []*middleware.CircuitBreaker
+	upstreams  []upstreamRef
+	transports []poolTransport
+}
+
+// upstreamRef ties a target to the rule it serves, for Upstreams.
+type upstreamRef struct {
+	route   string
+	variant string
+	target  *target
+}
+
+// RouterOption configures NewRouter.
+type RouterOption func(*Router)
+
+// WithHealthChecker takes upstreams that c reports unhealthy out of their
+// route's rotation.
+func WithHealthChecker(c *health.Checker) RouterOption {
+	return func(rt *Router) { rt.checker = c }
+}
+
+type route struct {
+	prefix   string
+	template string
+	// handler serves methods not in methods; nil means they get 405.
+	handler http.Handler
+	methods map[string]http.Handler
+	// timeouts holds the rules' Timeouts by methods key, "" for handler's.
+	timeouts map[string]time.Duration
+}
+
+// key returns the methods key serving method, or "" if the route's handler
+// does. HEAD falls back to GET, as in http.ServeMux.
+func (rte *route) key(method string) string {
+	if _, ok := rte.methods[method]; ok {
+		return method
+	}
+	if method == http.MethodHead {
+		if _, ok := rte.methods[http.MethodGet]; ok {
+			return http.MethodGet
+		}
+	}
+	return ""
+}
+
+// lookup returns the handler for method, or nil if the route doesn't
+// accept it.
+func (rte *route) lookup(method string) http.Handler {
+	if k := rte.key(method); k != "" {
+		return rte.methods[k]
+	}
+	return rte.handler
+}
+
+// allow lists the route's methods for a 405's Allow header.
+func (rte *route) allow() string {
+	methods := make([]string, 0, len(rte.methods)+1)
+	for m := range rte.methods {
+		methods = append(methods, m)
+	}
+	if _, ok := rte.methods[http.MethodGet]; ok {
+		if _, ok := rte.methods[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// WithCacheStore sets the store shared by routes with a CacheTTL. Defaults
+// to a 64MB in-memory LRU.
+func WithCacheStore(s middleware.CacheStore) RouterOption {
+	return func(rt *Router) { rt.cache = s }
+}
+
+// WithBodyDump wraps routes with DumpBody set in mw, normally
+// middleware.NewDumpBody. Without it DumpBody has no effect.
+func WithBodyDump(mw middleware.Middleware) RouterOption {
+	return func(rt *Router) { rt.dump = mw }
+}
+
+// WithTransformer makes t available to rules as their response_transform
+// under name.
+func WithTransformer(name string, t Transformer) RouterOption {
+	return func(rt *Router) {
+		if rt.transformers == nil {
+			rt.transformers = map[string]Transformer{}
+		}
+		rt.transformers[name] = t
+	}
+}
+
+// WithUpstreamClaims sends validated claims to every route's upstreams as
+// headers, and strips those headers from client requests; see
+// WithClaimHeaders. Check the mapping with ValidateClaimHeaders first.
+func WithUpstreamClaims(claimHeaders map[string]string) RouterOption {
+	return func(rt *Router) { rt.claims = claimHeaders }
+}
+
+// ValidateRules checks a routing table without building it: each prefix
+// must start with "/", prefixes may only repeat with disjoint upper-case
+// methods, each rule needs exactly one form of absolute upstream URL with
+// non-negative weights, variant percents add up to 100, header edits,
+// fallbacks and shadows are well-formed, sensitive rules can't dump
+// bodies, and gRPC-Web rules speak HTTP/2 to their upstreams.
+func ValidateRules(rules []Rule) error {
+	type claimed struct {
+		any     bool
+		methods map[string]bool
+	}
+	byPrefix := make(map[string]*claimed, len(rules))
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return fmt.Errorf("route %q: path prefix must start with /", rule.PathPrefix)
+		}
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/")
+		c, ok := byPrefix[prefix]
+		if !ok {
+			c = &claimed{methods: map[string]bool{}}
+			byPrefix[prefix] = c
+		}
+		if len(rule.Methods) == 0 {
+			if c.any {
+				return fmt.Errorf("route %q: duplicate path prefix", rule.PathPrefix)
+			}
+			c.any = true
+		}
+		for _, m := range rule.Methods {
+			if m == "" || m != strings.ToUpper(m) {
+				return fmt.Errorf("route %q: method %q must be upper case", rule.PathPrefix, m)
+			}
+			if c.methods[m] {
+				return fmt.Errorf("route %q: duplicate path prefix for %s", rule.PathPrefix, m)
+			}
+			c.methods[m] = true
+		}
+
+		if rule.DumpBody && rule.Sensitive {
+			return fmt.Errorf("route %q: dump_body is not allowed on a sensitive route", rule.PathPrefix)
+		}
+		if rule.Timeout < 0 {
+			return fmt.Errorf("route %q: timeout must not be negative", rule.PathPrefix)
+		}
+		if rule.MaxInFlight < 0 {
+			return fmt.Errorf("route %q: max_in_flight must not be negative", rule.PathPrefix)
+		}
+		if slices.Contains(rule.ClientNames, "") {
+			return fmt.Errorf("route %q: client_names must not contain an empty name", rule.PathPrefix)
+		}
+		if err := middleware.ValidateIPList(rule.AllowIPs); err != nil {
+			return fmt.Errorf("route %q: allow_ips: %w", rule.PathPrefix, err)
+		}
+		if err := middleware.ValidateIPList(rule.DenyIPs); err != nil {
+			return fmt.Errorf("route %q: deny_ips: %w", rule.PathPrefix, err)
+		}
+		if err := validateTransport(rule); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if err := validateHeaderEdits(rule); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if err := validateSplit(rule); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if err := validateResponseLimit(rule); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if err := validateFallback(rule.Fallback); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if err := validateShadow(rule.Shadow); err != nil {
+			return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+		}
+		if rule.GRPCWeb && rule.UpstreamProtocol != ProtocolHTTP2 {
+			return fmt.Errorf(`route %q: grpc_web requires upstream_protocol "h2"`, rule.PathPrefix)
+		}
+		if rule.GRPCWeb && rule.ResponseTransform != "" {
+			return fmt.Errorf("route %q: grpc_web can't be combined with response_transform", rule.PathPrefix)
+		}
+		if len(rule.TransformContentTypes) > 0 && rule.ResponseTransform == "" {
+			return fmt.Errorf("route %q: transform_content_types without response_transform", rule.PathPrefix)
+		}
+		for _, ct := range rule.TransformContentTypes {
+			if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != ct {
+				return fmt.Errorf("route %q: invalid transform content type %q", rule.PathPrefix, ct)
+			}
+		}
+
+		switch {
+		case rule.UpstreamURL != "" && len(rule.Upstreams) > 0:
+			return fmt.Errorf("route %q: set upstream_url or upstreams, not both", rule.PathPrefix)
+		case len(rule.Targets()) == 0:
+			return fmt.Errorf("route %q: no upstream configured", rule.PathPrefix)
+		}
+		for _, up := range rule.Targets() {
+			u, err := url.Parse(up.URL)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("route %q: invalid upstream URL %q", rule.PathPrefix, up.URL)
+			}
+			if up.Weight < 0 {
+				return fmt.Errorf("route %q: negative weight for %q", rule.PathPrefix, up.URL)
+			}
+		}
+	}
+	return nil
+}
+
+// NewRouter validates rules and builds a proxy for each.
+func NewRouter(rules []Rule, opts ...RouterOption) (*Router, error) {
+	rt := &Router{}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	if err := rt.Update(rules); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// Update validates rules and swaps them in as the routing table. Requests
+// already routed finish against the table they started with; new requests
+// use the new one. Invalid rules leave the current table in place.
+//
+// Each update builds fresh proxies and circuit breakers, so breaker state
+// starts over for every route.
+func (rt *Router) Update(rules []Rule) error {
+	if err := ValidateRules(rules); err != nil {
+		return err
+	}
+	fallbacks := make([]middleware.Middleware, len(rules))
+	for i, rule := range rules {
+		if name := rule.ResponseTransform; name != "" && rt.transformers[name] == nil {
+			return fmt.Errorf("route %q: unknown response_transform %q", rule.PathPrefix, name)
+		}
+		if rule.Fallback != nil {
+			cfg, err := rule.Fallback.load()
+			if err != nil {
+				return fmt.Errorf("route %q: %w", rule.PathPrefix, err)
+			}
+			fallbacks[i] = middleware.NewFallback(cfg)
+		}
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	t := &routeTable{rules: slices.Clone(rules)}
+	byPrefix := make(map[string]*route, len(rules))
+	for i, rule := range rules {
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/")
+		rte, ok := byPrefix[prefix]
+		if !ok {
+			rte = &route{
+				prefix:   prefix,
+				template: rule.PathPrefix,
+				methods:  map[string]http.Handler{},
+				timeouts: map[string]time.Duration{},
+			}
+			byPrefix[prefix] = rte
+		}
+
+		// The layers nearest the upstream. A split rule gets a set per
+		// variant, so its cache keeps each variant's responses apart.
+		var shadow func(http.Handler) http.Handler
+		if rule.Shadow != nil {
+			shadow = rt.newShadow(t, rule)
+		}
+		if rule.CacheTTL > 0 && rt.cache == nil {
+			rt.cache = middleware.NewLRUStore(64 << 20)
+		}
+		h := rt.newUpstream(t, rule, func(variant string, h http.Handler) http.Handler {
+			if shadow != nil {
+				h = shadow(h)
+			}
+			if rule.GRPCWeb {
+				h = grpcWeb(h)
+			}
+			if rule.CacheTTL > 0 {
+				// Claims sent upstream vary the response by user.
+				h = middleware.NewCache(middleware.CacheConfig{
+					Store:      rt.cache,
+					DefaultTTL: time.Duration(rule.CacheTTL),
+					KeyClaims:  slices.Sorted(maps.Keys(rt.claims)),
+					KeyPrefix:  variant,
+				})(h)
+			}
+			return h
+		})
+		// Fallbacks sit in front of the cache, which would otherwise keep
+		// them, and behind the checks below, so they are still enforced.
+		if fallbacks[i] != nil {
+			h = fallbacks[i](h)
+		}
+		if rule.Scope != "" {
+			h = auth.RequireScope(rule.Scope)(h)
+		}
+		if len(rule.ClientNames) > 0 {
+			h = auth.RequireClientCert(rule.ClientNames...)(h)
+		}
+		if rule.MaxInFlight > 0 {
+			h = middleware.NewMaxInFlight(rule.Name(), rule.MaxInFlight)(h)
+		}
+		// Clients turned away by address don't take in-flight slots.
+		h = middleware.IPDenyList(rule.DenyIPs)(h)
+		h = middleware.IPAllowList(rule.AllowIPs)(h)
+		if rt.dump != nil && rule.DumpBody && !rule.Sensitive {
+			h = rt.dump(h)
+		}
+		if len(rule.Methods) == 0 {
+			rte.handler = h
+			rte.timeouts[""] = time.Duration(rule.Timeout)
+		}
+		for _, m := range rule.Methods {
+			rte.methods[m] = h
+			rte.timeouts[m] = time.Duration(rule.Timeout)
+		}
+	}
+	for _, rte := range byPrefix {
+		t.routes = append(t.routes, *rte)
+	}
+	sort.Slice(t.routes, func(i, j int) bool {
+		return len(t.routes[i].prefix) > len(t.routes[j].prefix)
+	})
+	// Connections left idle in the old table's transports would otherwise
+	// linger until they time out.
+	if old := rt.table.Swap(t); old != nil {
+		for _, tr := range old.transports {
+			tr.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// newUpstream builds the proxies for a rule that passed ValidateRules: a
+// balancer over its upstreams, or a splitter over one per variant. wrap
+// adds the layers that go between the balancers and the split, given the
+// variant's name or "".
+func (rt *Router) newUpstream(t *routeTable, rule Rule, wrap func(variant string, h http.Handler) http.Handler) http.Handler {
+	if len(rule.Variants) == 0 {
+		return wrap("", rt.newBalancer(t, rule, "", rule.Targets()))
+	}
+	s := &splitter{}
+	s.key, _ = stickyKey(rule.Sticky)
+	var end float64
+	for _, v := range rule.Variants {
+		end += v.Percent
+		lb := rt.newBalancer(t, rule, v.Name, v.Targets())
+		s.variants = append(s.variants, splitVariant{
+			percent: v.Percent,
+			end:     end,
+			lb:      lb,
+			handler: wrap(v.Name, lb),
+		})
+	}
+	return s
+}
+
+// newBalancer builds a balancer over targets, the upstreams of rule or of
+// its named variant.
+func (rt *Router) newBalancer(t *routeTable, rule Rule, variant string, targets []Upstream) *balancer {
+	lb := &balancer{}
+	if rt.checker != nil {
+		lb.healthy = rt.checker.Healthy
+	}
+	transport := newTransport(rule)
+	t.transports = append(t.transports, transport)
+	for _, up := range targets {
+		u, _ := url.Parse(up.URL)
+		weight := max(up.Weight, 1)
+
+		// Single-upstream routes keep the route's name in breaker metrics.
+		name := rule.Name()
+		if variant != "" {
+			name += " " + variant
+		}
+		if len(targets) > 1 {
+			name += " " + u.Host
+		}
+		breaker := middleware.NewCircuitBreaker(name, middleware.BreakerConfig{
+			FailureThreshold: rule.BreakerThreshold,
+			Cooldown:         time.Duration(rule.BreakerCooldown),
+		})
+		t.breakers = append(t.breakers, breaker)
+		opts := []ProxyOption{
+			WithTransport(transport),
+			WithRetry(RetryConfig{
+				Attempts: rule.RetryAttempts,
+				Backoff:  time.Duration(rule.RetryBackoff),
+			}),
+			WithRequestHeaders(rule.SetRequestHeaders, rule.RemoveRequestHeaders),
+			WithResponseHeaders(rule.SetResponseHeaders, rule.RemoveResponseHeaders),
+			WithClaimHeaders(rt.claims),
+		}
+		if name := rule.ResponseTransform; name != "" {
+			opts = append(opts, WithResponseTransformer(name, rt.transformers[name], rule.TransformContentTypes...))
+		}
+		if rule.MaxResponseBytes > 0 {
+			opts = append(opts, WithMaxResponseBytes(rule.MaxResponseBytes, rule.ResponseLimitPolicy))
+		}
+		proxy := NewProxy(u, opts...)
+		tg := &target{
+			url:     up.URL,
+			handler: breaker.Middleware(proxy),
+			breaker: breaker,
+			weight:  weight,
+		}
+		lb.targets = append(lb.targets, tg)
+		t.upstreams = append(t.upstreams, upstreamRef{route: rule.Name(), variant: variant, target: tg})
+	}
+	return lb
+}
+
+// Breakers returns the circuit breaker guarding each upstream.
+func (rt *Router) Breakers() []*middleware.CircuitBreaker {
+	return rt.table.Load().breakers
+}
+
+// Rules returns the rules the current routing table was built from.
+func (rt *Router) Rules() []Rule {
+	return slices.Clone(rt.table.Load().rules)
+}
+
+// UpstreamState is a snapshot of one upstream of the current table.
+type UpstreamState struct {
+	Route   string `json:"route"`
+	Variant string `json:"variant,omitempty"`
+	URL     string `json:"url"`
+	Weight  int    `json:"weight"`
+	Breaker string `json:"breaker"`
+	// Health is the last active health check, for routes with HealthPath
+	// whose upstream has been probed.
+	Health *health.Status `json:"health,omitempty"`
+}
+
+// Upstreams reports the circuit breaker and health check state of every
+// upstream, in rule order.
+func (rt *Router) Upstreams() []UpstreamState {
+	var statuses map[string]health.Status
+	if rt.checker != nil {
+		statuses = rt.checker.Statuses()
+	}
+	refs := rt.table.Load().upstreams
+	states := make([]UpstreamState, 0, len(refs))
+	for _, ref := range refs {
+		st := UpstreamState{
+			Route:   ref.route,
+			Variant: ref.variant,
+			URL:     ref.target.url,
+			Weight:  ref.target.weight,
+			Breaker: ref.target.breaker.State().String(),
+		}
+		if hs, ok := statuses[ref.target.url]; ok {
+			st.Health = &hs
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Timeout returns the Timeout of the rule that would serve r, or zero if
+// that rule has none or no rule matches. It is meant for
+// middleware.TimeoutFunc, which runs before the router.
+func (rt *Router) Timeout(r *http.Request) time.Duration {
+	for _, route := range rt.table.Load().routes {
+		if matchPrefix(route.prefix, r.URL.Path) {
+			return route.timeouts[route.key(r.Method)]
+		}
+	}
+	return 0
+}
+
+// RouteTemplate returns the path prefix of the rule that would serve r, as
+// reported in metrics, or "" if no rule matches.
+func (rt *Router) RouteTemplate(r *http.Request) string {
+	for _, route := range rt.table.Load().routes {
+		if matchPrefix(route.prefix, r.URL.Path) {
+			return route.template
+		}
+	}
+	return ""
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.table.Load().routes {
+		if matchPrefix(route.prefix, r.URL.Path) {
+			middleware.SetRoute(r.Context(), route.template)
+			h := route.lookup(r.Method)
+			if h == nil {
+				w.Header().Set("Allow", route.allow())
+				apierr.Write(w, r, http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed, "method not allowed")
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	apierr.Write(w, r, http.StatusNotFound, apierr.CodeNotFound, "not found")
+}
+
+func matchPrefix(prefix, path string) bool {
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,596 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/auth"
+	"api-gateway/internal/metrics"
+	"api-gateway/internal/middleware"
+)
+
+func namedUpstream(t *testing.T, name string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestRouterLongestPrefix(t *testing.T) {
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/api", UpstreamURL: namedUpstream(t, "api")},
+		{PathPrefix: "/api/v1/users", UpstreamURL: namedUpstream(t, "users")},
+		{PathPrefix: "/api/v1/users/admin/", UpstreamURL: namedUpstream(t, "admin")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/api/v1/users", http.StatusOK, "users"},
+		{"/api/v1/users/42", http.StatusOK, "users"},
+		{"/api/v1/users/admin", http.StatusOK, "admin"},
+		{"/api/v1/users/admin/keys", http.StatusOK, "admin"},
+		{"/api/v1/usersearch", http.StatusOK, "api"},
+		{"/api/v2/things", http.StatusOK, "api"},
+		{"/apix", http.StatusNotFound, `{"error":{"code":"not_found","message":"not found"}}`},
+		{"/", http.StatusNotFound, `{"error":{"code":"not_found","message":"not found"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Fatalf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewRouterRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"relative upstream", []Rule{{PathPrefix: "/api", UpstreamURL: "localhost:3001"}}},
+		{"unparseable upstream", []Rule{{PathPrefix: "/api", UpstreamURL: "http://[::1"}}},
+		{"prefix without slash", []Rule{{PathPrefix: "api", UpstreamURL: "http://localhost:3001"}}},
+		{"no upstream", []Rule{{PathPrefix: "/api"}}},
+		{"both upstream forms", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Upstreams: []Upstream{{URL: "http://localhost:3002"}}}}},
+		{"relative upstream in set", []Rule{{PathPrefix: "/api", Upstreams: []Upstream{{URL: "localhost:3002"}}}}},
+		{"negative weight", []Rule{{PathPrefix: "/api", Upstreams: []Upstream{{URL: "http://localhost:3002", Weight: -1}}}}},
+		{"overlapping methods", []Rule{
+			{PathPrefix: "/api", Methods: []string{"GET", "POST"}, UpstreamURL: "http://localhost:3001"},
+			{PathPrefix: "/api", Methods: []string{"POST"}, UpstreamURL: "http://localhost:3002"},
+		}},
+		{"lower-case method", []Rule{{PathPrefix: "/api", Methods: []string{"get"}, UpstreamURL: "http://localhost:3001"}}},
+		{"unknown upstream protocol", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", UpstreamProtocol: "spdy"}}},
+		{"grpc_web without h2", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", GRPCWeb: true}}},
+		{"grpc_web with transform", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", GRPCWeb: true,
+			UpstreamProtocol: ProtocolHTTP2, ResponseTransform: "v2"}}},
+		{"transform content types without transform", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			TransformContentTypes: []string{"application/json"}}}},
+		{"transform content type with parameters", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			ResponseTransform: "v2", TransformContentTypes: []string{"application/json; charset=utf-8"}}}},
+		{"negative max response bytes", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", MaxResponseBytes: -1}}},
+		{"unknown response limit policy", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			MaxResponseBytes: 1 << 20, ResponseLimitPolicy: "drop"}}},
+		{"response limit policy without limit", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			ResponseLimitPolicy: LimitTruncate}}},
+		{"truncate with cache", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			MaxResponseBytes: 1 << 20, ResponseLimitPolicy: LimitTruncate, CacheTTL: Duration(time.Minute)}}},
+		{"fallback without body", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", Fallback: &Fallback{Status: 200}}}},
+		{"fallback with bad status", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Fallback: &Fallback{Status: 42, Body: "{}"}}}},
+		{"relative shadow upstream", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Shadow: &Shadow{UpstreamURL: "localhost:3002", Percent: 10}}}},
+		{"shadow without percent", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Shadow: &Shadow{UpstreamURL: "http://localhost:3002"}}}},
+		{"shadow percent over 100", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Shadow: &Shadow{UpstreamURL: "http://localhost:3002", Percent: 110}}}},
+		{"negative dial timeout", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", DialTimeout: Duration(-time.Second)}}},
+		{"idle per host above total", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			MaxIdleConns: 10, MaxIdleConnsPerHost: 20}}},
+		{"negative timeout", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", Timeout: Duration(-time.Second)}}},
+		{"empty client name", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", ClientNames: []string{""}}}},
+		{"malformed allow_ips", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", AllowIPs: []string{"10.0.0.0/40"}}}},
+		{"malformed deny_ips", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001", DenyIPs: []string{"vpc"}}}},
+		{"set hop-by-hop header", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			SetRequestHeaders: map[string]string{"connection": "close"}}}},
+		{"invalid header name", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			RemoveResponseHeaders: []string{"X Bad"}}}},
+		{"header value with newline", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			SetResponseHeaders: map[string]string{"X-A": "1\r\nX-B: 2"}}}},
+		{"split percents off", []Rule{{PathPrefix: "/api", Variants: []Variant{
+			{Name: "a", Percent: 90, UpstreamURL: "http://localhost:3001"},
+			{Name: "b", Percent: 5, UpstreamURL: "http://localhost:3002"},
+		}}}},
+		{"split and upstream", []Rule{{PathPrefix: "/api", UpstreamURL: "http://localhost:3001",
+			Variants: []Variant{{Name: "a", Percent: 100, UpstreamURL: "http://localhost:3002"}}}}},
+		{"unnamed variant", []Rule{{PathPrefix: "/api", Variants: []Variant{{Percent: 100, UpstreamURL: "http://localhost:3001"}}}}},
+		{"bad sticky", []Rule{{PathPrefix: "/api", Sticky: "header:X-User",
+			Variants: []Variant{{Name: "a", Percent: 100, UpstreamURL: "http://localhost:3001"}}}}},
+		{"relative variant upstream", []Rule{{PathPrefix: "/api", Variants: []Variant{{Name: "a", Percent: 100, UpstreamURL: "localhost:3001"}}}}},
+		{"duplicate prefix", []Rule{
+			{PathPrefix: "/api", UpstreamURL: "http://localhost:3001"},
+			{PathPrefix: "/api/", UpstreamURL: "http://localhost:3002"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRouter(tt.rules); err == nil {
+				t.Fatal("NewRouter() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	data := `[{"path_prefix":"/api/v1/users","upstream_url":"http://localhost:3001"},
+		{"path_prefix":"/api/v1/services","upstream_url":"http://localhost:3002","scope":"services:read"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[1].Scope != "services:read" || rules[0].UpstreamURL != "http://localhost:3001" {
+		t.Fatalf("rules = %+v", rules)
+	}
+}
+
+func TestLoadRulesBreakerSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	data := `[{"path_prefix":"/api","upstream_url":"http://localhost:3001","breaker_threshold":3,"breaker_cooldown":"45s"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules[0].BreakerThreshold != 3 || time.Duration(rules[0].BreakerCooldown) != 45*time.Second {
+		t.Fatalf("rule = %+v", rules[0])
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"path_prefix":"/api","upstream_url":"http://x","breaker_cooldown":45}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil {
+		t.Fatal("numeric cooldown accepted, want duration string")
+	}
+}
+
+func TestRouterCachesBehindScope(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "public")
+		io.WriteString(w, "data")
+	}))
+	defer srv.Close()
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: srv.URL, Scope: "read", CacheTTL: Duration(time.Minute)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(scope string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/x", nil)
+		req.Header.Set("Authorization", "Bearer t")
+		req = req.WithContext(auth.WithClaims(req.Context(), map[string]any{"scope": scope}))
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		return rec
+	}
+	get("read")
+	if rec := get("read"); rec.Header().Get("X-Cache") != "HIT" || calls != 1 {
+		t.Fatalf("X-Cache = %q after %d upstream calls, want HIT", rec.Header().Get("X-Cache"), calls)
+	}
+	if rec := get("other"); rec.Code != http.StatusForbidden {
+		t.Fatalf("unscoped client got %d from cache, want 403", rec.Code)
+	}
+}
+
+func TestRouterCachesByForwardedClaims(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public")
+		io.WriteString(w, "user="+r.Header.Get("X-User-ID"))
+	}))
+	defer srv.Close()
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: srv.URL, CacheTTL: Duration(time.Minute)}},
+		WithUpstreamClaims(map[string]string{"sub": "X-User-ID"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+sub)
+		req = req.WithContext(auth.WithClaims(req.Context(), map[string]any{"sub": sub}))
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		return rec
+	}
+	get("alice")
+	if rec := get("bob"); rec.Body.String() != "user=bob" {
+		t.Fatalf("bob got %q (X-Cache %s)", rec.Body, rec.Header().Get("X-Cache"))
+	}
+	if rec := get("alice"); rec.Body.String() != "user=alice" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("alice again: %q (X-Cache %s), want her cached response", rec.Body, rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestRouterIPLists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/internal", UpstreamURL: srv.URL, AllowIPs: []string{"10.0.0.0/8", "fd00::/8"}, DenyIPs: []string{"10.6.6.0/24"}},
+		{PathPrefix: "/public", UpstreamURL: srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, remoteAddr string
+		want             int
+	}{
+		{"/internal/x", "10.1.2.3:5000", http.StatusOK},
+		{"/internal/x", "[fd00::1]:5000", http.StatusOK},
+		{"/internal/x", "10.6.6.6:5000", http.StatusForbidden},
+		{"/internal/x", "203.0.113.1:5000", http.StatusForbidden},
+		{"/public/x", "203.0.113.1:5000", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s from %s: status = %d, want %d", tt.path, tt.remoteAddr, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/api/v1/users", Methods: []string{"GET"}, UpstreamURL: namedUpstream(t, "read")},
+		{PathPrefix: "/api/v1/users", Methods: []string{"POST", "PUT"}, UpstreamURL: namedUpstream(t, "write")},
+		{PathPrefix: "/api/v1/things", Methods: []string{"DELETE"}, UpstreamURL: namedUpstream(t, "delete")},
+		{PathPrefix: "/api/v1/things", UpstreamURL: namedUpstream(t, "any")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+		wantAllow    string
+	}{
+		{http.MethodGet, "/api/v1/users/42", http.StatusOK, "read", ""},
+		{http.MethodHead, "/api/v1/users", http.StatusOK, "", ""},
+		{http.MethodPut, "/api/v1/users/42", http.StatusOK, "write", ""},
+		{http.MethodDelete, "/api/v1/users/42", http.StatusMethodNotAllowed, `{"error":{"code":"method_not_allowed","message":"method not allowed"}}`, "GET, HEAD, POST, PUT"},
+		{http.MethodDelete, "/api/v1/things/1", http.StatusOK, "delete", ""},
+		{http.MethodPatch, "/api/v1/things/1", http.StatusOK, "any", ""},
+		{http.MethodDelete, "/api/v1/nothing", http.StatusNotFound, `{"error":{"code":"not_found","message":"not found"}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rt.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Fatalf("body = %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Fatalf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestRouterUpdate(t *testing.T) {
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: namedUpstream(t, "old")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Body.String()
+	}
+
+	if err := rt.Update([]Rule{{PathPrefix: "/api", UpstreamURL: "not a url"}}); err == nil {
+		t.Fatal("invalid rules accepted")
+	}
+	if got := get("/api"); got != "old" {
+		t.Fatalf("after rejected update: %q, want the old table", got)
+	}
+
+	if err := rt.Update([]Rule{
+		{PathPrefix: "/api", UpstreamURL: namedUpstream(t, "new")},
+		{PathPrefix: "/v2", UpstreamURL: namedUpstream(t, "v2")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("/api"); got != "new" {
+		t.Fatalf("after update: %q, want new", got)
+	}
+	if got := get("/v2/x"); got != "v2" || len(rt.Breakers()) != 2 {
+		t.Fatalf("added route: %q, %d breakers", got, len(rt.Breakers()))
+	}
+}
+
+func TestRouterUpdateKeepsInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "old")
+	}))
+	defer slow.Close()
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: slow.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string)
+	go func() {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		done <- rec.Body.String()
+	}()
+	time.Sleep(20 * time.Millisecond) // let the request reach the upstream
+	if err := rt.Update([]Rule{{PathPrefix: "/api", UpstreamURL: namedUpstream(t, "new")}}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if got := <-done; got != "old" {
+		t.Fatalf("in-flight request got %q, want it to finish on the old upstream", got)
+	}
+}
+
+func TestRouterDumpsOnlyOptedInRoutes(t *testing.T) {
+	var dumped []string
+	dump := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dumped = append(dumped, r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/debug", UpstreamURL: namedUpstream(t, "debug"), DumpBody: true},
+		{PathPrefix: "/plain", UpstreamURL: namedUpstream(t, "plain")},
+		{PathPrefix: "/login", UpstreamURL: namedUpstream(t, "login"), Sensitive: true},
+	}, WithBodyDump(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/debug", "/plain", "/login"} {
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if len(dumped) != 1 || dumped[0] != "/debug" {
+		t.Fatalf("dumped %v, want only /debug", dumped)
+	}
+
+	_, err = NewRouter([]Rule{{PathPrefix: "/login", UpstreamURL: "http://a:1", DumpBody: true, Sensitive: true}})
+	if err == nil {
+		t.Fatal("dump_body on a sensitive route accepted")
+	}
+}
+
+func TestRouterTimeoutOverride(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(1500 * time.Millisecond):
+			io.WriteString(w, "report")
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	rt, err := NewRouter([]Rule{
+		{PathPrefix: "/api/v1/reports", UpstreamURL: slow.URL, Timeout: Duration(10 * time.Second)},
+		{PathPrefix: "/api/v1/users", UpstreamURL: slow.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := middleware.TimeoutFunc(func(r *http.Request) time.Duration {
+		if d := rt.Timeout(r); d > 0 {
+			return d
+		}
+		return time.Second
+	})
+	// The server's WriteTimeout is no longer than the global timeout, so
+	// the override only wins if the write deadline is moved too.
+	gw := httptest.NewUnstartedServer(timeout(rt))
+	gw.Config.WriteTimeout = time.Second
+	gw.Start()
+	t.Cleanup(gw.Close)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/api/v1/reports", http.StatusOK, "report"},
+		{"/api/v1/users", http.StatusGatewayTimeout, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+			resp, err := http.Get(gw.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Fatalf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRouterUpstreamProtocol(t *testing.T) {
+	// The upstream accepts both HTTP/1.1 and cleartext HTTP/2 and reports
+	// which one each request arrived over.
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	up.Config.Protocols = new(http.Protocols)
+	up.Config.Protocols.SetHTTP1(true)
+	up.Config.Protocols.SetUnencryptedHTTP2(true)
+	up.Start()
+	t.Cleanup(up.Close)
+
+	tests := []struct {
+		protocol string
+		want     string
+	}{
+		{ProtocolAuto, "HTTP/1.1"},
+		{ProtocolHTTP1, "HTTP/1.1"},
+		{ProtocolHTTP2, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want+" for "+tt.protocol, func(t *testing.T) {
+			rt, err := NewRouter([]Rule{{
+				PathPrefix:          "/api",
+				UpstreamURL:         up.URL,
+				UpstreamProtocol:    tt.protocol,
+				MaxIdleConnsPerHost: 16,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				rec := httptest.NewRecorder()
+				rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+				if rec.Body.String() != tt.want {
+					t.Fatalf("upstream saw %q, want %q", rec.Body, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterConnectionPoolMetrics(t *testing.T) {
+	release := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(up.Close)
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: up.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.TrimPrefix(up.URL, "http://")
+	gauges := func() string {
+		var out strings.Builder
+		metrics.Default.WriteText(&out)
+		var lines []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, "gateway_upstream_connections{upstream=\""+addr+"\"") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api", nil))
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(gauges(), `state="active"} 1`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("request not counted as active:\n%s", gauges())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	<-done
+
+	want := `gateway_upstream_connections{upstream="` + addr + `",state="active"} 0` + "\n" +
+		`gateway_upstream_connections{upstream="` + addr + `",state="idle"} 1`
+	if got := gauges(); got != want {
+		t.Fatalf("after the request:\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRouterResponseTransform(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.users+json")
+		io.WriteString(w, "v1")
+	}))
+	t.Cleanup(up.Close)
+	upper := WithTransformer("upper", func(_ string, body io.Reader) (io.Reader, error) {
+		b, err := io.ReadAll(body)
+		return strings.NewReader(strings.ToUpper(string(b))), err
+	})
+	rule := Rule{PathPrefix: "/api", UpstreamURL: up.URL, ResponseTransform: "upper",
+		TransformContentTypes: []string{"application/vnd.users+json"}}
+
+	if _, err := NewRouter([]Rule{rule}); err == nil || !strings.Contains(err.Error(), `unknown response_transform "upper"`) {
+		t.Fatalf("err = %v, want an unknown transformer", err)
+	}
+	rt, err := NewRouter([]Rule{rule}, upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if rec.Body.String() != "V1" {
+		t.Fatalf("body = %q, want the transformed V1", rec.Body)
+	}
+}
+
+func TestRouterFallback(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	body := filepath.Join(t.TempDir(), "fallback.json")
+	if err := os.WriteFile(body, []byte(`{"items":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: down.URL, Fallback: &Fallback{BodyFile: body}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"items":[]}` || rec.Header().Get(middleware.FallbackHeader) != "true" {
+		t.Fatalf("GET: %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/items", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("POST: status = %d, want 502", rec.Code)
+	}
+
+	if _, err := NewRouter([]Rule{{PathPrefix: "/api", UpstreamURL: down.URL,
+		Fallback: &Fallback{BodyFile: filepath.Join(t.TempDir(), "missing.json")}}}); err == nil {
+		t.Fatal("missing body_file accepted")
+	}
+}
@@ -1,21 +1,51 @@
 package handler
 
 import (
-	"encoding/json"
 	"net/http"
+
+	"api-gateway/internal/auth"
+	"api-gateway/internal/health"
+	"api-gateway/internal/metrics"
+	"api-gateway/internal/middleware"
 )
 
-func RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/api/v1/users", handleUsers)
-	mux.HandleFunc("/api/v1/services", handleServices)
+// RegisterRoutes mounts the routing table at the root of g, so it receives
+// every request under g's prefix not claimed by a more specific pattern on
+// the same mux. The table can be mounted in several groups, each with its
+// own middleware; a group with a prefix also claims the prefix itself, so
+// "/partner" isn't redirected to "/partner/".
+func RegisterRoutes(g *RouteGroup, rt *Router) {
+	g.Handle("/", rt)
+	if g.prefix != "" {
+		g.Handle("", rt)
+	}
+}
+
+// RegisterMetrics serves metrics.Default at /metrics.
+func RegisterMetrics(mux *http.ServeMux) {
+	handle(mux, "/metrics", metrics.Default.Handler())
 }
 
-func handleUsers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+// RegisterUpstreamHealth serves c's per-upstream status at
+// /healthz/upstreams.
+func RegisterUpstreamHealth(mux *http.ServeMux, c *health.Checker) {
+	handle(mux, "/healthz/upstreams", c.Handler())
+}
+
+// SessionPath is where RegisterSessions serves browser logins.
+const SessionPath = "/auth/session"
+
+// RegisterSessions serves s's login and logout endpoint at SessionPath,
+// taking login's credentials in exchange for a session cookie; see
+// auth.Sessions.Handler.
+func RegisterSessions(mux *http.ServeMux, s *auth.Sessions, login auth.Authenticator) {
+	handle(mux, SessionPath, s.Handler(login))
 }
 
-func handleServices(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+// handle registers h on mux and reports pattern as the route template.
+func handle(mux *http.ServeMux, pattern string, h http.Handler) {
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.SetRoute(r.Context(), pattern)
+		h.ServeHTTP(w, r)
+	}))
 }
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/auth"
+	"api-gateway/internal/logging"
+	"api-gateway/internal/metrics"
+)
+
+// Shadow settings for rules that leave them unset, and the cap on a
+// route's shadow requests in flight, beyond which requests go unmirrored
+// rather than pile up behind a slow shadow.
+const (
+	defaultShadowMaxBody = 1 << 20
+	defaultShadowTimeout = 10 * time.Second
+	maxShadowInFlight    = 100
+)
+
+var (
+	shadowRequests = metrics.Default.NewCounter("gateway_shadow_requests_total",
+		"Requests mirrored to a shadow upstream, by route and the status codes of the primary and shadow responses.",
+		"route", "primary", "shadow")
+	shadowSkipped = metrics.Default.NewCounter("gateway_shadow_skipped_total",
+		"Requests picked for mirroring but served without a shadow, by route and reason.",
+		"route", "reason")
+)
+
+// Shadow mirrors a share of a route's requests to a second upstream, such
+// as a new service being tried under real traffic before cutover. The
+// shadow's responses are discarded; only the primary's reach the client.
+type Shadow struct {
+	UpstreamURL string `json:"upstream_url"`
+	// Percent is the share of the route's requests mirrored, above 0 and
+	// up to 100.
+	Percent float64 `json:"percent"`
+	// MaxBodyBytes caps the request bodies buffered to be sent twice,
+	// 1MB by default. Requests with longer bodies aren't mirrored.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// Timeout bounds each shadow request, 10s by default.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+func validateShadow(s *Shadow) error {
+	if s == nil {
+		return nil
+	}
+	if u, err := url.Parse(s.UpstreamURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("shadow: invalid upstream URL %q", s.UpstreamURL)
+	}
+	switch {
+	case s.Percent <= 0 || s.Percent > 100:
+		return fmt.Errorf("shadow: percent must be above 0 and at most 100, got %g", s.Percent)
+	case s.MaxBodyBytes < 0:
+		return errors.New("shadow: max_body_bytes must not be negative")
+	case s.Timeout < 0:
+		return errors.New("shadow: timeout must not be negative")
+	}
+	return nil
+}
+
+// newShadow returns middleware mirroring rule's requests to its shadow,
+// through a proxy with the rule's transport settings and request header
+// edits but none of its retries, breakers or response handling. Handlers
+// it wraps, such as a split rule's variants, share its in-flight cap.
+func (rt *Router) newShadow(t *routeTable, rule Rule) func(http.Handler) http.Handler {
+	u, _ := url.Parse(rule.Shadow.UpstreamURL)
+	transport := newTransport(rule)
+	t.transports = append(t.transports, transport)
+	proxy := NewProxy(u,
+		WithTransport(transport),
+		WithRequestHeaders(rule.SetRequestHeaders, rule.RemoveRequestHeaders),
+		WithClaimHeaders(rt.claims),
+	)
+	slots := make(chan struct{}, maxShadowInFlight)
+	return func(next http.Handler) http.Handler {
+		return &shadower{
+			next:    next,
+			proxy:   proxy,
+			route:   rule.Name(),
+			host:    u.Host,
+			percent: rule.Shadow.Percent,
+			maxBody: cmp.Or(rule.Shadow.MaxBodyBytes, defaultShadowMaxBody),
+			timeout: cmp.Or(time.Duration(rule.Shadow.Timeout), defaultShadowTimeout),
+			slots:   slots,
+		}
+	}
+}
+
+// shadower serves each request with next and sends a copy of a share of
+// them to the shadow proxy in the background. The copy carries the
+// request's body, read up front, and claims, but not its cancellation: the
+// shadow request runs to its own timeout whether or not the client stays.
+// Nothing about it, failure included, is seen by the client. Protocol
+// upgrades aren't mirrored, nor are requests whose client waits for 100
+// Continue, as reading the body ahead of the primary would ask for it.
+type shadower struct {
+	next    http.Handler
+	proxy   http.Handler
+	route   string
+	host    string
+	percent float64
+	maxBody int64
+	timeout time.Duration
+	// slots holds a token for each shadow request in flight.
+	slots chan struct{}
+}
+
+func (s *shadower) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rand.Float64()*100 >= s.percent || r.Header.Get("Upgrade") != "" || expectsContinue(r) {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	// The slot is taken first, so a busy shadow doesn't cost a buffered
+	// body.
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		shadowSkipped.Inc(s.route, "busy")
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	body, ok := s.readBody(r)
+	if !ok {
+		<-s.slots
+		shadowSkipped.Inc(s.route, "body_too_large")
+		s.next.ServeHTTP(w, r)
+		return
+	}
+
+	// The copy is made once the body is read, so trailers are filled in.
+	ctx := context.Background()
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		ctx = auth.WithClaims(ctx, claims)
+	}
+	shadow := r.Clone(ctx)
+	shadow.Body = io.NopCloser(bytes.NewReader(body))
+	shadow.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		shadow.Body = http.NoBody
+	}
+	primary := make(chan int, 1)
+	go s.mirror(shadow, primary)
+
+	sw := &shadowedWriter{ResponseWriter: w, status: http.StatusOK}
+	defer func() { primary <- sw.status }()
+	s.next.ServeHTTP(sw, r)
+}
+
+// readBody reads r's body for the shadow and puts it back for the
+// primary. It reports false for a body over maxBody, or one that fails to
+// read, leaving the primary to read the rest, or the error, as it would
+// have.
+func (s *shadower) readBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+	if err != nil || int64(len(body)) > s.maxBody {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = readCloser{bytes.NewReader(body), r.Body}
+	return body, true
+}
+
+// mirror sends req to the shadow, discards the response, and counts it
+// against the status the primary answered with.
+func (s *shadower) mirror(req *http.Request, primary <-chan int) {
+	defer func() { <-s.slots }()
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Errorf("shadow: %s %s -> %s: panic: %v", req.Method, req.URL.Path, s.host, err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(req.Context(), s.timeout)
+	defer cancel()
+	w := &discardWriter{header: http.Header{}, status: http.StatusOK}
+	s.proxy.ServeHTTP(w, req.WithContext(ctx))
+	status := strconv.Itoa(w.status)
+	logging.Debugf("shadow: %s %s -> %s: %s", req.Method, req.URL.Path, s.host, status)
+	shadowRequests.Inc(s.route, strconv.Itoa(<-primary), status)
+}
+
+// shadowedWriter records the status of the primary response.
+type shadowedWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *shadowedWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *shadowedWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *shadowedWriter) Flush() {
+	w.wroteHeader = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *shadowedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// discardWriter takes the shadow's response, keeping only its status.
+type discardWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return len(b), nil
+}
+
+func (w *discardWriter) Flush() {}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/metrics"
+)
+
+// shadowRequest is what the shadow upstream saw of a mirrored request.
+type shadowRequest struct {
+	method, path, body, header string
+}
+
+func TestShadow(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "primary got "+string(body))
+	}))
+	t.Cleanup(primary.Close)
+	mirrored := make(chan shadowRequest, 4)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- shadowRequest{r.Method, r.URL.Path, string(body), r.Header.Get("X-Env")}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "shadow")
+	}))
+	t.Cleanup(shadow.Close)
+	t.Cleanup(func() { close(release) })
+
+	rt, err := NewRouter([]Rule{{
+		PathPrefix:        "/shadowed",
+		UpstreamURL:       primary.URL,
+		SetRequestHeaders: map[string]string{"X-Env": "prod"},
+		Shadow:            &Shadow{UpstreamURL: shadow.URL, Percent: 100, MaxBodyBytes: 16},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shadowed/items", strings.NewReader(body)))
+		return rec
+	}
+
+	// The client gets the primary's response while the shadow still holds
+	// its request.
+	rec := serve("item")
+	if rec.Code != http.StatusCreated || rec.Body.String() != "primary got item" {
+		t.Fatalf("response = %d %q, want the primary's", rec.Code, rec.Body)
+	}
+	select {
+	case got := <-mirrored:
+		if want := (shadowRequest{http.MethodPost, "/shadowed/items", "item", "prod"}); got != want {
+			t.Fatalf("shadow got %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request wasn't mirrored")
+	}
+
+	// A body over max_body_bytes goes to the primary whole and isn't
+	// mirrored.
+	long := strings.Repeat("x", 20)
+	if rec := serve(long); rec.Body.String() != "primary got "+long {
+		t.Fatalf("long body: response = %q", rec.Body)
+	}
+	select {
+	case got := <-mirrored:
+		t.Fatalf("request over max_body_bytes mirrored: %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	want := `gateway_shadow_requests_total{route="/shadowed",primary="201",shadow="500"} 1`
+	deadline := time.Now().Add(time.Second)
+	for {
+		var out strings.Builder
+		metrics.Default.WriteText(&out)
+		text := out.String()
+		if strings.Contains(text, want) &&
+			strings.Contains(text, `gateway_shadow_skipped_total{route="/shadowed",reason="body_too_large"} 1`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics don't report the comparison:\n%s", text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShadowFailureIsInvisible(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(primary.Close)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	rt, err := NewRouter([]Rule{{
+		PathPrefix:  "/api",
+		UpstreamURL: primary.URL,
+		Shadow:      &Shadow{UpstreamURL: down.URL, Percent: 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Fatalf("response = %d %q with the shadow down", rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestShadowSlots(t *testing.T) {
+	var primaryBody io.ReadCloser
+	s := &shadower{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			primaryBody = r.Body
+		}),
+		proxy:   http.NotFoundHandler(),
+		route:   "/slots",
+		percent: 100,
+		maxBody: 4,
+		slots:   make(chan struct{}, 1),
+	}
+	serve := func(body string) io.ReadCloser {
+		req := httptest.NewRequest(http.MethodPost, "/slots", strings.NewReader(body))
+		sent := req.Body
+		s.ServeHTTP(httptest.NewRecorder(), req)
+		return sent
+	}
+
+	// A body over max_body_bytes gives its slot back.
+	serve("too long")
+	if n := len(s.slots); n != 0 {
+		t.Fatalf("%d slots held after a body over max_body_bytes", n)
+	}
+
+	// With every slot taken, the body goes to the primary unread.
+	s.slots <- struct{}{}
+	if sent := serve("item"); primaryBody != sent {
+		t.Fatal("body was buffered for a shadow without a free slot")
+	}
+}
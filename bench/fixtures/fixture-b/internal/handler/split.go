@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/auth"
+)
+
+// Variant is one arm of a route's traffic split, such as the current
+// release or a canary. Its upstreams are load-balanced among themselves
+// like a rule's.
+type Variant struct {
+	Name string `json:"name"`
+	// Percent is the variant's share of the route's traffic. The variants
+	// of a rule must add up to 100.
+	Percent     float64    `json:"percent"`
+	UpstreamURL string     `json:"upstream_url,omitempty"`
+	Upstreams   []Upstream `json:"upstreams,omitempty"`
+}
+
+// Targets returns the variant's upstreams, as Rule.Targets does.
+func (v Variant) Targets() []Upstream {
+	if v.UpstreamURL != "" {
+		return []Upstream{{URL: v.UpstreamURL, Weight: 1}}
+	}
+	return v.Upstreams
+}
+
+// validateSplit checks a rule's variants and sticky setting.
+func validateSplit(rule Rule) error {
+	if len(rule.Variants) == 0 {
+		if rule.Sticky != "" {
+			return errors.New("sticky requires variants")
+		}
+		return nil
+	}
+	if rule.UpstreamURL != "" || len(rule.Upstreams) > 0 {
+		return errors.New("set variants or upstream_url/upstreams, not both")
+	}
+	if _, err := stickyKey(rule.Sticky); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	var total float64
+	for _, v := range rule.Variants {
+		switch {
+		case v.Name == "":
+			return errors.New("variants need a name")
+		case names[v.Name]:
+			return fmt.Errorf("duplicate variant %q", v.Name)
+		case v.Percent < 0:
+			return fmt.Errorf("variant %q: negative percent", v.Name)
+		case v.UpstreamURL != "" && len(v.Upstreams) > 0:
+			return fmt.Errorf("variant %q: set upstream_url or upstreams, not both", v.Name)
+		case len(v.Targets()) == 0:
+			return fmt.Errorf("variant %q: no upstream configured", v.Name)
+		}
+		names[v.Name] = true
+		total += v.Percent
+	}
+	if math.Abs(total-100) > 1e-9 {
+		return fmt.Errorf("variant percents add up to %g, want 100", total)
+	}
+	return nil
+}
+
+// stickyKey parses a rule's sticky setting: "sub" pins by the token's sub
+// claim and "cookie:NAME" by the named cookie's value. It returns nil for
+// "", meaning every request is split at random.
+func stickyKey(sticky string) (func(*http.Request) string, error) {
+	switch name, ok := strings.CutPrefix(sticky, "cookie:"); {
+	case sticky == "":
+		return nil, nil
+	case sticky == "sub":
+		return func(r *http.Request) string {
+			claims, _ := auth.ClaimsFromContext(r.Context())
+			sub, _ := claims["sub"].(string)
+			return sub
+		}, nil
+	case ok && name != "":
+		return func(r *http.Request) string {
+			c, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}, nil
+	}
+	return nil, fmt.Errorf(`sticky: want "sub" or "cookie:NAME", got %q`, sticky)
+}
+
+// splitter sends each request to one of a route's variants. A request's
+// position is a point in [0, 100) and the variants cover consecutive
+// ranges of it sized by their percents. With a sticky key the point is a
+// hash of the key, so a client stays on its variant across requests, and
+// raising the last variant's share, as when ramping a canary up, only
+// moves clients onto it. Requests without a key get a random point.
+//
+// When every upstream of the chosen variant is out of rotation the
+// request goes to the next variant that has one available, so a failing
+// canary doesn't fail its share of traffic.
+type splitter struct {
+	variants []splitVariant
+	key      func(*http.Request) string
+}
+
+type splitVariant struct {
+	percent float64
+	end     float64 // exclusive upper bound of the variant's range
+	lb      *balancer
+	// handler serves the variant's requests: lb behind the rule's own
+	// layers, such as its cache, which must not mix variants' responses.
+	handler http.Handler
+}
+
+func (s *splitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.pick(r).handler.ServeHTTP(w, r)
+}
+
+func (s *splitter) pick(r *http.Request) splitVariant {
+	point := rand.Float64() * 100
+	if s.key != nil {
+		if k := s.key(r); k != "" {
+			h := fnv.New64a()
+			h.Write([]byte(k))
+			point = float64(h.Sum64()%10000) / 100
+		}
+	}
+	chosen := len(s.variants) - 1
+	for i, v := range s.variants {
+		if point < v.end {
+			chosen = i
+			break
+		}
+	}
+	for i := range s.variants {
+		v := s.variants[(chosen+i)%len(s.variants)]
+		// A variant at 0% only takes traffic chosen for it, which is none.
+		if v.lb.anyAvailable() && (i == 0 || v.percent > 0) {
+			return v
+		}
+	}
+	return s.variants[chosen]
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/auth"
+)
+
+func splitRule(stable, canary string, canaryPercent float64, sticky string) Rule {
+	return Rule{
+		PathPrefix: "/api/v1/services",
+		Sticky:     sticky,
+		Variants: []Variant{
+			{Name: "stable", Percent: 100 - canaryPercent, UpstreamURL: stable},
+			{Name: "canary", Percent: canaryPercent, UpstreamURL: canary},
+		},
+	}
+}
+
+func TestRouterSplitsTraffic(t *testing.T) {
+	stable, canary := namedUpstream(t, "stable"), namedUpstream(t, "canary")
+	rt, err := NewRouter([]Rule{splitRule(stable, canary, 20, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for range 1000 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+		counts[rec.Body.String()]++
+	}
+	if c := counts["canary"]; c < 140 || c > 260 {
+		t.Fatalf("canary got %d of 1000 requests at 20%%: %v", c, counts)
+	}
+
+	if err := rt.Update([]Rule{splitRule(stable, canary, 0, "")}); err != nil {
+		t.Fatal(err)
+	}
+	for range 100 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+		if rec.Body.String() != "stable" {
+			t.Fatal("canary got traffic at 0%")
+		}
+	}
+}
+
+func TestRouterStickySplit(t *testing.T) {
+	stable, canary := namedUpstream(t, "stable"), namedUpstream(t, "canary")
+	tests := []struct {
+		name   string
+		sticky string
+		as     func(r *http.Request, user string) *http.Request
+	}{
+		{"cookie", "cookie:session", func(r *http.Request, user string) *http.Request {
+			r.AddCookie(&http.Cookie{Name: "session", Value: user})
+			return r
+		}},
+		{"sub", "sub", func(r *http.Request, user string) *http.Request {
+			return r.WithContext(auth.WithClaims(r.Context(), map[string]any{"sub": user}))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := NewRouter([]Rule{splitRule(stable, canary, 10, tt.sticky)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			variantOf := func(user string) string {
+				var got string
+				for i := range 5 {
+					rec := httptest.NewRecorder()
+					rt.ServeHTTP(rec, tt.as(httptest.NewRequest(http.MethodGet, "/api/v1/services", nil), user))
+					if i > 0 && rec.Body.String() != got {
+						t.Fatalf("user %s moved from %s to %s", user, got, rec.Body)
+					}
+					got = rec.Body.String()
+				}
+				return got
+			}
+
+			onCanary := map[string]bool{}
+			for i := range 500 {
+				user := fmt.Sprintf("user-%d", i)
+				onCanary[user] = variantOf(user) == "canary"
+			}
+			if n := countTrue(onCanary); n < 25 || n > 75 {
+				t.Fatalf("%d of 500 users on the canary at 10%%", n)
+			}
+
+			// Ramping the canary up keeps everyone already on it there.
+			if err := rt.Update([]Rule{splitRule(stable, canary, 50, tt.sticky)}); err != nil {
+				t.Fatal(err)
+			}
+			for user, was := range onCanary {
+				if was && variantOf(user) != "canary" {
+					t.Fatalf("user %s left the canary when it grew", user)
+				}
+			}
+		})
+	}
+}
+
+func countTrue(m map[string]bool) int {
+	n := 0
+	for _, v := range m {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRouterSplitAvoidsFailedVariant(t *testing.T) {
+	stable, canary := namedUpstream(t, "stable"), namedUpstream(t, "canary")
+	rule := splitRule(stable, canary, 50, "")
+	rule.BreakerThreshold = 1
+	rt, err := NewRouter([]Rule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range rt.Breakers() {
+		if b.Name() == "/api/v1/services canary" {
+			b.Record(false)
+		}
+	}
+	for range 20 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+		if rec.Body.String() != "stable" {
+			t.Fatalf("got %q with the canary's breaker open, want stable", rec.Body)
+		}
+	}
+}
+
+func TestRouterCachesEachVariant(t *testing.T) {
+	stable, canary := namedUpstream(t, "stable"), namedUpstream(t, "canary")
+	rule := splitRule(stable, canary, 20, "")
+	rule.CacheTTL = Duration(time.Minute)
+	rt, err := NewRouter([]Rule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once both variants' responses are cached, the split still holds.
+	counts, hits := map[string]int{}, map[string]int{}
+	for range 1000 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+		counts[rec.Body.String()]++
+		if rec.Header().Get("X-Cache") == "HIT" {
+			hits[rec.Body.String()]++
+		}
+	}
+	if c := counts["canary"]; c < 140 || c > 260 {
+		t.Fatalf("canary got %d of 1000 requests at 20%%: %v", c, counts)
+	}
+	if hits["stable"] == 0 || hits["canary"] == 0 {
+		t.Fatalf("cache hits by variant = %v, want both cached", hits)
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/logging"
+)
+
+// maxTransformBytes is the largest response body a Transformer is given.
+// Larger bodies pass through untransformed rather than being held in
+// memory.
+const maxTransformBytes = 10 << 20
+
+// Transformer rewrites a response body, such as renaming the fields of a
+// JSON document while clients and upstream migrate between schemas.
+// contentType is the response's Content-Type header.
+type Transformer func(contentType string, body io.Reader) (io.Reader, error)
+
+// responseTransform applies a Transformer to responses of its content
+// types.
+type responseTransform struct {
+	name         string
+	transform    Transformer
+	contentTypes []string
+}
+
+// WithResponseTransformer rewrites the bodies of upstream responses whose
+// media type is one of contentTypes, "application/json" if none are given,
+// with t. Bodies are buffered, so that a transformer that fails, at once
+// or while its output is read, leaves the upstream's body to pass through
+// unchanged, with the error logged under name. The rewritten body is sent
+// with its new Content-Length and any ETag weakened. Bodies with a
+// Content-Encoding, larger than 10MB or of 101, 204 and 304 responses are
+// never transformed.
+func WithResponseTransformer(name string, t Transformer, contentTypes ...string) ProxyOption {
+	if len(contentTypes) == 0 {
+		contentTypes = []string{"application/json"}
+	}
+	return func(c *proxyConfig) {
+		c.transform = &responseTransform{name: name, transform: t, contentTypes: contentTypes}
+	}
+}
+
+func (rt *responseTransform) apply(resp *http.Response) {
+	switch resp.StatusCode {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusNotModified:
+		return
+	}
+	if resp.Request.Method == http.MethodHead || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(rt.contentTypes, mediaType) {
+		return
+	}
+
+	original, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformBytes+1))
+	if err != nil {
+		// The upstream failed mid-body; hand on what arrived and the error.
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(original), errReader{err}), resp.Body}
+		return
+	}
+	if len(original) > maxTransformBytes {
+		logging.Infof("transform %s: %s %s: body over %d bytes, passing it through",
+			rt.name, resp.Request.Method, resp.Request.URL.Path, maxTransformBytes)
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(original), resp.Body), resp.Body}
+		return
+	}
+	resp.Body.Close()
+
+	body := original
+	if out, err := rt.run(contentType, original); err != nil {
+		logging.Warnf("transform %s: %s %s: %v; passing the body through",
+			rt.name, resp.Request.Method, resp.Request.URL.Path, err)
+	} else {
+		body = out
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			resp.Header.Set("ETag", "W/"+etag)
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// run calls the transformer and reads all of its output, so errors it
+// reports while being read also count as failures.
+func (rt *responseTransform) run(contentType string, body []byte) ([]byte, error) {
+	r, err := rt.transform(contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Upstream protocols a rule can select with UpstreamProtocol.
+const (
+	// ProtocolAuto speaks HTTP/1.1, or HTTP/2 to TLS upstreams that offer
+	// it, as http.DefaultTransport does.
+	ProtocolAuto = ""
+	// ProtocolHTTP1 speaks only HTTP/1.1.
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTP2 speaks only HTTP/2: over TLS to https upstreams and
+	// as cleartext h2c, with prior knowledge, to http ones.
+	ProtocolHTTP2 = "h2"
+)
+
+// Connection settings for rules that leave them unset. Go's own default
+// of 2 idle connections per host makes a busy route close and redial most
+// of its connections, each leaving a port in TIME_WAIT.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+	defaultTLSHandshakeTimeout = 5 * time.Second
+)
+
+// validateTransport checks a rule's transport settings.
+func validateTransport(rule Rule) error {
+	switch rule.UpstreamProtocol {
+	case ProtocolAuto, ProtocolHTTP1, ProtocolHTTP2:
+	default:
+		return fmt.Errorf(`upstream_protocol: want "http1" or "h2", got %q`, rule.UpstreamProtocol)
+	}
+	for _, s := range []struct {
+		name  string
+		value int64
+	}{
+		{"max_idle_conns", int64(rule.MaxIdleConns)},
+		{"max_idle_conns_per_host", int64(rule.MaxIdleConnsPerHost)},
+		{"idle_conn_timeout", int64(rule.IdleConnTimeout)},
+		{"dial_timeout", int64(rule.DialTimeout)},
+		{"tls_handshake_timeout", int64(rule.TLSHandshakeTimeout)},
+	} {
+		if s.value < 0 {
+			return fmt.Errorf("%s must not be negative", s.name)
+		}
+	}
+	if rule.MaxIdleConns > 0 && rule.MaxIdleConnsPerHost > rule.MaxIdleConns {
+		return errors.New("max_idle_conns_per_host must not exceed max_idle_conns")
+	}
+	return nil
+}
+
+// newTransport returns a transport for a rule's upstreams, with the rule's
+// settings, or the defaults above, applied over http.DefaultTransport's.
+// Its connections are counted in gateway_upstream_connections.
+func newTransport(rule Rule) poolTransport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	switch rule.UpstreamProtocol {
+	case ProtocolHTTP1:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	t.MaxIdleConnsPerHost = cmp.Or(rule.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	t.MaxIdleConns = cmp.Or(rule.MaxIdleConns, max(defaultMaxIdleConns, t.MaxIdleConnsPerHost))
+	t.IdleConnTimeout = cmp.Or(time.Duration(rule.IdleConnTimeout), defaultIdleConnTimeout)
+	t.TLSHandshakeTimeout = cmp.Or(time.Duration(rule.TLSHandshakeTimeout), defaultTLSHandshakeTimeout)
+	dialer := &net.Dialer{
+		Timeout:   cmp.Or(time.Duration(rule.DialTimeout), defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	t.DialContext = countingDialer(dialer.DialContext)
+	return poolTransport{t}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/middleware"
+)
+
+// The helpers below implement just enough of RFC 6455 for the test:
+// unfragmented text frames with payloads under 126 bytes.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func wsWrite(w io.Writer, msg string, mask bool) error {
+	frame := []byte{0x81, byte(len(msg))}
+	payload := []byte(msg)
+	if mask {
+		key := []byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, key...)
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+func wsRead(r io.Reader) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", err
+	}
+	masked := hdr[1]&0x80 != 0
+	payload := make([]byte, hdr[1]&0x7f)
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return "", err
+		}
+	}
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return string(payload), nil
+}
+
+// echoWebSocket greets each client, then echoes its messages prefixed with
+// "echo: ".
+func echoWebSocket(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "websocket only", http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			wsAccept(r.Header.Get("Sec-WebSocket-Key")))
+		wsWrite(brw, "hello", false)
+		brw.Flush()
+		for {
+			msg, err := wsRead(brw)
+			if err != nil {
+				return
+			}
+			wsWrite(brw, "echo: "+msg, false)
+			brw.Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	backend := echoWebSocket(t)
+
+	// Route through the same wrappers the gateway puts around the proxy,
+	// with a request timeout shorter than the conversation.
+	h := middleware.Chain(
+		middleware.Recover,
+		middleware.RequestID,
+		middleware.NewLogger(middleware.TextFormat, io.Discard),
+		middleware.Metrics,
+		middleware.Timeout(50*time.Millisecond),
+		middleware.Gzip,
+	)(NewProxy(mustParse(t, backend.URL)))
+	gateway := httptest.NewServer(h)
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano())))
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: gateway\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\nAccept-Encoding: gzip\r\n\r\n", key)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != wsAccept(key) {
+		t.Fatalf("Sec-WebSocket-Accept = %q, want %q", got, wsAccept(key))
+	}
+
+	if msg, err := wsRead(br); err != nil || msg != "hello" {
+		t.Fatalf("server frame = %q, %v", msg, err)
+	}
+	time.Sleep(100 * time.Millisecond) // outlive the request timeout
+	for _, msg := range []string{"one", "two"} {
+		if err := wsWrite(conn, msg, true); err != nil {
+			t.Fatal(err)
+		}
+		got, err := wsRead(br)
+		if err != nil || got != "echo: "+msg {
+			t.Fatalf("reply to %q = %q, %v", msg, got, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Package health actively probes upstreams and tracks which are up.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/logging"
+	"api-gateway/internal/metrics"
+)
+
+var upGauge = metrics.Default.NewGauge("gateway_upstream_up",
+	"Whether the last active health check of an upstream passed.", "upstream")
+
+// Target is an upstream to probe.
+type Target struct {
+	// URL is the upstream's base URL, as configured on the route.
+	URL string
+	// Path is requested with GET on each probe. Defaults to /healthz.
+	Path string
+	// Interval between probes. Defaults to 10s.
+	Interval time.Duration
+	// Timeout bounds each probe. Defaults to 2s.
+	Timeout time.Duration
+}
+
+// Status is the last known health of a target.
+type Status struct {
+	Up        bool      `json:"up"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker probes each target in the background. An upstream counts as up
+// when its probe returns a 2xx or 3xx status. Targets that haven't been
+// probed yet count as up, so traffic flows while the first round runs.
+type Checker struct {
+	client *http.Client
+
+	mu      sync.RWMutex
+	targets []Target
+	status  map[string]Status
+
+	// Set while Run is active, so SetTargets can start and stop probes.
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	cancels map[string]context.CancelFunc
+}
+
+// NewChecker returns a Checker for targets. Targets sharing a URL are probed
+// once, with the first one's settings.
+func NewChecker(targets []Target) *Checker {
+	return &Checker{
+		// Probes must see the upstream's own answer, not a redirect target.
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+		targets: normalize(targets),
+		status:  map[string]Status{},
+	}
+}
+
+func normalize(targets []Target) []Target {
+	var out []Target
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if seen[t.URL] {
+			continue
+		}
+		seen[t.URL] = true
+		if t.Path == "" {
+			t.Path = "/healthz"
+		}
+		if t.Interval <= 0 {
+			t.Interval = 10 * time.Second
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = 2 * time.Second
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Run probes every target until ctx is done, then waits for in-flight
+// probes to finish before returning.
+func (c *Checker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	c.mu.Lock()
+	c.ctx, c.wg, c.cancels = ctx, &wg, map[string]context.CancelFunc{}
+	for _, t := range c.targets {
+		c.start(t)
+	}
+	c.mu.Unlock()
+
+	<-ctx.Done()
+	c.mu.Lock()
+	c.ctx = nil
+	c.mu.Unlock()
+	wg.Wait()
+}
+
+// start launches the probe loop for t. Callers hold mu with Run active.
+func (c *Checker) start(t Target) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.cancels[t.URL] = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.watch(ctx, t)
+	}()
+}
+
+// SetTargets replaces the set of probed upstreams, as after a config
+// reload. Unchanged targets keep probing and keep their status; removed
+// ones stop and are forgotten; new or changed ones start probing at once
+// if Run is active.
+func (c *Checker) SetTargets(targets []Target) {
+	next := normalize(targets)
+	byURL := make(map[string]Target, len(next))
+	for _, t := range next {
+		byURL[t.URL] = t
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, old := range c.targets {
+		t, kept := byURL[old.URL]
+		if kept && t == old {
+			continue
+		}
+		if cancel := c.cancels[old.URL]; cancel != nil {
+			cancel()
+			delete(c.cancels, old.URL)
+		}
+		if !kept {
+			delete(c.status, old.URL)
+		}
+	}
+	c.targets = next
+	if c.ctx == nil {
+		return
+	}
+	for _, t := range next {
+		if c.cancels[t.URL] == nil {
+			c.start(t)
+		}
+	}
+}
+
+func (c *Checker) watch(ctx context.Context, t Target) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	for {
+		c.probe(ctx, t)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) probe(ctx context.Context, t Target) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	err := c.get(ctx, strings.TrimSuffix(t.URL, "/")+t.Path)
+	st := Status{Up: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	// Cancellation (shutdown, or SetTargets dropping the target) happens
+	// under mu, so checking here keeps a stopped probe from writing back.
+	if errors.Is(ctx.Err(), context.Canceled) {
+		c.mu.Unlock()
+		return
+	}
+	prev, seen := c.status[t.URL]
+	c.status[t.URL] = st
+	c.mu.Unlock()
+
+	upGauge.Set(boolFloat(st.Up), t.URL)
+	if seen && prev.Up != st.Up || !seen && !st.Up {
+		if st.Up {
+			logging.Infof("health: %s is up", t.URL)
+		} else {
+			logging.Warnf("health: %s is down: %v", t.URL, err)
+		}
+	}
+}
+
+func (c *Checker) get(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Healthy reports whether rawURL passed its last probe. URLs that aren't
+// checked, or haven't been probed yet, are reported healthy.
+func (c *Checker) Healthy(rawURL string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st, ok := c.status[rawURL]
+	return !ok || st.Up
+}
+
+// AnyHealthy returns a readiness check that passes while at least one of
+// rawURLs is healthy.
+func (c *Checker) AnyHealthy(rawURLs ...string) func(context.Context) error {
+	return func(context.Context) error {
+		for _, u := range rawURLs {
+			if c.Healthy(u) {
+				return nil
+			}
+		}
+		return errors.New("no healthy upstream")
+	}
+}
+
+// Statuses returns the last known status of every probed upstream, keyed by
+// URL.
+func (c *Checker) Statuses() map[string]Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.status)
+}
+
+// Handler serves the current up/down state of each upstream as JSON, for
+// debugging.
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type entry struct {
+			URL string `json:"url"`
+			Status
+		}
+		c.mu.RLock()
+		targets := c.targets
+		c.mu.RUnlock()
+		statuses := c.Statuses()
+		entries := make([]entry, 0, len(targets))
+		for _, t := range targets {
+			st, ok := statuses[t.URL]
+			if !ok {
+				st = Status{Up: true, Error: "not yet checked"}
+			}
+			entries = append(entries, entry{URL: t.URL, Status: st})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"upstreams": entries})
+	})
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckerTracksUpstreams(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewChecker([]Target{{URL: srv.URL, Path: "/ping", Interval: 5 * time.Millisecond}})
+	if !c.Healthy(srv.URL) {
+		t.Fatal("unprobed upstream reported unhealthy")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { _, ok := c.Statuses()[srv.URL]; return ok })
+	if !c.Healthy(srv.URL) {
+		t.Fatal("healthy upstream reported down")
+	}
+	healthy.Store(false)
+	waitFor(t, func() bool { return !c.Healthy(srv.URL) })
+	if err := c.AnyHealthy(srv.URL)(ctx); err == nil {
+		t.Fatal("AnyHealthy passed with the only upstream down")
+	}
+	healthy.Store(true)
+	waitFor(t, func() bool { return c.Healthy(srv.URL) })
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestCheckerHandler(t *testing.T) {
+	c := NewChecker([]Target{{URL: "http://b.internal"}, {URL: "http://a.internal"}, {URL: "http://a.internal"}})
+	c.status["http://b.internal"] = Status{Up: false, Error: "status 503"}
+
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body struct {
+		Upstreams []struct {
+			URL   string `json:"url"`
+			Up    bool   `json:"up"`
+			Error string `json:"error"`
+		} `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Upstreams) != 2 {
+		t.Fatalf("upstreams = %+v, want deduplicated pair", body.Upstreams)
+	}
+	if a, b := body.Upstreams[0], body.Upstreams[1]; a.URL != "http://a.internal" || !a.Up || b.Up || b.Error != "status 503" {
+		t.Fatalf("upstreams = %+v", body.Upstreams)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckerSetTargets(t *testing.T) {
+	var aCalls, bCalls atomic.Int32
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { aCalls.Add(1) }))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer b.Close()
+
+	c := NewChecker([]Target{{URL: a.URL, Interval: 5 * time.Millisecond}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, func() bool { return aCalls.Load() > 0 })
+
+	c.SetTargets([]Target{{URL: b.URL, Interval: 5 * time.Millisecond}})
+	waitFor(t, func() bool { return !c.Healthy(b.URL) })
+	if _, ok := c.Statuses()[a.URL]; ok {
+		t.Fatal("removed target still reported")
+	}
+	stopped := aCalls.Load()
+	time.Sleep(30 * time.Millisecond)
+	if aCalls.Load() > stopped+1 {
+		t.Fatal("removed target still being probed")
+	}
+
+	cancel()
+	<-done
+	c.SetTargets([]Target{{URL: a.URL}}) // after Run: recorded, not started
+	if n := bCalls.Load(); n == 0 {
+		t.Fatal("new target never probed")
+	}
+}
@@ -0,0 +1,80 @@
+// Package lifecycle runs cleanup for the gateway's background components,
+// such as the health checker and trace exporter, once the server has
+// drained.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"api-gateway/internal/logging"
+)
+
+// Hook stops one component. It should return once the component is done
+// or ctx ends, whichever comes first.
+type Hook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	fn   Hook
+}
+
+// Shutdown collects hooks to run on graceful shutdown. The zero value is
+// ready to use, and it is safe for concurrent use.
+type Shutdown struct {
+	mu    sync.Mutex
+	hooks []namedHook
+}
+
+// RegisterShutdownHook adds fn, under name for the log, to the hooks Run
+// calls. Register a component's hook as it starts: hooks run in reverse, so
+// a component stops before anything it was started on top of.
+func (s *Shutdown) RegisterShutdownHook(name string, fn Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, namedHook{name, fn})
+}
+
+// Run calls the registered hooks, last registered first, one at a time.
+// A hook's error or panic is logged and the rest still run. Once ctx ends,
+// Run stops waiting for a hook still running, logs the hooks it didn't get
+// to, and returns ctx's error; otherwise it returns nil. Each hook runs at
+// most once, however often Run is called.
+func (s *Shutdown) Run(ctx context.Context) error {
+	s.mu.Lock()
+	hooks := s.hooks
+	s.hooks = nil
+	s.mu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if ctx.Err() != nil {
+			for _, skipped := range hooks[:i+1] {
+				logging.Warnf("shutdown hook %s: skipped: %v", skipped.name, ctx.Err())
+			}
+			return ctx.Err()
+		}
+		done := make(chan error, 1)
+		go func() { done <- call(ctx, h.fn) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				logging.Errorf("shutdown hook %s: %v", h.name, err)
+			}
+		case <-ctx.Done():
+			logging.Errorf("shutdown hook %s: gave up waiting: %v", h.name, ctx.Err())
+		}
+	}
+	return ctx.Err()
+}
+
+// call runs fn, turning a panic into an error.
+func call(ctx context.Context, fn Hook) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn(ctx)
+}
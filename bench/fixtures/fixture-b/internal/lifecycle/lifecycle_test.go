@@ -0,0 +1,62 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownRunsHooksInReverse(t *testing.T) {
+	var s Shutdown
+	var order []string
+	hook := func(name string, err error) Hook {
+		return func(context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	s.RegisterShutdownHook("redis", hook("redis", nil))
+	s.RegisterShutdownHook("tracing", hook("tracing", errors.New("flush failed")))
+	s.RegisterShutdownHook("panics", func(context.Context) error {
+		order = append(order, "panics")
+		panic("boom")
+	})
+	s.RegisterShutdownHook("health", hook("health", nil))
+
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"health", "panics", "tracing", "redis"}; !slices.Equal(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if err := s.Run(context.Background()); err != nil || len(order) != 4 {
+		t.Fatalf("second Run: err = %v, ran %v", err, order)
+	}
+}
+
+func TestShutdownRespectsDeadline(t *testing.T) {
+	var s Shutdown
+	ran := false
+	s.RegisterShutdownHook("never reached", func(context.Context) error {
+		ran = true
+		return nil
+	})
+	s.RegisterShutdownHook("stuck", func(context.Context) error {
+		select {} // ignores its context
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Run took %v past its deadline", elapsed)
+	}
+	if ran {
+		t.Fatal("hook ran after the deadline")
+	}
+}
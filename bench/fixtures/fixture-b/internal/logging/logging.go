@@ -0,0 +1,91 @@
+// Package logging gates the gateway's logs by a level that can be changed
+// while it runs, through the admin API or a signal, without a restart.
+// Lines that pass are written with the standard log package, as before.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log line's severity. Lines below the current level are
+// dropped.
+type Level int32
+
+const (
+	LevelDebug Level = iota - 1
+	// LevelInfo is the zero Level and the default.
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l-LevelDebug]
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error", in any case.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return LevelDebug + Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: want debug, info, warn or error", s)
+}
+
+// current is read on every log call, from any goroutine.
+var current atomic.Int32
+
+// SetLevel sets the level lines must reach to be written, returning the
+// previous one.
+func SetLevel(l Level) Level {
+	return Level(current.Swap(int32(l)))
+}
+
+// CurrentLevel returns the level set by SetLevel, LevelInfo by default.
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+// Step moves the level by delta, negative being more verbose, stopping at
+// LevelDebug and LevelError, and returns the new level.
+func Step(delta int) Level {
+	for {
+		old := current.Load()
+		next := min(max(old+int32(delta), int32(LevelDebug)), int32(LevelError))
+		if current.CompareAndSwap(old, next) {
+			return Level(next)
+		}
+	}
+}
+
+// Enabled reports whether lines at l are written.
+func Enabled(l Level) bool {
+	return l >= CurrentLevel()
+}
+
+// Debugf logs detail only wanted while investigating a problem.
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args...) }
+
+// Infof logs the gateway's normal operation.
+func Infof(format string, args ...any) { logf(LevelInfo, format, args...) }
+
+// Warnf logs something unexpected that the gateway works around.
+func Warnf(format string, args ...any) { logf(LevelWarn, format, args...) }
+
+// Errorf logs a failure that costs a request or a component.
+func Errorf(format string, args ...any) { logf(LevelError, format, args...) }
+
+func logf(l Level, format string, args ...any) {
+	if Enabled(l) {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		for _, s := range []string{l.String(), strings.ToUpper(l.String())} {
+			if got, err := ParseLevel(s); err != nil || got != l {
+				t.Errorf("ParseLevel(%q) = %v, %v; want %v", s, got, err, l)
+			}
+		}
+	}
+	for _, s := range []string{"", "trace", "warning"} {
+		if _, err := ParseLevel(s); err == nil {
+			t.Errorf("ParseLevel(%q) succeeded", s)
+		}
+	}
+}
+
+func TestLevelGatesOutput(t *testing.T) {
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(prev)
+		SetLevel(LevelInfo)
+	})
+
+	if CurrentLevel() != LevelInfo {
+		t.Fatalf("default level = %s, want info", CurrentLevel())
+	}
+	logAll := func() string {
+		buf.Reset()
+		Debugf("d")
+		Infof("i")
+		Warnf("w")
+		Errorf("e")
+		var got string
+		for line := range strings.Lines(buf.String()) {
+			got += strings.TrimSpace(line[strings.LastIndexByte(line, ' ')+1:])
+		}
+		return got
+	}
+	tests := []struct {
+		level Level
+		want  string
+	}{
+		{LevelDebug, "diwe"},
+		{LevelInfo, "iwe"},
+		{LevelWarn, "we"},
+		{LevelError, "e"},
+	}
+	for _, tt := range tests {
+		SetLevel(tt.level)
+		if got := logAll(); got != tt.want {
+			t.Errorf("at %s: logged %q, want %q", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	t.Cleanup(func() { SetLevel(LevelInfo) })
+	SetLevel(LevelInfo)
+	for _, want := range []Level{LevelDebug, LevelDebug} {
+		if got := Step(-1); got != want {
+			t.Fatalf("Step(-1) = %s, want %s", got, want)
+		}
+	}
+	for _, want := range []Level{LevelInfo, LevelWarn, LevelError, LevelError} {
+		if got := Step(1); got != want {
+			t.Fatalf("Step(1) = %s, want %s", got, want)
+		}
+	}
+}
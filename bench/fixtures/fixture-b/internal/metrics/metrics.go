@@ -0,0 +1,273 @@
+// Package metrics is a minimal Prometheus-compatible metrics registry that
+// renders the text exposition format without external dependencies.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry served by the gateway's /metrics endpoint.
+var Default = NewRegistry()
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds a set of uniquely named metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]collector{}}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.collectors[c.name()]; dup {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteText renders every metric in the text exposition format, sorted by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// vec tracks one value per combination of label values.
+type vec[T any] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	newValue   func() *T
+
+	mu     sync.Mutex
+	series map[string]*series[T]
+}
+
+type series[T any] struct {
+	labelValues []string
+	value       *T
+}
+
+func (v *vec[T]) name() string { return v.metricName }
+
+func (v *vec[T]) get(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{labelValues: append([]string(nil), values...), value: v.newValue()}
+		v.series[key] = s
+	}
+	return s.value
+}
+
+func (v *vec[T]) sorted() []*series[T] {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*series[T], len(keys))
+	for i, k := range keys {
+		out[i] = v.series[k]
+	}
+	return out
+}
+
+func (v *vec[T]) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, v.kind)
+}
+
+// CounterVec is a monotonically increasing value per label combination.
+type CounterVec struct {
+	vec[float64]
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[float64]{
+		metricName: name, help: help, kind: "counter", labels: labels,
+		newValue: func() *float64 { return new(float64) },
+		series:   map[string]*series[float64]{},
+	}}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues) += delta
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, s.labelValues), formatValue(*s.value))
+	}
+}
+
+// GaugeVec is a value per label combination that can go up and down.
+type GaugeVec struct {
+	vec[float64]
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec[float64]{
+		metricName: name, help: help, kind: "gauge", labels: labels,
+		newValue: func() *float64 { return new(float64) },
+		series:   map[string]*series[float64]{},
+	}}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues) = v
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	*g.get(labelValues) += delta
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, s := range g.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, formatLabels(g.labels, s.labelValues), formatValue(*s.value))
+	}
+}
+
+// HistogramVec counts observations into cumulative buckets per label
+// combination.
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[histogram]{
+		metricName: name, help: help, kind: "histogram", labels: labels,
+		newValue: func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} },
+		series:   map[string]*series[histogram]{},
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist := h.get(labelValues)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range h.sorted() {
+		for i, upper := range h.buckets {
+			values := append(append([]string(nil), s.labelValues...), formatValue(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(bucketLabels, values), s.value.counts[i])
+		}
+		values := append(append([]string(nil), s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(bucketLabels, values), s.value.count)
+		labels := formatLabels(h.labels, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatValue(s.value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, s.value.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests served.", "method", "status")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Inc("POST", "500")
+	g := r.NewGauge("in_flight", "Requests in flight.")
+	g.Add(3)
+	g.Add(-1)
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/api")
+	h.Observe(0.5, "/api")
+	c.Inc(`we"ird`, "200")
+
+	var out strings.Builder
+	r.WriteText(&out)
+	want := `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/api",le="0.1"} 1
+latency_seconds_bucket{route="/api",le="1"} 2
+latency_seconds_bucket{route="/api",le="+Inf"} 2
+latency_seconds_sum{route="/api"} 0.55
+latency_seconds_count{route="/api"} 2
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2
+requests_total{method="POST",status="500"} 1
+requests_total{method="we\"ird",status="200"} 1
+`
+	if out.String() != want {
+		t.Fatalf("exposition mismatch:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("x_total", "x")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate registration did not panic")
+		}
+	}()
+	r.NewGauge("x_total", "x")
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/apierr"
+)
+
+// MaxBodyBytes caps request bodies at n bytes. Requests declaring a larger
+// Content-Length are rejected with 413 up front; bodies without one (chunked
+// uploads) are wrapped in http.MaxBytesReader so reads past n fail with an
+// *http.MaxBytesError, which handlers should answer with 413. It can be
+// applied globally in Chain or to individual route groups.
+func MaxBodyBytes(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				apierr.Write(w, r, http.StatusRequestEntityTooLarge, apierr.CodeBodyTooLarge, "request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodyBytes(t *testing.T) {
+	var readErr error
+	h := MaxBodyBytes(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	t.Run("declared length over limit", func(t *testing.T) {
+		readErr = nil
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789")))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("status = %d, want 413", rec.Code)
+		}
+	})
+
+	t.Run("chunked body over limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("01234"), strings.NewReader("56789")))
+		req.ContentLength = -1
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		var tooLarge *http.MaxBytesError
+		if !errors.As(readErr, &tooLarge) {
+			t.Fatalf("read error = %v, want *http.MaxBytesError", readErr)
+		}
+	})
+
+	t.Run("within limit", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small")))
+		if rec.Code != http.StatusOK || readErr != nil {
+			t.Fatalf("status = %d, read error = %v", rec.Code, readErr)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/logging"
+	"api-gateway/internal/metrics"
+)
+
+// BreakerState is the position of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed passes requests through while counting failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests fast until the cooldown elapses.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through to probe recovery.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+var breakerStateGauge = metrics.Default.NewGauge("gateway_upstream_circuit_state",
+	"Circuit breaker state per upstream: 0 closed, 1 open, 2 half-open.", "upstream")
+
+// BreakerConfig tunes a CircuitBreaker.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Defaults to 5.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a trial request is
+	// let through. Defaults to 30s.
+	Cooldown time.Duration
+}
+
+// CircuitBreaker stops sending traffic to an upstream after repeated
+// failures, giving it Cooldown to recover before probing it again.
+type CircuitBreaker struct {
+	name string
+	cfg  BreakerConfig
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreaker returns a closed breaker. name identifies the upstream
+// in metrics.
+func NewCircuitBreaker(name string, cfg BreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	b := &CircuitBreaker{name: name, cfg: cfg, now: time.Now}
+	breakerStateGauge.Set(float64(BreakerClosed), name)
+	return b
+}
+
+// Name returns the upstream name the breaker was created with.
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State returns the breaker's current state.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Available reports whether Allow would currently admit a request, without
+// reserving the half-open trial. Load balancers use it to route around
+// upstreams that would fail fast.
+func (b *CircuitBreaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return b.now().Sub(b.openedAt) >= b.cfg.Cooldown
+	case BreakerHalfOpen:
+		return !b.trial
+	}
+	return true
+}
+
+// Allow reports whether a request may proceed, reserving the trial slot
+// when the circuit is half-open. Every allowed request must be followed by
+// a call to Record. When Allow returns false, retryAfter is the remaining
+// cooldown.
+func (b *CircuitBreaker) Allow() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		remaining := b.cfg.Cooldown - b.now().Sub(b.openedAt)
+		if remaining > 0 {
+			return false, remaining
+		}
+		b.setState(BreakerHalfOpen)
+		b.trial = true
+		return true, 0
+	case BreakerHalfOpen:
+		if b.trial {
+			return false, time.Second
+		}
+		b.trial = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// Record reports the outcome of a request admitted by Allow.
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.trial = false
+		if success {
+			b.failures = 0
+			b.setState(BreakerClosed)
+		} else {
+			b.open()
+		}
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// release gives back a request admitted by Allow without an outcome, as
+// when its client left: a half-open trial passes to the next request.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(BreakerOpen)
+}
+
+func (b *CircuitBreaker) setState(s BreakerState) {
+	if b.state != s {
+		logging.Debugf("breaker %s: %s -> %s", b.name, b.state, s)
+	}
+	b.state = s
+	breakerStateGauge.Set(float64(s), b.name)
+}
+
+type healthyKey struct{}
+
+// UpstreamHealthy marks the request's upstream as having answered normally,
+// even though the client gets an error of the gateway's own, such as a 502
+// for a response over the route's size limit, so the breaker guarding the
+// request counts a success. It is a no-op outside Middleware.
+func UpstreamHealthy(ctx context.Context) {
+	if healthy, ok := ctx.Value(healthyKey{}).(*atomic.Bool); ok {
+		healthy.Store(true)
+	}
+}
+
+// Middleware guards next with the breaker. Responses with a 5xx status,
+// including the proxy's 502/504 for connection failures, count as failures;
+// while the circuit is open requests get 503 without reaching next.
+// Requests whose client disconnected, including those the proxy aborts
+// mid-response with http.ErrAbortHandler, say nothing about the upstream
+// and aren't counted either way. See UpstreamHealthy for errors that
+// aren't the upstream's.
+func (b *CircuitBreaker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := b.Allow()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			apierr.Write(w, r, http.StatusServiceUnavailable, apierr.CodeUpstreamUnavailable, "upstream unavailable")
+			return
+		}
+		healthy := new(atomic.Bool)
+		r = r.WithContext(context.WithValue(r.Context(), healthyKey{}, healthy))
+		sw := newStatusWriter(w)
+		success := false
+		defer func() {
+			if clientGone(r) {
+				b.release()
+				return
+			}
+			b.Record(success || healthy.Load())
+		}()
+		next.ServeHTTP(sw, r)
+		success = sw.status < 500
+	})
+}
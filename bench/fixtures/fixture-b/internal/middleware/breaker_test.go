@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewCircuitBreaker("test-transitions", BreakerConfig{FailureThreshold: 3, Cooldown: 10 * time.Second})
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		t.Helper()
+		if ok, _ := b.Allow(); !ok {
+			t.Fatal("request unexpectedly rejected")
+		}
+		b.Record(false)
+	}
+
+	fail()
+	fail()
+	b.Allow()
+	b.Record(true) // a success resets the consecutive count
+	fail()
+	fail()
+	if b.State() != BreakerClosed {
+		t.Fatalf("state = %v after non-consecutive failures, want closed", b.State())
+	}
+	fail()
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+
+	if ok, retry := b.Allow(); ok || retry != 10*time.Second {
+		t.Fatalf("Allow() while open = %v, %v", ok, retry)
+	}
+	if b.Available() {
+		t.Fatal("Available() while open = true")
+	}
+
+	now = now.Add(10 * time.Second)
+	if !b.Available() || b.State() != BreakerOpen {
+		t.Fatal("Available() after cooldown should be true without leaving open")
+	}
+	if ok, _ := b.Allow(); !ok {
+		t.Fatal("trial request rejected after cooldown")
+	}
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state = %v, want half-open", b.State())
+	}
+	if ok, _ := b.Allow(); ok || b.Available() {
+		t.Fatal("second concurrent trial allowed")
+	}
+	b.Record(false)
+	if b.State() != BreakerOpen {
+		t.Fatalf("failed trial: state = %v, want open", b.State())
+	}
+
+	now = now.Add(10 * time.Second)
+	b.Allow()
+	b.Record(true)
+	if b.State() != BreakerClosed {
+		t.Fatalf("successful trial: state = %v, want closed", b.State())
+	}
+}
+
+func TestCircuitBreakerMiddleware(t *testing.T) {
+	status := http.StatusBadGateway
+	calls := 0
+	b := NewCircuitBreaker("test-middleware", BreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	h := b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	do()
+	do()
+	rec := do()
+	if rec.Code != http.StatusServiceUnavailable || calls != 2 {
+		t.Fatalf("open circuit: status = %d, upstream calls = %d", rec.Code, calls)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestCircuitBreakerIgnoresClientDisconnects(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first chunk")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(up.Close)
+	target, _ := url.Parse(up.URL)
+	b := NewCircuitBreaker("test-disconnects", BreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	h := b.Middleware(httputil.NewSingleHostReverseProxy(target))
+	served := make(chan struct{}, 1)
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { served <- struct{}{} }()
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(gw.Close)
+
+	// Each client reads the start of a streamed response and hangs up, so
+	// the proxy aborts its copy.
+	for range 3 {
+		resp, err := http.Get(gw.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadFull(resp.Body, make([]byte, len("first chunk")))
+		resp.Body.Close()
+		select {
+		case <-served:
+		case <-time.After(2 * time.Second):
+			t.Fatal("gateway didn't finish the abandoned request")
+		}
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state after client disconnects = %s, want closed", got)
+	}
+}
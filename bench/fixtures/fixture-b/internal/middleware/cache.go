@@ -0,0 +1,477 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/auth"
+)
+
+// credentialHeaders are the request headers a client can authenticate
+// with: a token, an API key, or a session cookie.
+var credentialHeaders = []string{"Authorization", auth.APIKeyHeader, "Cookie"}
+
+// CachedResponse is a stored upstream response.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored and Expires bound the entry's freshness.
+	Stored  time.Time
+	Expires time.Time
+	// Vary maps each header named in the response's Vary to the value the
+	// original request carried. A later request must match all of them.
+	Vary map[string]string
+}
+
+// size approximates the entry's memory footprint for store accounting.
+func (c *CachedResponse) size() int64 {
+	n := int64(len(c.Body))
+	for k, vs := range c.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// CacheStore holds cached responses. Implementations must be safe for
+// concurrent use and may evict entries at any time.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+	Delete(key string)
+}
+
+// LRUStore is an in-memory CacheStore that evicts the least recently used
+// entries once their combined size exceeds a byte budget.
+type LRUStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewLRUStore returns an LRUStore holding at most maxBytes of responses.
+func NewLRUStore(maxBytes int64) *LRUStore {
+	return &LRUStore{maxBytes: maxBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (s *LRUStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruEntry).resp, true
+}
+
+func (s *LRUStore) Set(key string, resp *CachedResponse) {
+	size := resp.size()
+	if size > s.maxBytes {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	s.items[key] = s.order.PushFront(&lruEntry{key: key, resp: resp})
+	s.bytes += size
+	for s.bytes > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *LRUStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+}
+
+// Len returns the number of cached entries.
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *LRUStore) remove(el *list.Element) {
+	e := s.order.Remove(el).(*lruEntry)
+	delete(s.items, e.key)
+	s.bytes -= e.resp.size()
+}
+
+// CacheConfig tunes NewCache.
+type CacheConfig struct {
+	// Store holds the cached responses. Defaults to a 64MB LRUStore.
+	Store CacheStore
+	// DefaultTTL applies to responses without a max-age. Defaults to 1m.
+	DefaultTTL time.Duration
+	// MaxBodyBytes is the largest body worth caching; bigger responses
+	// stream through uncached. Defaults to 1MB.
+	MaxBodyBytes int
+	// KeyClaims names claims whose values in the request's context are part
+	// of the cache key. Set it to the claims the proxy sends upstream as
+	// headers: those responses differ by user, but the headers are added
+	// after the key is chosen, so Vary can't tell entries apart.
+	KeyClaims []string
+	// KeyPrefix starts every key, setting apart the entries of caches that
+	// share a Store but not an upstream, such as the variants of a split
+	// route.
+	KeyPrefix string
+}
+
+// NewCache returns middleware that caches 200 responses to GET requests,
+// keyed by path and query, and replays them with X-Cache: HIT until they go
+// stale. Freshness comes from the response's s-maxage or max-age, falling
+// back to cfg.DefaultTTL. Upgrade requests such as WebSocket handshakes
+// bypass the cache.
+//
+// Responses are never stored when they set a cookie, carry no-store,
+// no-cache or private, or have Vary: *. Other Vary headers are honoured by
+// remembering the request's values and only replaying to matching requests.
+// Responses to authenticated requests, those with claims in their context
+// or carrying an Authorization, X-API-Key or Cookie header, are stored only
+// when the upstream marks them public or varies on every one of those
+// headers the request carries, so one client's data isn't served to
+// another.
+//
+// Concurrent misses for the same key are coalesced: the first goes to the
+// upstream while the rest wait for it, so an expired entry doesn't send a
+// stampede upstream. Waiters are served the response once it is stored,
+// if their Vary headers match, or the upstream's 5xx if it failed. Any
+// other outcome, such as an uncacheable response or the first client
+// going away, sends each waiter upstream on its own.
+func NewCache(cfg CacheConfig) Middleware {
+	return newCache(cfg).middleware
+}
+
+type cache struct {
+	cfg CacheConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a cache miss being fetched from the upstream. resp is set
+// before done is closed, and left nil if waiters must fetch for themselves.
+type flight struct {
+	done chan struct{}
+	resp *CachedResponse
+}
+
+// join returns the flight fetching key, starting one if there is none.
+// leader reports whether the caller started it and must land it.
+func (c *cache) join(key string) (f *flight, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+// land hands resp to the flight's waiters.
+func (c *cache) land(key string, f *flight, resp *CachedResponse) {
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	f.resp = resp
+	close(f.done)
+}
+
+func newCache(cfg CacheConfig) *cache {
+	if cfg.Store == nil {
+		cfg.Store = NewLRUStore(64 << 20)
+	}
+	if cfg.DefaultTTL <= 0 {
+		cfg.DefaultTTL = time.Minute
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	return &cache{cfg: cfg, now: time.Now, flights: map[string]*flight{}}
+}
+
+func (c *cache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || isUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		_, noCache := reqCC["no-cache"]
+		_, noStore := reqCC["no-store"]
+		if !noCache {
+			if cached, ok := c.cfg.Store.Get(key); ok && c.now().Before(cached.Expires) && varyMatches(cached, r) {
+				serveCached(w, cached, c.now())
+				return
+			}
+		}
+
+		var shared *CachedResponse
+		if !noCache && !noStore {
+			f, leader := c.join(key)
+			if !leader {
+				select {
+				case <-f.done:
+				case <-r.Context().Done():
+					if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+						apierr.Write(w, r, http.StatusGatewayTimeout, apierr.CodeGatewayTimeout, "gateway timeout")
+					}
+					return
+				}
+				if resp := f.resp; resp != nil && varyMatches(resp, r) {
+					serveShared(w, resp, c.now())
+					return
+				}
+			} else {
+				defer func() { c.land(key, f, shared) }()
+			}
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		// Headers already set by outer middleware, such as X-Request-ID,
+		// belong to this request and mustn't be replayed on later hits.
+		before := w.Header().Clone()
+		cw := &cacheWriter{statusWriter: newStatusWriter(w), limit: c.cfg.MaxBodyBytes}
+		next.ServeHTTP(cw, r)
+		// A fetch cut short by this client leaving says nothing about the
+		// upstream, so waiters go and ask it themselves.
+		if noStore || cw.overflow || r.Context().Err() != nil {
+			return
+		}
+		if cw.status >= 500 {
+			shared = &CachedResponse{Status: cw.status, Header: addedHeaders(before, w.Header()), Body: cw.body.Bytes()}
+			return
+		}
+		if cw.status != http.StatusOK {
+			return
+		}
+		if resp := cacheable(r, w.Header(), c.cfg.DefaultTTL, c.now()); resp != nil {
+			resp.Header = addedHeaders(before, w.Header())
+			resp.Body = cw.body.Bytes()
+			c.cfg.Store.Set(key, resp)
+			shared = resp
+		}
+	})
+}
+
+// key returns the entry r is cached under: any KeyPrefix, its path and
+// query, then the values of any KeyClaims, JSON-encoded so that distinct
+// values stay distinct.
+func (c *cache) key(r *http.Request) string {
+	key := r.URL.RequestURI()
+	if c.cfg.KeyPrefix != "" {
+		key = c.cfg.KeyPrefix + "\x00" + key
+	}
+	if len(c.cfg.KeyClaims) == 0 {
+		return key
+	}
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	for _, name := range c.cfg.KeyClaims {
+		v, _ := json.Marshal(claims[name])
+		key += "\x00" + name + "=" + string(v)
+	}
+	return key
+}
+
+// serveShared answers a waiter with the response its flight's leader got:
+// a hit on the entry just stored, or else the upstream's error.
+func serveShared(w http.ResponseWriter, resp *CachedResponse, now time.Time) {
+	if resp.Status == http.StatusOK {
+		serveCached(w, resp, now)
+		return
+	}
+	h := w.Header()
+	for k, vs := range resp.Header {
+		h[k] = slices.Clone(vs)
+	}
+	h.Set("X-Cache", "MISS")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+func serveCached(w http.ResponseWriter, cached *CachedResponse, now time.Time) {
+	h := w.Header()
+	for k, vs := range cached.Header {
+		h[k] = slices.Clone(vs)
+	}
+	h.Set("X-Cache", "HIT")
+	h.Set("Age", strconv.Itoa(int(now.Sub(cached.Stored).Seconds())))
+	w.WriteHeader(cached.Status)
+	w.Write(cached.Body)
+}
+
+// cacheable builds a CachedResponse from the response headers, or returns
+// nil if the response must not be stored.
+func cacheable(r *http.Request, header http.Header, defaultTTL time.Duration, now time.Time) *CachedResponse {
+	if header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return nil
+		}
+	}
+
+	vary := map[string]string{}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[name] = r.Header.Get(name)
+			}
+		}
+	}
+	if _, public := cc["public"]; !public && private(r) && !variesOnCredentials(r, vary) {
+		return nil
+	}
+
+	ttl := defaultTTL
+	if v, ok := cc["s-maxage"]; ok {
+		ttl = maxAge(v)
+	} else if v, ok := cc["max-age"]; ok {
+		ttl = maxAge(v)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &CachedResponse{
+		Status:  http.StatusOK,
+		Stored:  now,
+		Expires: now.Add(ttl),
+		Vary:    vary,
+	}
+}
+
+// addedHeaders returns the headers in after that differ from before.
+func addedHeaders(before, after http.Header) http.Header {
+	added := http.Header{}
+	for k, vs := range after {
+		if !slices.Equal(before[k], vs) {
+			added[k] = slices.Clone(vs)
+		}
+	}
+	return added
+}
+
+// private reports whether r is authenticated, by its claims, a verified
+// client certificate or a credential header, so its response may be meant
+// for its client alone.
+func private(r *http.Request) bool {
+	if _, ok := auth.ClaimsFromContext(r.Context()); ok {
+		return true
+	}
+	if _, ok := auth.ClientCertFromContext(r.Context()); ok {
+		return true
+	}
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// variesOnCredentials reports whether a response varying on the vary
+// headers keys on all of r's credentials. A request carrying none of the
+// credential headers, such as one that only presented a client
+// certificate, can't be told apart by them.
+func variesOnCredentials(r *http.Request, vary map[string]string) bool {
+	carried := false
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) == "" {
+			continue
+		}
+		if _, ok := vary[http.CanonicalHeaderKey(name)]; !ok {
+			return false
+		}
+		carried = true
+	}
+	return carried
+}
+
+func varyMatches(cached *CachedResponse, r *http.Request) bool {
+	for name, want := range cached.Vary {
+		if r.Header.Get(name) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCacheControl splits a Cache-Control value into lower-cased
+// directives and their (unquoted) arguments.
+func parseCacheControl(v string) map[string]string {
+	cc := map[string]string{}
+	for _, part := range strings.Split(v, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+	return cc
+}
+
+func maxAge(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// cacheWriter copies the response body into a buffer, up to limit, while
+// writing it through to the client.
+type cacheWriter struct {
+	*statusWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if w.body.Len()+len(b) > w.limit {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.statusWriter.Write(b)
+}
@@ -0,0 +1,357 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/internal/auth"
+)
+
+// countingUpstream answers with its hit count, after applying set to the
+// response headers.
+func countingUpstream(set func(h http.Header)) (http.Handler, *int) {
+	calls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if set != nil {
+			set(w.Header())
+		}
+		fmt.Fprintf(w, "response %d", calls)
+	}), &calls
+}
+
+func TestCacheServesHitsUntilStale(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newCache(CacheConfig{})
+	c.now = func() time.Time { return now }
+	next, calls := countingUpstream(func(h http.Header) { h.Set("Cache-Control", "max-age=30") })
+	h := RequestID(c.middleware(next))
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	first := get("/items?page=1")
+	if first.Header().Get("X-Cache") != "MISS" || first.Body.String() != "response 1" {
+		t.Fatalf("first: X-Cache %q, body %q", first.Header().Get("X-Cache"), first.Body.String())
+	}
+	now = now.Add(10 * time.Second)
+	hit := get("/items?page=1")
+	if hit.Header().Get("X-Cache") != "HIT" || hit.Body.String() != "response 1" || hit.Header().Get("Age") != "10" {
+		t.Fatalf("hit: X-Cache %q, Age %q, body %q", hit.Header().Get("X-Cache"), hit.Header().Get("Age"), hit.Body.String())
+	}
+	if hit.Header().Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+		t.Fatal("hit replayed the original request's X-Request-ID")
+	}
+	if get("/items?page=2").Body.String() != "response 2" {
+		t.Fatal("different query served from cache")
+	}
+
+	now = now.Add(30 * time.Second)
+	if rec := get("/items?page=1"); rec.Header().Get("X-Cache") != "MISS" || *calls != 3 {
+		t.Fatalf("stale entry: X-Cache %q after %d upstream calls", rec.Header().Get("X-Cache"), *calls)
+	}
+}
+
+func TestCacheSkipsUncacheableResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		status int
+		header map[string]string
+	}{
+		{"POST", http.MethodPost, "", http.StatusOK, nil},
+		{"error status", http.MethodGet, "", http.StatusNotFound, nil},
+		{"Set-Cookie", http.MethodGet, "", http.StatusOK, map[string]string{"Set-Cookie": "s=1"}},
+		{"no-store", http.MethodGet, "", http.StatusOK, map[string]string{"Cache-Control": "no-store"}},
+		{"private", http.MethodGet, "", http.StatusOK, map[string]string{"Cache-Control": "private, max-age=60"}},
+		{"max-age=0", http.MethodGet, "", http.StatusOK, map[string]string{"Cache-Control": "max-age=0"}},
+		{"Vary *", http.MethodGet, "", http.StatusOK, map[string]string{"Vary": "*"}},
+		{"authorized, not public", http.MethodGet, "Bearer t", http.StatusOK, nil},
+		{"authorized, varying on another credential", http.MethodGet, "Bearer t", http.StatusOK,
+			map[string]string{"Vary": "X-API-Key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := NewCache(CacheConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			for range 2 {
+				req := httptest.NewRequest(tt.method, "/x", nil)
+				if tt.auth != "" {
+					req.Header.Set("Authorization", tt.auth)
+				}
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			if calls != 2 {
+				t.Fatalf("upstream calls = %d, want 2 (response was cached)", calls)
+			}
+		})
+	}
+}
+
+func TestCacheHonoursVary(t *testing.T) {
+	next, calls := countingUpstream(func(h http.Header) { h.Set("Vary", "Authorization, Accept-Language") })
+	h := NewCache(CacheConfig{})(next)
+
+	get := func(auth, lang string) string {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("X-Cache")
+	}
+
+	get("Bearer alice", "en")
+	if got := get("Bearer alice", "en"); got != "HIT" {
+		t.Fatalf("same client: X-Cache = %q, want HIT", got)
+	}
+	if got := get("Bearer bob", "en"); got != "MISS" {
+		t.Fatalf("other client: X-Cache = %q, want MISS", got)
+	}
+	if got := get("Bearer bob", "fr"); got != "MISS" || *calls != 3 {
+		t.Fatalf("other language: X-Cache = %q, calls = %d", got, *calls)
+	}
+}
+
+func TestCacheKeepsAPIKeyAndSessionResponsesPrivate(t *testing.T) {
+	for _, credential := range []string{"X-API-Key", "Cookie"} {
+		t.Run(credential, func(t *testing.T) {
+			// The upstream answers with the user the auth middleware found.
+			h := NewCache(CacheConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ := auth.ClaimsFromContext(r.Context())
+				fmt.Fprintf(w, "user=%s", claims["sub"])
+			}))
+			get := func(value, sub string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/me", nil)
+				req.Header.Set(credential, value)
+				req = req.WithContext(auth.WithClaims(req.Context(), map[string]any{"sub": sub}))
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+			get("alice-credential", "alice")
+			if rec := get("bob-credential", "bob"); rec.Body.String() != "user=bob" {
+				t.Fatalf("bob got %q (X-Cache %s)", rec.Body, rec.Header().Get("X-Cache"))
+			}
+		})
+	}
+
+	// Claims without a credential header, as a test or an embedder might
+	// set, and a verified client certificate, which sends no header, make
+	// a request private too.
+	h := NewCache(CacheConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for name, with := range map[string]func(context.Context) context.Context{
+		"claims": func(ctx context.Context) context.Context {
+			return auth.WithClaims(ctx, map[string]any{"sub": "alice"})
+		},
+		"client certificate": func(ctx context.Context) context.Context {
+			return auth.WithClientCert(ctx, auth.ClientCert{CommonName: "billing"})
+		},
+	} {
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req = req.WithContext(with(req.Context()))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Header().Get("X-Cache") != "MISS" {
+				t.Fatalf("response to a request with %s was cached", name)
+			}
+		}
+	}
+}
+
+func TestCacheStoresResponsesVaryingOnAPIKey(t *testing.T) {
+	next, calls := countingUpstream(func(h http.Header) { h.Set("Vary", "X-API-Key") })
+	h := NewCache(CacheConfig{})(next)
+	get := func(key string) string {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	get("key-alice")
+	if got := get("key-alice"); got != "response 1" {
+		t.Fatalf("same key: body = %q, want a hit", got)
+	}
+	if got := get("key-bob"); got != "response 2" || *calls != 2 {
+		t.Fatalf("other key: body = %q, calls = %d", got, *calls)
+	}
+}
+
+func TestCacheRequestNoCacheRevalidates(t *testing.T) {
+	next, calls := countingUpstream(nil)
+	h := NewCache(CacheConfig{})(next)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != "response 2" || *calls != 2 {
+		t.Fatalf("no-cache request served %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if rec.Body.String() != "response 2" {
+		t.Fatalf("refreshed entry not stored: %q", rec.Body.String())
+	}
+}
+
+func TestCacheSkipsOversizeBodies(t *testing.T) {
+	calls := 0
+	h := NewCache(CacheConfig{MaxBodyBytes: 8})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(strings.Repeat("x", 16)))
+	}))
+	for range 2 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/big", nil))
+		if rec.Body.Len() != 16 {
+			t.Fatalf("body truncated to %d bytes", rec.Body.Len())
+		}
+	}
+	if calls != 2 {
+		t.Fatal("oversize body was cached")
+	}
+}
+
+func TestCacheCoalescesConcurrentMisses(t *testing.T) {
+	const clients = 10
+	tests := []struct {
+		name      string
+		status    int
+		header    string
+		wantCalls int32
+		wantCache string
+	}{
+		{"stored response", http.StatusOK, "max-age=30", 1, "HIT"},
+		{"upstream error", http.StatusBadGateway, "", 1, "MISS"},
+		{"uncacheable response", http.StatusOK, "private", clients, "MISS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			entered := make(chan struct{}, clients)
+			release := make(chan struct{})
+			h := NewCache(CacheConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				entered <- struct{}{}
+				if n == 1 {
+					<-release
+				}
+				if tt.header != "" {
+					w.Header().Set("Cache-Control", tt.header)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte("shared"))
+			}))
+
+			recs := make([]*httptest.ResponseRecorder, clients)
+			var wg sync.WaitGroup
+			serve := func(i int) {
+				defer wg.Done()
+				recs[i] = httptest.NewRecorder()
+				h.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/items", nil))
+			}
+			wg.Add(clients)
+			go serve(0)
+			<-entered
+			for i := 1; i < clients; i++ {
+				go serve(i)
+			}
+			// Give the others time to find the first one's fetch.
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", got, tt.wantCalls)
+			}
+			for i, rec := range recs[1:] {
+				if rec.Code != tt.status || rec.Body.String() != "shared" || rec.Header().Get("X-Cache") != tt.wantCache {
+					t.Fatalf("client %d: status %d, X-Cache %q, body %q", i+1, rec.Code, rec.Header().Get("X-Cache"), rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCacheWaiterFetchesAfterLeaderLeaves(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 2)
+	h := NewCache(CacheConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		entered <- struct{}{}
+		if r.Header.Get("X-Leader") != "" {
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("fresh"))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := httptest.NewRequest(http.MethodGet, "/items", nil).WithContext(ctx)
+	leader.Header.Set("X-Leader", "1")
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		h.ServeHTTP(httptest.NewRecorder(), leader)
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items", nil))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-leaderDone
+	<-waiterDone
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "fresh" || calls.Load() != 2 {
+		t.Fatalf("waiter: status %d, body %q after %d upstream calls", rec.Code, rec.Body.String(), calls.Load())
+	}
+}
+
+func TestLRUStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewLRUStore(10)
+	entry := func(body string) *CachedResponse { return &CachedResponse{Body: []byte(body)} }
+
+	s.Set("a", entry("aaaa"))
+	s.Set("b", entry("bbbb"))
+	s.Get("a") // a is now more recent than b
+	s.Set("c", entry("cccc"))
+
+	if _, ok := s.Get("b"); ok {
+		t.Fatal("least recently used entry survived")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Fatal("recently used entry evicted")
+	}
+	s.Set("huge", entry(strings.Repeat("x", 11)))
+	if _, ok := s.Get("huge"); ok || s.Len() != 2 {
+		t.Fatalf("entry over the whole budget stored; len = %d", s.Len())
+	}
+}
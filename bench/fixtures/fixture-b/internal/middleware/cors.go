@@ -1,16 +1,106 @@
 package middleware
 
-import "net/http"
-
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusNoContent)
-			return
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig describes a cross-origin resource sharing policy.
+type CORSConfig struct {
+	// AllowedOrigins lists origins permitted to make cross-origin requests.
+	// "*" permits any origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and auth headers. The
+	// matching origin is then echoed back instead of "*", as the spec
+	// forbids a wildcard on credentialed requests.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response. Zero
+	// leaves it to the browser's default.
+	MaxAge time.Duration
+}
+
+// corsAppliedKey marks a request a CORS policy has already handled.
+type corsAppliedKey struct{}
+
+// CORS allows any origin to call any of the common methods with
+// Content-Type and Authorization headers.
+var CORS = NewCORS(CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+	AllowedHeaders: []string{"Content-Type", "Authorization"},
+})
+
+// NewCORS returns middleware applying cfg. Preflight requests (OPTIONS with
+// Origin and Access-Control-Request-Method) are answered with 204 and never
+// reach later middleware; requests from origins outside the policy get no
+// Access-Control-* headers, which browsers treat as a denial.
+//
+// Only the first CORS policy a request passes through applies. When route
+// groups nest, each with its own policy, the outermost group's decides and
+// the inner ones pass the request straight on, so a response never mixes
+// headers from two policies.
+func NewCORS(cfg CORSConfig) Middleware {
+	wildcard := false
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			wildcard = true
 		}
-		next.ServeHTTP(w, r)
-	})
+		origins[strings.ToLower(o)] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := ""
+	if cfg.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(corsAppliedKey{}) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), corsAppliedKey{}, true))
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+
+			h := w.Header()
+			if !wildcard || cfg.AllowCredentials {
+				h.Add("Vary", "Origin")
+			}
+			allowed := origin != "" && (wildcard || origins[strings.ToLower(origin)])
+			if allowed {
+				if wildcard && !cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed {
+				h.Set("Access-Control-Allow-Methods", methods)
+				if headers != "" {
+					h.Set("Access-Control-Allow-Headers", headers)
+				}
+				if maxAge != "" {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
 }
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	strict := NewCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	tests := []struct {
+		name        string
+		mw          Middleware
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantCreds   string
+		wantMaxAge  string
+		wantMethods string
+	}{
+		{"default simple request", CORS, "GET", "https://any.example", false, http.StatusOK, "*", "", "", ""},
+		{"default preflight", CORS, "OPTIONS", "https://any.example", true, http.StatusNoContent, "*", "", "", "GET, POST, PUT, DELETE, OPTIONS"},
+		{"credentials echo origin", strict, "GET", "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true", "", ""},
+		{"preflight allowed origin", strict, "OPTIONS", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", "true", "600", "GET, POST"},
+		{"disallowed origin", strict, "GET", "https://evil.example", false, http.StatusOK, "", "", "", ""},
+		{"preflight disallowed origin", strict, "OPTIONS", "https://evil.example", true, http.StatusNoContent, "", "", "", ""},
+		{"plain OPTIONS reaches handler", strict, "OPTIONS", "", false, http.StatusOK, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/api/v1/users", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":      tt.wantOrigin,
+				"Access-Control-Allow-Credentials": tt.wantCreds,
+				"Access-Control-Max-Age":           tt.wantMaxAge,
+				"Access-Control-Allow-Methods":     tt.wantMethods,
+			} {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSFirstPolicyWins(t *testing.T) {
+	public := NewCORS(CORSConfig{AllowedOrigins: []string{"https://www.example.com"}, AllowedMethods: []string{"GET"}, MaxAge: time.Hour})
+	partner := NewCORS(CORSConfig{AllowedOrigins: []string{"https://partner.example.net"}, AllowedMethods: []string{"GET", "POST"}})
+	reached := false
+	h := public(partner(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })))
+
+	tests := []struct {
+		name       string
+		origin     string
+		preflight  bool
+		wantOrigin string
+		wantMaxAge string
+	}{
+		{"outer policy's origin", "https://www.example.com", true, "https://www.example.com", "3600"},
+		{"inner policy's origin denied", "https://partner.example.net", true, "", ""},
+		{"simple request", "https://partner.example.net", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest(http.MethodOptions, "/partner/v1/orders", nil)
+			if !tt.preflight {
+				req.Method = http.MethodGet
+			}
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Errorf("Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+			if got := rec.Header().Values("Vary"); len(got) != 1 {
+				t.Errorf("Vary = %q, want a single Origin", got)
+			}
+			if reached == tt.preflight {
+				t.Errorf("handler reached = %v on preflight = %v", reached, tt.preflight)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// alwaysRedacted are the headers NewDumpBody never logs, whatever the
+// config. X-API-Key is auth.APIKeyHeader.
+var alwaysRedacted = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key"}
+
+// DumpConfig configures NewDumpBody.
+type DumpConfig struct {
+	// Out receives one JSON object per request. Defaults to stderr.
+	Out io.Writer
+	// MaxBytes caps how much of each body is logged. Defaults to 4KB.
+	MaxBytes int
+	// RedactHeaders are logged as "[REDACTED]", in addition to
+	// Authorization, cookies, and the API key header.
+	RedactHeaders []string
+	// RedactFields are JSON object keys and form fields, matched
+	// case-insensitively at any depth, whose values are logged as
+	// "[REDACTED]". A JSON or form body that can't be parsed, for instance
+	// because it was truncated at MaxBytes, is omitted rather than logged
+	// unredacted.
+	RedactFields []string
+}
+
+type dumpEntry struct {
+	Timestamp         string              `json:"timestamp"`
+	RequestID         string              `json:"request_id,omitempty"`
+	Method            string              `json:"method"`
+	Path              string              `json:"path"`
+	Status            int                 `json:"status"`
+	RequestHeaders    map[string][]string `json:"request_headers"`
+	RequestBody       string              `json:"request_body,omitempty"`
+	RequestTruncated  bool                `json:"request_truncated,omitempty"`
+	ResponseHeaders   map[string][]string `json:"response_headers"`
+	ResponseBody      string              `json:"response_body,omitempty"`
+	ResponseTruncated bool                `json:"response_truncated,omitempty"`
+}
+
+// NewDumpBody returns debugging middleware that logs each request and
+// response with its headers and the first cfg.MaxBytes of its body. The
+// request body is copied as the handler reads it, so the handler still
+// sees all of it, and the response is copied as it is written.
+//
+// Bodies can carry credentials and personal data, and copying them costs
+// memory on every request: apply it only to the routes being debugged.
+func NewDumpBody(cfg DumpConfig) Middleware {
+	if cfg.Out == nil {
+		cfg.Out = os.Stderr
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 4 << 10
+	}
+	headers := make(map[string]bool)
+	for _, h := range append(alwaysRedacted, cfg.RedactHeaders...) {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+	fields := make(map[string]bool)
+	for _, f := range cfg.RedactFields {
+		fields[strings.ToLower(f)] = true
+	}
+	d := &dumper{out: cfg.Out, max: cfg.MaxBytes, headers: headers, fields: fields}
+	return d.middleware
+}
+
+type dumper struct {
+	mu      sync.Mutex
+	out     io.Writer
+	max     int
+	headers map[string]bool
+	fields  map[string]bool
+}
+
+func (d *dumper) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqHeader := r.Header.Clone()
+		var reqBody *capture
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = &capture{max: d.max}
+			r.Body = &teeBody{ReadCloser: r.Body, c: reqBody}
+		}
+		dw := &dumpWriter{statusWriter: newStatusWriter(w), c: capture{max: d.max}}
+
+		next.ServeHTTP(dw, r)
+
+		e := dumpEntry{
+			Timestamp:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:       RequestIDFromContext(r.Context()),
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Status:          dw.status,
+			RequestHeaders:  d.redactHeaders(reqHeader),
+			ResponseHeaders: d.redactHeaders(dw.Header()),
+		}
+		if reqBody != nil {
+			e.RequestBody, e.RequestTruncated = d.redactBody(reqHeader, reqBody)
+		}
+		e.ResponseBody, e.ResponseTruncated = d.redactBody(dw.Header(), &dw.c)
+		d.write(e)
+	})
+}
+
+func (d *dumper) write(e dumpEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	json.NewEncoder(d.out).Encode(e)
+}
+
+func (d *dumper) redactHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		if d.headers[http.CanonicalHeaderKey(k)] {
+			v = []string{redacted}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// redactBody renders a captured body for the log. Encoded bodies are
+// described rather than logged, since their bytes mean nothing to a reader.
+func (d *dumper) redactBody(h http.Header, c *capture) (body string, truncated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buf.Len() == 0 {
+		return "", c.truncated
+	}
+	if enc := h.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return "[" + enc + " encoded, " + strconv.Itoa(c.total) + " bytes]", c.truncated
+	}
+	if len(d.fields) == 0 {
+		return c.buf.String(), c.truncated
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v any
+		if c.truncated || json.Unmarshal(c.buf.Bytes(), &v) != nil {
+			return "[unparseable JSON omitted]", c.truncated
+		}
+		out, _ := json.Marshal(d.redactJSON(v))
+		return string(out), false
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(c.buf.String())
+		if c.truncated || err != nil {
+			return "[unparseable form omitted]", c.truncated
+		}
+		for k := range form {
+			if d.fields[strings.ToLower(k)] {
+				form[k] = []string{redacted}
+			}
+		}
+		return form.Encode(), false
+	}
+	return c.buf.String(), c.truncated
+}
+
+func (d *dumper) redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if d.fields[strings.ToLower(k)] {
+				v[k] = redacted
+			} else {
+				v[k] = d.redactJSON(val)
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = d.redactJSON(val)
+		}
+	}
+	return v
+}
+
+// capture keeps the first max bytes written to it and counts the rest.
+// It is locked because the transport may still be reading a request body
+// when the handler returns.
+type capture struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	total     int
+	truncated bool
+}
+
+func (c *capture) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(b)
+	c.total += n
+	if room := c.max - c.buf.Len(); room < n {
+		c.truncated = true
+		b = b[:max(room, 0)]
+	}
+	c.buf.Write(b)
+	return n, nil
+}
+
+// teeBody copies what the handler reads from a request body into c.
+type teeBody struct {
+	io.ReadCloser
+	c *capture
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.c.Write(p[:n])
+	return n, err
+}
+
+// dumpWriter copies the response body into c as it is written.
+type dumpWriter struct {
+	*statusWriter
+	c capture
+}
+
+func (w *dumpWriter) Write(b []byte) (int, error) {
+	n, err := w.statusWriter.Write(b)
+	w.c.Write(b[:n])
+	return n, err
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func dumpEntryFor(t *testing.T, cfg DumpConfig, req *http.Request, h http.HandlerFunc) (dumpEntry, *httptest.ResponseRecorder) {
+	t.Helper()
+	var logs bytes.Buffer
+	cfg.Out = &logs
+	rec := httptest.NewRecorder()
+	NewDumpBody(cfg)(h).ServeHTTP(rec, req)
+	var e dumpEntry
+	if err := json.Unmarshal(logs.Bytes(), &e); err != nil {
+		t.Fatalf("dump is not JSON: %v\n%s", err, logs.String())
+	}
+	return e, rec
+}
+
+func TestDumpBodyTeesWithoutConsuming(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/x", strings.NewReader("ping"))
+	var seen string
+	e, rec := dumpEntryFor(t, DumpConfig{}, req, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		seen = string(b)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "pong")
+	})
+	if seen != "ping" || rec.Body.String() != "pong" {
+		t.Fatalf("handler saw %q, client got %q", seen, rec.Body.String())
+	}
+	if e.RequestBody != "ping" || e.ResponseBody != "pong" || e.Status != http.StatusCreated {
+		t.Fatalf("entry = %+v", e)
+	}
+}
+
+func TestDumpBodyTruncates(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/x", strings.NewReader(strings.Repeat("a", 100)))
+	e, _ := dumpEntryFor(t, DumpConfig{MaxBytes: 10}, req, func(w http.ResponseWriter, r *http.Request) {
+		if b, _ := io.ReadAll(r.Body); len(b) != 100 {
+			t.Errorf("handler read %d bytes, want all 100", len(b))
+		}
+		io.WriteString(w, strings.Repeat("b", 100))
+	})
+	if e.RequestBody != strings.Repeat("a", 10) || !e.RequestTruncated {
+		t.Errorf("request body %q, truncated %v", e.RequestBody, e.RequestTruncated)
+	}
+	if e.ResponseBody != strings.Repeat("b", 10) || !e.ResponseTruncated {
+		t.Errorf("response body %q, truncated %v", e.ResponseBody, e.ResponseTruncated)
+	}
+}
+
+func TestDumpBodyRedacts(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		max         int
+		want        string
+	}{
+		{"json", "application/json", `{"user":"ann","Password":"hunter2","nested":[{"token":"t"}]}`, 0,
+			`{"Password":"[REDACTED]","nested":[{"token":"[REDACTED]"}],"user":"ann"}`},
+		{"form", "application/x-www-form-urlencoded", "password=hunter2&user=ann", 0,
+			"password=%5BREDACTED%5D&user=ann"},
+		{"truncated json", "application/json", `{"password":"hunter2"}`, 8, "[unparseable JSON omitted]"},
+		{"plain text", "text/plain", "password=hunter2", 0, "password=hunter2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/x", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("X-Session", "s")
+			cfg := DumpConfig{MaxBytes: tt.max, RedactHeaders: []string{"x-session"}, RedactFields: []string{"password", "token"}}
+			e, _ := dumpEntryFor(t, cfg, req, func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+			})
+			if e.RequestBody != tt.want {
+				t.Errorf("body = %s, want %s", e.RequestBody, tt.want)
+			}
+			for _, h := range []string{"Authorization", "X-Session"} {
+				if got := e.RequestHeaders[h]; len(got) != 1 || got[0] != redacted {
+					t.Errorf("%s = %v, want redacted", h, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// FallbackHeader marks responses served by NewFallback.
+const FallbackHeader = "X-From-Fallback"
+
+// FallbackConfig is the response NewFallback serves in place of an
+// unavailable upstream's.
+type FallbackConfig struct {
+	// Status defaults to 200.
+	Status int
+	// ContentType defaults to "application/json".
+	ContentType string
+	Body        []byte
+}
+
+// NewFallback returns middleware that answers GET and HEAD requests with
+// cfg's response, marked with X-From-Fallback: true, when next answers
+// 502, 503 or 504: the circuit breaker is open, the upstream can't be
+// reached or timed out, or it reports itself unavailable. Whatever next
+// wrote is discarded. Fallback responses carry Cache-Control: no-store,
+// so clients don't keep the degraded payload once the upstream is back.
+// Other methods, and other statuses, pass through.
+func NewFallback(cfg FallbackConfig) Middleware {
+	if cfg.Status == 0 {
+		cfg.Status = http.StatusOK
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fw := &fallbackWriter{ResponseWriter: w, header: w.Header().Clone()}
+			next.ServeHTTP(fw, r)
+			if !fw.unavailable {
+				return
+			}
+			h := w.Header()
+			h.Set("Content-Type", cfg.ContentType)
+			h.Set("Content-Length", strconv.Itoa(len(cfg.Body)))
+			h.Set("Cache-Control", "no-store")
+			h.Set(FallbackHeader, "true")
+			w.WriteHeader(cfg.Status)
+			if r.Method != http.MethodHead {
+				w.Write(cfg.Body)
+			}
+		})
+	}
+}
+
+// fallbackWriter gives the handler a header map of its own, holding the
+// response back until its status is known. A 502, 503 or 504 is swallowed,
+// along with its headers and body; anything else is passed on intact.
+type fallbackWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	unavailable bool
+}
+
+func (w *fallbackWriter) Header() http.Header {
+	if w.wroteHeader {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *fallbackWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		if !w.unavailable {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		w.wroteHeader, w.unavailable = true, true
+		return
+	}
+	w.commitHeader()
+	// 1xx responses are informational; the final status is still to come.
+	w.wroteHeader = code >= 200
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// commitHeader replaces the real header map's contents with the handler's.
+func (w *fallbackWriter) commitHeader() {
+	h := w.ResponseWriter.Header()
+	for name := range h {
+		delete(h, name)
+	}
+	for name, values := range w.header {
+		h[name] = values
+	}
+}
+
+func (w *fallbackWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.unavailable {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *fallbackWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.unavailable {
+		f.Flush()
+	}
+}
+
+func (w *fallbackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.commitHeader()
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *fallbackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
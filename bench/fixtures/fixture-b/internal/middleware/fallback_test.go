@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/apierr"
+)
+
+func TestFallback(t *testing.T) {
+	const fallback = `{"items":[]}`
+	tests := []struct {
+		name       string
+		method     string
+		status     int
+		wantStatus int
+		wantBody   string
+	}{
+		{"upstream unavailable", http.MethodGet, http.StatusServiceUnavailable, http.StatusOK, fallback},
+		{"bad gateway", http.MethodGet, http.StatusBadGateway, http.StatusOK, fallback},
+		{"gateway timeout", http.MethodGet, http.StatusGatewayTimeout, http.StatusOK, fallback},
+		{"head", http.MethodHead, http.StatusServiceUnavailable, http.StatusOK, ""},
+		{"success passes through", http.MethodGet, http.StatusOK, http.StatusOK, "upstream"},
+		{"other error passes through", http.MethodGet, http.StatusInternalServerError, http.StatusInternalServerError, "upstream"},
+		{"post not covered", http.MethodPost, http.StatusServiceUnavailable, http.StatusServiceUnavailable, "upstream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewFallback(FallbackConfig{Body: []byte(fallback)})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, "upstream")
+			}))
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Vary", "Origin")
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/", nil))
+
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Fatalf("got %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+			fromFallback := rec.Header().Get(FallbackHeader) == "true"
+			if fromFallback != (tt.wantBody != "upstream") {
+				t.Fatalf("%s = %q", FallbackHeader, rec.Header().Get(FallbackHeader))
+			}
+			if fromFallback {
+				if rec.Header().Get("Retry-After") != "" || rec.Header().Get("Content-Type") != "application/json" ||
+					rec.Header().Get("Cache-Control") != "no-store" {
+					t.Fatalf("fallback headers = %v", rec.Header())
+				}
+			} else if rec.Header().Get("Retry-After") != "30" {
+				t.Fatalf("passed-through headers = %v", rec.Header())
+			}
+			if rec.Header().Get("Vary") != "Origin" {
+				t.Fatal("headers set in front of the fallback were lost")
+			}
+		})
+	}
+}
+
+func TestFallbackBehindBreaker(t *testing.T) {
+	cb := NewCircuitBreaker("fallback-test", BreakerConfig{FailureThreshold: 1})
+	calls := 0
+	h := NewFallback(FallbackConfig{Status: http.StatusAccepted, ContentType: "text/plain", Body: []byte("stale")})(
+		cb.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			apierr.Write(w, r, http.StatusBadGateway, apierr.CodeBadGateway, "bad gateway")
+		})))
+	for range 2 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "stale" || rec.Header().Get("Content-Type") != "text/plain" {
+			t.Fatalf("got %d %q %v", rec.Code, rec.Body, rec.Header())
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("upstream called %d times, want the open circuit to stop the second", calls)
+	}
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultGzipMinSize = 1024
+
+// GzipConfig tunes NewGzip.
+type GzipConfig struct {
+	// MinSize is the smallest response, in bytes, worth compressing.
+	// Defaults to 1KB; smaller bodies aren't worth the gzip framing.
+	MinSize int
+	// Types, if set, limits compression to these media types, such as
+	// "application/json"; an entry "text/*" covers every text type.
+	// Otherwise every type but those already compressed is. Server-Sent
+	// Events are never compressed either way.
+	Types []string
+}
+
+// Gzip compresses responses of 1KB or more for clients that accept gzip.
+var Gzip = NewGzip(GzipConfig{})
+
+var gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// NewGzip returns middleware that gzips responses when the client's
+// Accept-Encoding allows it. Responses below cfg.MinSize, responses that
+// already carry a Content-Encoding, and content types outside cfg.Types or
+// already compressed (images, video, archives, ...) pass through unchanged.
+// Content-Length is dropped from compressed responses since the compressed
+// size isn't known up front.
+//
+// The start of a body is held back until MinSize is reached or the handler
+// flushes, unless its Content-Type already rules compression out; a
+// text/event-stream response is therefore written through as it comes.
+// After a flush, compressed responses stream too, each Flush sending what
+// has been compressed so far.
+func NewGzip(cfg GzipConfig) Middleware {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultGzipMinSize
+	}
+	types := make([]string, len(cfg.Types))
+	for i, t := range cfg.Types {
+		types[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+	compressible := func(contentType string) bool { return compressibleType(types, contentType) }
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, minSize: cfg.MinSize, compressible: compressible, status: http.StatusOK}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip, i.e.
+// lists gzip or * without q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// incompressible lists content type prefixes that are already compressed.
+var incompressible = []string{
+	"image/", "video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-7z-compressed", "application/zstd",
+	"font/woff",
+}
+
+// compressibleType reports whether contentType is worth compressing, given
+// the GzipConfig's Types, lower-cased.
+func compressibleType(types []string, contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	// Events must reach the client one by one, and a gzip stream would
+	// have to be flushed after each to get there.
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	for _, prefix := range incompressible {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+		if typ, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mediaType, typ+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter buffers the start of the body until it can tell whether the
+// response is worth compressing, then either streams through a gzip.Writer
+// or flushes the buffer uncompressed.
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize      int
+	compressible func(contentType string) bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if code < 200 {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !g.wroteHeader {
+		g.status = code
+		g.wroteHeader = true
+		g.decideEarly()
+	}
+}
+
+// decideEarly commits to plain output straight away when the Content-Type
+// the handler set rules compression out, so nothing is held back.
+func (g *gzipWriter) decideEarly() {
+	if ct := g.Header().Get("Content-Type"); !g.decided && ct != "" && !g.compressible(ct) {
+		g.decide(false)
+	}
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.wroteHeader = true
+		g.decideEarly()
+	}
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) < g.minSize {
+			return len(b), nil
+		}
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// decide picks compressed or plain output, sends the header, and writes out
+// anything buffered so far. bigEnough reports whether the size threshold was
+// met (or is moot because the handler is flushing).
+func (g *gzipWriter) decide(bigEnough bool) error {
+	g.decided = true
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	compress := bigEnough &&
+		h.Get("Content-Encoding") == "" &&
+		g.compressible(h.Get("Content-Type")) &&
+		g.status != http.StatusNoContent && g.status != http.StatusNotModified
+
+	if compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzipPool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (g *gzipWriter) Flush() {
+	if !g.decided {
+		g.decide(true)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: underlying ResponseWriter does not support hijacking")
+	}
+	g.decided = true
+	return h.Hijack()
+}
+
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipWriter) close() {
+	if !g.decided {
+		if !g.wroteHeader {
+			// Nothing was written; let net/http send its implicit 200.
+			return
+		}
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		gzipPool.Put(g.gz)
+		g.gz = nil
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.8": true,
+		"GZIP":                true,
+		"gzip;q=0":            false,
+		"br, *":               true,
+		"identity":            false,
+		"*;q=0":               false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func serveGzip(t *testing.T, h http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/services", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	Gzip(h).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGzipCompressesLargeResponses(t *testing.T) {
+	body := strings.Repeat(`{"name":"service"},`, 200)
+	rec := serveGzip(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "9999")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, body)
+	}, "gzip")
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Fatal("Content-Length was not removed")
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != body {
+		t.Fatal("decompressed body does not match")
+	}
+}
+
+func TestGzipSkips(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		name    string
+		accept  string
+		handler http.HandlerFunc
+	}{
+		{"client does not accept", "", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, large) }},
+		{"tiny response", "gzip", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") }},
+		{"already compressed type", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}},
+		{"already encoded", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, large)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveGzip(t, tt.handler, tt.accept)
+			if rec.Header().Get("Content-Encoding") == "gzip" {
+				t.Fatal("response was compressed")
+			}
+			if rec.Body.Len() == 0 {
+				t.Fatal("body was dropped")
+			}
+		})
+	}
+}
+
+func TestGzipFlush(t *testing.T) {
+	rec := serveGzip(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first chunk")
+		w.(http.Flusher).Flush()
+		if !w.(*gzipWriter).decided {
+			t.Error("Flush did not commit the response")
+		}
+		io.WriteString(w, " second chunk")
+	}, "gzip")
+
+	if !rec.Flushed {
+		t.Fatal("Flush was not passed through")
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != "first chunk second chunk" {
+		t.Fatalf("body = %q", got)
+	}
+}
+
+func TestGzipTypes(t *testing.T) {
+	gz := NewGzip(GzipConfig{Types: []string{"application/json", "Text/*"}})
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"text/html", true},
+		{"text/csv", true},
+		{"application/xml", false},
+		{"application/javascript", false},
+		{"text/event-stream", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		gz(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			io.WriteString(w, large)
+		})).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding") == "gzip"; got != tt.want {
+			t.Errorf("%s: compressed = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestGzipPassesEventStreamThrough(t *testing.T) {
+	next := make(chan struct{})
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 2 {
+			io.WriteString(w, "data: event "+strconv.Itoa(i+1)+"\n\n")
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	})))
+	defer srv.Close()
+	defer close(next)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	// Set by hand, so the transport leaves any compression in place.
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ce := res.Header.Get("Content-Encoding"); ce != "" {
+		t.Fatalf("Content-Encoding = %q, want none", ce)
+	}
+	// The first event must arrive while the handler is still waiting to
+	// send the second, far short of the 1KB threshold.
+	br := bufio.NewReader(res.Body)
+	line, err := br.ReadString('\n')
+	if err != nil || line != "data: event 1\n" {
+		t.Fatalf("first event = %q, %v", line, err)
+	}
+	next <- struct{}{}
+	br.ReadString('\n')
+	if line, _ := br.ReadString('\n'); line != "data: event 2\n" {
+		t.Fatalf("second event = %q", line)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/apierr"
+)
+
+// MaxHeaderBytes rejects requests whose request line and headers exceed n
+// bytes with 431 and a JSON error. It is meant to sit behind an
+// http.Server with the same MaxHeaderBytes: the server stops reading a
+// connection whose headers run well past the limit, answering with a bare
+// 431 of its own, but lets through requests that exceed it by less than
+// its read buffer. This middleware refuses those too, so every request
+// over n gets the same answer. Sizes are counted as the headers would be
+// sent over HTTP/1.1.
+func MaxHeaderBytes(n int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if headerBytes(r) > n {
+				// Don't read on into a body nobody will handle.
+				w.Header().Set("Connection", "close")
+				apierr.Write(w, r, http.StatusRequestHeaderFieldsTooLarge, apierr.CodeHeaderTooLarge, "request headers too large")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerBytes is the size of r's request line and headers as HTTP/1.1
+// puts them on the wire, each line ending in CRLF.
+func headerBytes(r *http.Request) int {
+	n := len(r.Method) + 1 + len(r.RequestURI) + 1 + len(r.Proto) + 2
+	n += len("Host: ") + len(r.Host) + 2
+	for name, values := range r.Header {
+		for _, v := range values {
+			n += len(name) + 2 + len(v) + 2
+		}
+	}
+	return n + 2
+}
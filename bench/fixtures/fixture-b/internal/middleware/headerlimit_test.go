@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/apierr"
+)
+
+func TestMaxHeaderBytes(t *testing.T) {
+	const limit = 1024
+	srv := httptest.NewUnstartedServer(MaxHeaderBytes(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv.Config.MaxHeaderBytes = limit
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		size     int
+		want     int
+		wantJSON bool
+	}{
+		{"under the limit", limit / 2, http.StatusOK, false},
+		{"just over the limit", limit + 100, http.StatusRequestHeaderFieldsTooLarge, true},
+		{"far over the limit", 16 * limit, http.StatusRequestHeaderFieldsTooLarge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			req.Header.Set("X-Padding", strings.Repeat("a", tt.size))
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+			if tt.wantJSON {
+				var body apierr.Body
+				if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Error.Code != apierr.CodeHeaderTooLarge {
+					t.Fatalf("body = %+v, %v", body, err)
+				}
+			}
+		})
+	}
+}
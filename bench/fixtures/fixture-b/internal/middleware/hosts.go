@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/apierr"
+)
+
+// AllowedHosts rejects with 400 any request whose Host header doesn't name
+// one of hosts, defending handlers and upstreams that build URLs from Host
+// against spoofed values. An entry "*.example.com" matches any subdomain of
+// example.com at any depth, but not example.com itself. Ports are ignored,
+// and Unicode hostnames are compared in their punycode form, so
+// "bücher.example" and "xn--bcher-kva.example" are interchangeable on
+// either side. An empty list allows every host.
+//
+// Place it early in the chain, before anything that reads r.Host. Malformed
+// entries never match; check them with ValidateHosts.
+func AllowedHosts(hosts []string) Middleware {
+	if len(hosts) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	exact := make(map[string]bool)
+	var suffixes []string
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if rest, ok := strings.CutPrefix(h, "*."); ok {
+			if name, err := normalizeHost(rest); err == nil {
+				suffixes = append(suffixes, "."+name)
+			}
+			continue
+		}
+		if name, err := normalizeHost(h); err == nil {
+			exact[name] = true
+		}
+	}
+	allowed := func(host string) bool {
+		name, err := normalizeHost(host)
+		if err != nil {
+			return false
+		}
+		if exact[name] {
+			return true
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed(r.Host) {
+				apierr.Write(w, r, http.StatusBadRequest, apierr.CodeInvalidHost, "host not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidateHosts reports the first entry AllowedHosts would never match:
+// one with a port, a scheme, or a wildcard anywhere but a leading "*.".
+func ValidateHosts(hosts []string) error {
+	for _, h := range hosts {
+		name, wildcard := strings.CutPrefix(strings.TrimSpace(h), "*.")
+		if !wildcard && net.ParseIP(strings.Trim(name, "[]")) != nil {
+			continue
+		}
+		if strings.ContainsAny(name, "*:/[]") {
+			return fmt.Errorf("invalid host %q: want a hostname, IP address, or *.domain", h)
+		}
+		if _, err := normalizeHost(name); err != nil {
+			return fmt.Errorf("invalid host %q: %w", h, err)
+		}
+	}
+	return nil
+}
+
+// normalizeHost reduces a Host header or allow-list entry to a comparable
+// form: the port and any trailing dot dropped, lower case, and each label
+// in punycode.
+func normalizeHost(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ".")
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("empty label")
+		}
+		labels[i] = toASCIILabel(label)
+	}
+	return strings.Join(labels, "."), nil
+}
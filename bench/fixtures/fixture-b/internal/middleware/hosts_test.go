@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedHosts(t *testing.T) {
+	h := AllowedHosts([]string{"api.example.com", "*.internal.example", "bücher.example", "[::1]"})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"api.example.com", http.StatusOK},
+		{"API.Example.COM", http.StatusOK},
+		{"api.example.com:8443", http.StatusOK},
+		{"api.example.com.", http.StatusOK},
+		{"svc.internal.example", http.StatusOK},
+		{"a.b.internal.example:80", http.StatusOK},
+		{"internal.example", http.StatusBadRequest},
+		{"evilinternal.example", http.StatusBadRequest},
+		{"xn--bcher-kva.example", http.StatusOK},
+		{"bücher.example:443", http.StatusOK},
+		{"bucher.example", http.StatusBadRequest},
+		{"[::1]:8080", http.StatusOK},
+		{"example.com", http.StatusBadRequest},
+		{"api.example.com.evil.com", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedHostsEmptyAllowsAll(t *testing.T) {
+	h := AllowedHosts(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "anything.test"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestToASCIILabel(t *testing.T) {
+	for in, want := range map[string]string{
+		"example": "example",
+		"bücher":  "xn--bcher-kva",
+		"münchen": "xn--mnchen-3ya",
+		"例え":      "xn--r8jz45g",
+		"中国":      "xn--fiqs8s",
+	} {
+		if got := toASCIILabel(in); got != want {
+			t.Errorf("toASCIILabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	if err := ValidateHosts([]string{"example.com", "*.example.com", "10.0.0.1", "::1", "[::1]", "bücher.example"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"example.com:8080", "https://example.com", "*example.com", "a.*.example.com", "a..b"} {
+		if err := ValidateHosts([]string{bad}); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"api-gateway/internal/apierr"
+)
+
+// IdempotencyKeyHeader names the header clients send to make a POST or
+// PATCH safe to retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyConfig tunes NewIdempotency.
+type IdempotencyConfig struct {
+	// TTL is how long a response is replayed for its key. Defaults to 24h.
+	TTL time.Duration
+	// MaxBodyBytes is the largest request or response body handled; bigger
+	// requests pass through without idempotency, and bigger responses
+	// aren't kept. Defaults to 1MB.
+	MaxBodyBytes int
+	// MaxKeys bounds the keys held at once; past it, requests with a new
+	// key pass through without idempotency. Defaults to 10000.
+	MaxKeys int
+	// MaxBytes bounds the combined size of the responses held, so clients
+	// can't fill memory with a new key per request. Past it, requests with
+	// a new key pass through without idempotency, and a response that
+	// doesn't fit isn't kept. Defaults to 64MB.
+	MaxBytes int64
+	// Key identifies the client a key belongs to. Defaults to ClientKey.
+	Key KeyFunc
+}
+
+// Idempotency is NewIdempotency with the default settings.
+var Idempotency = NewIdempotency(IdempotencyConfig{})
+
+// NewIdempotency returns middleware making POST and PATCH requests that
+// carry an Idempotency-Key header safe to retry. The first response for a
+// key is kept for cfg.TTL and replayed, with Idempotent-Replayed: true, to
+// later requests with the same key instead of reaching the upstream again.
+// Keys are scoped to the client, method and path, so clients can't collide
+// with or read each other's responses. Place it after authentication so
+// clients are told apart by their sub claim.
+//
+// A request arriving while the first is still in flight waits for it and
+// then gets the replay. A request reusing a key with a different body gets
+// 422. 5xx and 429 responses aren't kept, so a retry after a transient
+// failure runs again.
+func NewIdempotency(cfg IdempotencyConfig) Middleware {
+	return newIdempotency(cfg).middleware
+}
+
+type idempotency struct {
+	cfg IdempotencyConfig
+	now func() time.Time
+
+	mu    sync.Mutex
+	keys  map[string]*idempotent
+	bytes int64 // sum of the kept responses' sizes
+}
+
+// idempotent is the state of one key. done is closed once the first
+// request finishes; resp is nil until then, and stays nil if the response
+// wasn't kept, in which case the key is removed.
+type idempotent struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	resp        *CachedResponse
+	expires     time.Time
+	size        int64 // counted against MaxBytes once resp is kept
+}
+
+func newIdempotency(cfg IdempotencyConfig) *idempotency {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 10000
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.Key == nil {
+		cfg.Key = ClientKey
+	}
+	return &idempotency{cfg: cfg, now: time.Now, keys: map[string]*idempotent{}}
+}
+
+func (m *idempotency) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get(IdempotencyKeyHeader)
+		if idemKey == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(m.cfg.MaxBodyBytes)+1))
+		if len(body) > m.cfg.MaxBodyBytes {
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			// MaxBodyBytes' limit, say; let the handler report it.
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), errReader{err}), r.Body}
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := m.cfg.Key(r) + " " + r.Method + " " + r.URL.Path + " " + idemKey
+		h := sha256.New()
+		io.WriteString(h, r.URL.RawQuery+"\n")
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		for {
+			entry, first := m.claim(key, fingerprint)
+			switch {
+			case entry == nil:
+				next.ServeHTTP(w, r)
+				return
+			case entry.fingerprint != fingerprint:
+				apierr.Write(w, r, http.StatusUnprocessableEntity, apierr.CodeIdempotencyKeyReused,
+					"Idempotency-Key was already used with a different request")
+				return
+			case first:
+				m.serveFirst(w, r, next, key, entry)
+				return
+			}
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				return
+			}
+			if entry.resp != nil {
+				serveReplay(w, entry.resp)
+				return
+			}
+			// The first request's response wasn't kept; try to go first.
+		}
+	})
+}
+
+// claim returns the live entry for key, creating it if there is none, in
+// which case first is true and the caller must complete it. It returns nil
+// when the table is full of live keys or their responses.
+func (m *idempotency) claim(key string, fingerprint [sha256.Size]byte) (entry *idempotent, first bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if e, ok := m.keys[key]; ok {
+		if e.resp == nil || now.Before(e.expires) {
+			return e, false
+		}
+		m.remove(key, e)
+	}
+	if m.full() {
+		m.purge(now)
+		if m.full() {
+			return nil, false
+		}
+	}
+	e := &idempotent{fingerprint: fingerprint, done: make(chan struct{})}
+	m.keys[key] = e
+	return e, true
+}
+
+// keep stores resp as entry's response, reporting false if it doesn't fit
+// in MaxBytes even once expired entries are gone. m.mu must be held.
+func (m *idempotency) keep(key string, entry *idempotent, resp *CachedResponse) bool {
+	size := resp.size() + int64(len(key))
+	if m.bytes+size > m.cfg.MaxBytes {
+		m.purge(m.now())
+		if m.bytes+size > m.cfg.MaxBytes {
+			return false
+		}
+	}
+	entry.resp = resp
+	entry.expires = m.now().Add(m.cfg.TTL)
+	entry.size = size
+	m.bytes += size
+	return true
+}
+
+func (m *idempotency) full() bool {
+	return len(m.keys) >= m.cfg.MaxKeys || m.bytes >= m.cfg.MaxBytes
+}
+
+// purge drops the expired entries. m.mu must be held.
+func (m *idempotency) purge(now time.Time) {
+	for k, e := range m.keys {
+		if e.resp != nil && !now.Before(e.expires) {
+			m.remove(k, e)
+		}
+	}
+}
+
+func (m *idempotency) remove(key string, e *idempotent) {
+	delete(m.keys, key)
+	m.bytes -= e.size
+}
+
+func (m *idempotency) serveFirst(w http.ResponseWriter, r *http.Request, next http.Handler, key string, entry *idempotent) {
+	var resp *CachedResponse
+	defer func() {
+		m.mu.Lock()
+		if resp == nil || !m.keep(key, entry, resp) {
+			m.remove(key, entry)
+		}
+		m.mu.Unlock()
+		close(entry.done)
+	}()
+
+	before := w.Header().Clone()
+	cw := &cacheWriter{statusWriter: newStatusWriter(w), limit: m.cfg.MaxBodyBytes}
+	next.ServeHTTP(cw, r)
+	if cw.overflow || cw.status >= 500 || cw.status == http.StatusTooManyRequests || r.Context().Err() != nil {
+		return
+	}
+	resp = &CachedResponse{
+		Status: cw.status,
+		Header: addedHeaders(before, w.Header()),
+		Body:   cw.body.Bytes(),
+	}
+}
+
+func serveReplay(w http.ResponseWriter, resp *CachedResponse) {
+	h := w.Header()
+	for k, vs := range resp.Header {
+		h[k] = slices.Clone(vs)
+	}
+	h.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader replays a read error after the bytes read before it.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
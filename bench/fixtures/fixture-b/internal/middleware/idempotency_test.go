@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyReplays(t *testing.T) {
+	var calls atomic.Int32
+	status := http.StatusCreated
+	m := newIdempotency(IdempotencyConfig{TTL: time.Hour})
+	now := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return now }
+	h := m.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", fmt.Sprintf("/orders/%d", n))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "order %d: %s", n, body)
+	}))
+	send := func(method, remote, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		remote     string
+		key        string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantReplay bool
+	}{
+		{"first", "POST", "10.0.0.1:1", "k1", `{"qty":1}`, http.StatusCreated, `order 1: {"qty":1}`, false},
+		{"retry replays", "POST", "10.0.0.1:1", "k1", `{"qty":1}`, http.StatusCreated, `order 1: {"qty":1}`, true},
+		{"different body", "POST", "10.0.0.1:1", "k1", `{"qty":2}`, http.StatusUnprocessableEntity, "", false},
+		{"other client", "POST", "10.0.0.2:1", "k1", `{"qty":1}`, http.StatusCreated, `order 2: {"qty":1}`, false},
+		{"new key", "POST", "10.0.0.1:1", "k2", `{"qty":1}`, http.StatusCreated, `order 3: {"qty":1}`, false},
+		{"no key", "POST", "10.0.0.1:1", "", `{"qty":1}`, http.StatusCreated, `order 4: {"qty":1}`, false},
+		{"GET ignores key", "GET", "10.0.0.1:1", "k1", "", http.StatusCreated, `order 5: `, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := send(tt.method, tt.remote, tt.key, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Fatalf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplay {
+				t.Fatalf("replayed = %v, want %v", replayed, tt.wantReplay)
+			}
+			if tt.wantReplay && rec.Header().Get("Location") != "/orders/1" {
+				t.Fatalf("Location = %q, want the original's", rec.Header().Get("Location"))
+			}
+		})
+	}
+
+	now = now.Add(time.Hour)
+	if rec := send("POST", "10.0.0.1:1", "k1", `{"qty":2}`); rec.Code != http.StatusCreated {
+		t.Fatalf("after TTL: status = %d, want the key free again", rec.Code)
+	}
+
+	status = http.StatusBadGateway
+	send("POST", "10.0.0.1:1", "k3", "x")
+	status = http.StatusCreated
+	if rec := send("POST", "10.0.0.1:1", "k3", "x"); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after 502 = %d replayed=%q, want it to run again", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyBoundsStoredBytes(t *testing.T) {
+	m := newIdempotency(IdempotencyConfig{TTL: time.Hour, MaxBytes: 2500})
+	now := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return now }
+	var calls atomic.Int32
+	h := m.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Two responses fit; the third doesn't and isn't kept.
+	for _, key := range []string{"k1", "k2", "k3"} {
+		send(key)
+	}
+	if rec := send("k2"); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("response within the byte limit wasn't kept")
+	}
+	before := calls.Load()
+	if rec := send("k3"); rec.Header().Get("Idempotent-Replayed") != "" || calls.Load() != before+1 {
+		t.Fatal("response over the byte limit was kept")
+	}
+	if m.bytes > 2500 {
+		t.Fatalf("store holds %d bytes, want at most 2500", m.bytes)
+	}
+
+	// Once entries expire their bytes are reclaimed for new keys.
+	now = now.Add(time.Hour)
+	send("k4")
+	if rec := send("k4"); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("new key not kept after old entries expired")
+	}
+	if len(m.keys) != 1 {
+		t.Fatalf("store holds %d keys, want the expired ones purged", len(m.keys))
+	}
+}
+
+func TestIdempotencyConcurrentRequestsWait(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+
+	const n = 5
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+			req.Header.Set(IdempotencyKeyHeader, "same")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			codes[i], bodies[i] = rec.Code, rec.Body.String()
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls.Load())
+	}
+	for i := range n {
+		if codes[i] != http.StatusCreated || bodies[i] != "created" {
+			t.Fatalf("request %d got %d %q", i, codes[i], bodies[i])
+		}
+	}
+}
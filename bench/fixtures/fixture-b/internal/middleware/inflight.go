@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/metrics"
+)
+
+var (
+	inFlightGauge = metrics.Default.NewGauge("gateway_in_flight_requests",
+		"Requests currently in flight, by MaxInFlight limit.", "limit")
+	inFlightRejected = metrics.Default.NewCounter("gateway_in_flight_rejected_total",
+		"Requests refused because a MaxInFlight limit was full, by limit.", "limit")
+)
+
+// MaxInFlight is NewMaxInFlight reporting under the name "global".
+func MaxInFlight(n int) Middleware {
+	return NewMaxInFlight("global", n)
+}
+
+// NewMaxInFlight returns middleware admitting at most n requests at once.
+// Requests beyond the limit aren't queued: they get 503 with Retry-After
+// straight away, so a spike sheds load instead of piling up goroutines and
+// buffers. A slot is released when its request's handler returns, panics
+// included. The in-flight count is reported as gateway_in_flight_requests
+// labelled with name; limits sharing a name, such as one rebuilt by a
+// reload, add up in the same series. n <= 0 means no limit.
+func NewMaxInFlight(name string, n int) Middleware {
+	if n <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	slots := make(chan struct{}, n)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				inFlightRejected.Inc(name)
+				w.Header().Set("Retry-After", "1")
+				apierr.Write(w, r, http.StatusServiceUnavailable, apierr.CodeOverloaded, "too many requests in flight")
+				return
+			}
+			inFlightGauge.Add(1, name)
+			defer func() {
+				inFlightGauge.Add(-1, name)
+				<-slots
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/internal/metrics"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	h := NewMaxInFlight("test", 2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		started.Done()
+		<-release
+	}))
+
+	var done sync.WaitGroup
+	for range 2 {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	started.Wait()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("over the limit: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var out bytes.Buffer
+	metrics.Default.WriteText(&out)
+	if !strings.Contains(out.String(), `gateway_in_flight_requests{limit="test"} 2`) {
+		t.Fatalf("metrics don't report 2 in flight:\n%s", out.String())
+	}
+
+	close(release)
+	done.Wait()
+
+	// A panicking request gives its slot back.
+	recovered := Recover(h)
+	for range 3 {
+		recovered.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	}
+	started.Add(1)
+	rec = httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("request hung")
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("after panics: status = %d, want slots released", rec.Code)
+	}
+}
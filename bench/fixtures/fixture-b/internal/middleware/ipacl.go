@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"api-gateway/internal/apierr"
+)
+
+// IPAllowList admits only requests whose client address falls in one of
+// cidrs, rejecting the rest with 403, whatever credentials they carry.
+// Entries are IPv4 or IPv6 CIDR prefixes, or bare addresses. An empty list
+// allows every client.
+//
+// The client address is RemoteIP's, so place it after RealIP: behind a
+// trusted load balancer it is then the forwarded client rather than the
+// balancer. IPv4-mapped IPv6 addresses match their IPv4 prefixes. Requests
+// without an IP address, such as those over a Unix socket, never match.
+// Malformed entries never match either; check them with ValidateIPList.
+func IPAllowList(cidrs []string) Middleware {
+	return ipList(cidrs, true)
+}
+
+// IPDenyList rejects with 403 requests whose client address falls in one of
+// cidrs, as IPAllowList admits them. An empty list denies no one.
+func IPDenyList(cidrs []string) Middleware {
+	return ipList(cidrs, false)
+}
+
+func ipList(cidrs []string, allow bool) Middleware {
+	if len(cidrs) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	var prefixes []netip.Prefix
+	for _, c := range cidrs {
+		if p, err := parsePrefix(strings.TrimSpace(c)); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	listed := func(r *http.Request) bool {
+		ip, err := netip.ParseAddr(RemoteIP(r))
+		if err != nil {
+			return false
+		}
+		ip = ip.Unmap().WithZone("")
+		for _, p := range prefixes {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if listed(r) != allow {
+				apierr.Write(w, r, http.StatusForbidden, apierr.CodeIPNotAllowed, "client address not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidateIPList reports the first entry IPAllowList or IPDenyList would
+// never match.
+func ValidateIPList(cidrs []string) error {
+	for _, c := range cidrs {
+		if _, err := parsePrefix(strings.TrimSpace(c)); err != nil {
+			return fmt.Errorf("invalid address or CIDR %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPLists(t *testing.T) {
+	trusted, err := ParsePrefixes("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpc := []string{"172.16.0.0/12", "2001:db8:1::/48", "198.51.100.7"}
+	tests := []struct {
+		name       string
+		list       func([]string) Middleware
+		cidrs      []string
+		remoteAddr string
+		xff        string
+		want       int
+	}{
+		{"allowed IPv4", IPAllowList, vpc, "172.20.1.2:5000", "", http.StatusOK},
+		{"allowed bare address", IPAllowList, vpc, "198.51.100.7:5000", "", http.StatusOK},
+		{"allowed IPv6", IPAllowList, vpc, "[2001:db8:1:2::9]:5000", "", http.StatusOK},
+		{"allowed IPv4-mapped", IPAllowList, vpc, "[::ffff:172.16.0.1]:5000", "", http.StatusOK},
+		{"outside allow list", IPAllowList, vpc, "203.0.113.1:5000", "", http.StatusForbidden},
+		{"IPv6 outside allow list", IPAllowList, vpc, "[2001:db8:2::1]:5000", "", http.StatusForbidden},
+		{"no IP address", IPAllowList, vpc, "@", "", http.StatusForbidden},
+		{"empty allow list", IPAllowList, nil, "203.0.113.1:5000", "", http.StatusOK},
+		{"forwarded client allowed", IPAllowList, vpc, "10.0.0.5:443", "172.16.5.5", http.StatusOK},
+		{"forwarded client outside", IPAllowList, vpc, "10.0.0.5:443", "203.0.113.1", http.StatusForbidden},
+		// The balancer's own address isn't in the list, only its clients'.
+		{"balancer itself not allowed", IPAllowList, vpc, "10.0.0.5:443", "", http.StatusForbidden},
+		{"untrusted peer can't forward its way in", IPAllowList, vpc, "203.0.113.1:5000", "172.16.5.5", http.StatusForbidden},
+		{"denied", IPDenyList, vpc, "172.16.0.1:5000", "", http.StatusForbidden},
+		{"denied IPv6", IPDenyList, vpc, "[2001:db8:1::1]:5000", "", http.StatusForbidden},
+		{"not denied", IPDenyList, vpc, "203.0.113.1:5000", "", http.StatusOK},
+		{"forwarded client denied", IPDenyList, vpc, "10.0.0.5:443", "198.51.100.7", http.StatusForbidden},
+		{"empty deny list", IPDenyList, nil, "172.16.0.1:5000", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RealIP(trusted...)(tt.list(tt.cidrs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIPList(t *testing.T) {
+	if err := ValidateIPList([]string{"10.0.0.0/8", "::1", "2001:db8::/32"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "vpc", ""} {
+		if err := ValidateIPList([]string{bad}); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
@@ -1,15 +1,136 @@
 package middleware
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"sync"
 	"time"
+
+	"api-gateway/internal/logging"
+	"api-gateway/internal/tracing"
 )
 
-func Logger(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
+// LogFormat selects how NewLogger renders access log entries.
+type LogFormat int
+
+const (
+	// JSONFormat writes one JSON object per request.
+	JSONFormat LogFormat = iota
+	// TextFormat writes one space-separated line per request.
+	TextFormat
+)
+
+// Logger writes JSON access logs to stderr.
+var Logger = NewLogger(JSONFormat, os.Stderr)
+
+type accessEntry struct {
+	Timestamp  string  `json:"timestamp"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RemoteAddr string  `json:"remote_addr"`
+	RequestID  string  `json:"request_id,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+}
+
+// SamplingPolicy thins the access log for NewSampledLogger. Responses
+// other than 2xx are always logged, so errors stay visible.
+type SamplingPolicy struct {
+	// SuccessRate is the fraction of 2xx responses logged, from 0 to 1.
+	SuccessRate float64
+	// SlowerThan, if set, logs every request taking at least this long,
+	// whatever its status.
+	SlowerThan time.Duration
+}
+
+// NewLogger returns access-log middleware writing entries to out in format.
+// Place it after RequestID and Tracing so entries carry the request ID and,
+// in JSON, the trace ID. Requests whose client disconnected first are
+// logged with StatusClientClosedRequest.
+//
+// Entries are subject to the log level: 5xx responses are logged at
+// error, 4xx ones at warn, and the rest at info.
+func NewLogger(format LogFormat, out io.Writer) Middleware {
+	return newLogger(format, out, nil)
+}
+
+// NewSampledLogger is NewLogger logging only the requests policy keeps.
+// At the debug level every request is logged.
+func NewSampledLogger(format LogFormat, out io.Writer, policy SamplingPolicy) Middleware {
+	return newLogger(format, out, func(status int, d time.Duration) bool {
+		if status < 200 || status >= 300 || (policy.SlowerThan > 0 && d >= policy.SlowerThan) {
+			return true
+		}
+		return rand.Float64() < policy.SuccessRate
 	})
 }
+
+// newLogger logs the requests keep reports true for, or all if it's nil.
+func newLogger(format LogFormat, out io.Writer, keep func(status int, d time.Duration) bool) Middleware {
+	var mu sync.Mutex
+	write := func(e accessEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		if format == TextFormat {
+			fmt.Fprintf(out, "%s %s %s %s %d %dB %.3fms %s\n",
+				e.Timestamp, e.RequestID, e.Method, e.Path, e.Status, e.Bytes, e.DurationMS, e.RemoteAddr)
+			return
+		}
+		json.NewEncoder(out).Encode(e)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := newStatusWriter(w)
+			observe(next, sw, r, func(gone bool) {
+				elapsed := time.Since(start)
+				status := sw.status
+				if gone {
+					status = StatusClientClosedRequest
+				}
+				if !logging.Enabled(accessLevel(status)) {
+					return
+				}
+				if keep != nil && !logging.Enabled(logging.LevelDebug) && !keep(status, elapsed) {
+					return
+				}
+				write(accessEntry{
+					Timestamp:  start.UTC().Format(time.RFC3339Nano),
+					Method:     r.Method,
+					Path:       r.URL.Path,
+					Status:     status,
+					Bytes:      sw.bytes,
+					DurationMS: float64(elapsed.Microseconds()) / 1000,
+					RemoteAddr: r.RemoteAddr,
+					RequestID:  RequestIDFromContext(r.Context()),
+					TraceID:    traceID(r),
+				})
+			})
+		})
+	}
+}
+
+// accessLevel is the log level of an access entry for status.
+func accessLevel(status int) logging.Level {
+	switch {
+	case status >= 500:
+		return logging.LevelError
+	case status >= 400:
+		return logging.LevelWarn
+	}
+	return logging.LevelInfo
+}
+
+func traceID(r *http.Request) string {
+	if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/logging"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &buf
+}
+
+func TestLoggerRecordsStatusAndSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, " 200 5B "},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }, " 418 0B "},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, " 200 0B "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			NewLogger(TextFormat, &logs)(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+			if !strings.Contains(logs.String(), "GET /x"+tt.want) {
+				t.Fatalf("log line %q does not contain %q", logs.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var logs bytes.Buffer
+	h := Chain(RequestID, NewLogger(JSONFormat, &logs))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v\n%s", err, logs.String())
+	}
+	for field, want := range map[string]any{
+		"method":      "POST",
+		"path":        "/api/v1/users",
+		"status":      float64(http.StatusCreated),
+		"remote_addr": req.RemoteAddr,
+		"request_id":  "req-1",
+	} {
+		if entry[field] != want {
+			t.Errorf("%s = %v, want %v", field, entry[field], want)
+		}
+	}
+	for _, field := range []string{"timestamp", "duration_ms"} {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("missing %s", field)
+		}
+	}
+}
+
+func TestLoggerClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"handler returns", func(w http.ResponseWriter, r *http.Request) {}},
+		{"handler aborts", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic(http.ErrAbortHandler)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			h := NewLogger(TextFormat, &logs)(tt.handler)
+			func() {
+				defer func() {
+					if err := recover(); err != nil && err != http.ErrAbortHandler {
+						t.Fatalf("panic %v", err)
+					}
+				}()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil).WithContext(ctx))
+			}()
+			if !strings.Contains(logs.String(), "GET /x 499 ") {
+				t.Fatalf("log line %q doesn't record the disconnect", logs.String())
+			}
+		})
+	}
+}
+
+func TestSampledLogger(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewSampledLogger(TextFormat, &logs, SamplingPolicy{SlowerThan: 50 * time.Millisecond})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow":
+				time.Sleep(60 * time.Millisecond)
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+			case "/broken":
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/ok", false},
+		{"/missing", true},
+		{"/broken", true},
+		{"/slow", true},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if logged := logs.Len() > 0; logged != tt.want {
+			t.Errorf("%s: logged = %v, want %v", tt.path, logged, tt.want)
+		}
+	}
+
+	logs.Reset()
+	h = NewSampledLogger(TextFormat, &logs, SamplingPolicy{SuccessRate: 0.1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 2000 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	}
+	if n := strings.Count(logs.String(), "\n"); n < 120 || n > 280 {
+		t.Fatalf("logged %d of 2000 successes at 10%%", n)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	t.Cleanup(func() { logging.SetLevel(logging.LevelInfo) })
+	var logs bytes.Buffer
+	status := func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}
+	logger := NewLogger(TextFormat, &logs)(http.HandlerFunc(status))
+	sampled := NewSampledLogger(TextFormat, &logs, SamplingPolicy{})(http.HandlerFunc(status))
+	tests := []struct {
+		level logging.Level
+		h     http.Handler
+		path  string
+		want  bool
+	}{
+		{logging.LevelInfo, logger, "/200", true},
+		{logging.LevelWarn, logger, "/200", false},
+		{logging.LevelWarn, logger, "/404", true},
+		{logging.LevelError, logger, "/404", false},
+		{logging.LevelError, logger, "/502", true},
+		{logging.LevelInfo, sampled, "/200", false},
+		{logging.LevelDebug, sampled, "/200", true},
+	}
+	for _, tt := range tests {
+		logging.SetLevel(tt.level)
+		logs.Reset()
+		tt.h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if logged := logs.Len() > 0; logged != tt.want {
+			t.Errorf("%s at %s: logged = %v, want %v", tt.path, tt.level, logged, tt.want)
+		}
+	}
+}
+
+func TestStatusWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewLogger(TextFormat, io.Discard)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped writer is not an http.Flusher")
+		}
+		w.Write([]byte("chunk"))
+		f.Flush()
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !rec.Flushed {
+		t.Fatal("Flush was not passed through")
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestStatusWriterHijack(t *testing.T) {
+	var logs bytes.Buffer
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	NewLogger(TextFormat, &logs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("wrapped writer is not an http.Hijacker")
+		}
+		hj.Hijack()
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	if !rec.hijacked {
+		t.Fatal("Hijack was not passed through")
+	}
+	if !strings.Contains(logs.String(), " 101 ") {
+		t.Fatalf("hijacked request not logged as 101: %q", logs.String())
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/metrics"
+)
+
+// Metrics records request counts and latencies in metrics.Default.
+var Metrics = NewMetrics(metrics.Default)
+
+// NewMetrics returns middleware recording, per method and route template,
+// a request counter labelled by status and a latency histogram. Routes are
+// reported with SetRoute by the router. Requests whose client disconnected
+// first are counted with status 499 and in
+// gateway_http_client_disconnects_total.
+func NewMetrics(reg *metrics.Registry) Middleware {
+	requests := reg.NewCounter("gateway_http_requests_total",
+		"HTTP requests handled, by method, route template and status code.",
+		"method", "route", "status")
+	latency := reg.NewHistogram("gateway_http_request_duration_seconds",
+		"HTTP request latency in seconds, by method and route template.",
+		metrics.DefBuckets, "method", "route")
+	disconnects := reg.NewCounter("gateway_http_client_disconnects_total",
+		"Requests whose client disconnected before the response was complete, by method and route template.",
+		"method", "route")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, route := withRouteHolder(r)
+			sw := newStatusWriter(w)
+			observe(next, sw, r, func(gone bool) {
+				label := route.routeLabel()
+				status := sw.status
+				if gone {
+					status = StatusClientClosedRequest
+					disconnects.Inc(r.Method, label)
+				}
+				requests.Inc(r.Method, label, strconv.Itoa(status))
+				latency.Observe(time.Since(start).Seconds(), r.Method, label)
+			})
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewMetrics(reg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/users") {
+			SetRoute(r.Context(), "/api/v1/users")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	for _, path := range []string{"/api/v1/users", "/api/v1/users/1", "/api/v1/users/2", "/nope/123"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var out strings.Builder
+	reg.WriteText(&out)
+	for _, want := range []string{
+		`gateway_http_requests_total{method="GET",route="/api/v1/users",status="200"} 3`,
+		`gateway_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`gateway_http_request_duration_seconds_count{method="GET",route="/api/v1/users"} 3`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "/api/v1/users/1") {
+		t.Error("raw path leaked into labels")
+	}
+}
+
+func TestMetricsClientDisconnect(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewMetrics(reg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/api/v1/users")
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/users", nil).WithContext(ctx))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	var out strings.Builder
+	reg.WriteText(&out)
+	for _, want := range []string{
+		`gateway_http_requests_total{method="GET",route="/api/v1/users",status="499"} 1`,
+		`gateway_http_requests_total{method="GET",route="/api/v1/users",status="200"} 1`,
+		`gateway_http_client_disconnects_total{method="GET",route="/api/v1/users"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, out.String())
+		}
+	}
+}
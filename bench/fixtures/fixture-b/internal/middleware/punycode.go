@@ -0,0 +1,93 @@
+package middleware
+
+import "strings"
+
+// Punycode parameters from RFC 3492, section 5.
+const (
+	pcBase        = 36
+	pcTMin        = 1
+	pcTMax        = 26
+	pcSkew        = 38
+	pcDamp        = 700
+	pcInitialBias = 72
+	pcInitialN    = 128
+)
+
+// toASCIILabel returns label in its ASCII-compatible "xn--" form if it has
+// any non-ASCII characters. It applies only the Punycode encoding, not the
+// full IDNA mapping, which is enough to compare hostnames a client sends in
+// Unicode with allow-list entries written either way.
+func toASCIILabel(label string) string {
+	runes := []rune(label)
+	var out strings.Builder
+	for _, r := range runes {
+		if r < 0x80 {
+			out.WriteRune(r)
+		}
+	}
+	basic := out.Len()
+	if basic == len(runes) {
+		return label
+	}
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := rune(pcInitialN), 0, pcInitialBias
+	for h := basic; h < len(runes); {
+		m := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := pcBase; ; k += pcBase {
+				t := min(max(k-bias, pcTMin), pcTMax)
+				if q < t {
+					break
+				}
+				out.WriteByte(punycodeDigit(t + (q-t)%(pcBase-t)))
+				q = (q - t) / (pcBase - t)
+			}
+			out.WriteByte(punycodeDigit(q))
+			bias = punycodeAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return "xn--" + out.String()
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= pcDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((pcBase-pcTMin)*pcTMax)/2 {
+		delta /= pcBase - pcTMin
+		k += pcBase
+	}
+	return k + (pcBase-pcTMin+1)*delta/(delta+pcSkew)
+}
@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/auth"
+	"api-gateway/internal/logging"
+)
+
+// KeyFunc identifies the client a request is accounted against.
+type KeyFunc func(r *http.Request) string
+
+// RemoteIP keys requests by the host part of r.RemoteAddr.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientKey keys authenticated requests by their sub claim and everything
+// else by remote IP. API keys' subjects carry auth.APIKeySubjectPrefix, so
+// a key and a user of the same name are separate clients.
+func ClientKey(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		if sub, _ := claims["sub"].(string); sub != "" {
+			return "sub:" + sub
+		}
+	}
+	return "ip:" + RemoteIP(r)
+}
+
+// RateStore records requests and decides whether a client may make
+// another. Allow reports whether the request identified by key is within
+// its limit and, if not, how long until it would be. A store shared between
+// replicas, such as redis.RateStore, makes the limit apply to the gateway
+// as a whole.
+type RateStore interface {
+	Allow(ctx context.Context, key string) (ok bool, retryAfter time.Duration, err error)
+}
+
+// Limit allows RPS requests per second with bursts of up to Burst.
+type Limit struct {
+	RPS   int `json:"rps"`
+	Burst int `json:"burst"`
+}
+
+// LimitFunc picks the limit a request's client is held to, reporting false
+// to leave it at the store's default.
+type LimitFunc func(r *http.Request) (Limit, bool)
+
+// ClaimLimit returns a LimitFunc for tiered access: an authenticated client
+// gets the limit its claim names, so with claim "tier" a token or API key
+// carrying {"tier": "pro"} is held to limits["pro"]. Unauthenticated
+// clients, and those whose claim names no limit, get the default. Place the
+// rate limit after the auth middleware for the claim to be seen.
+func ClaimLimit(claim string, limits map[string]Limit) LimitFunc {
+	return func(r *http.Request) (Limit, bool) {
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		if !ok {
+			return Limit{}, false
+		}
+		name, _ := claims[claim].(string)
+		l, ok := limits[name]
+		return l, ok
+	}
+}
+
+// Quota is a QuotaStore's verdict on one request.
+type Quota struct {
+	Allowed bool
+	// Limit is how many requests the client may make at once.
+	Limit int
+	// Remaining is how many more it may make right now.
+	Remaining int
+	// Reset is how long until all of Limit is available again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused client may try again.
+	RetryAfter time.Duration
+}
+
+// QuotaStore is a RateStore that can hold each client to a limit of its
+// own and report what is left of it, for RateLimitConfig.Limit and the
+// X-RateLimit headers. Both stores in this repository are QuotaStores.
+type QuotaStore interface {
+	RateStore
+	// Take counts a request from key against limit, or against the
+	// store's default limit if limit is the zero Limit.
+	Take(ctx context.Context, key string, limit Limit) (Quota, error)
+}
+
+// NewMemoryRateStore returns a token-bucket QuotaStore local to this
+// process: by default rps requests per second with bursts of up to burst.
+func NewMemoryRateStore(rps, burst int) QuotaStore {
+	return newLimiter(float64(rps), float64(burst))
+}
+
+// RateLimitConfig configures NewRateLimit.
+type RateLimitConfig struct {
+	Store RateStore
+	// Key identifies the client. Defaults to ClientKey.
+	Key KeyFunc
+	// Limit, if set, picks each client's limit, such as ClaimLimit's
+	// tiers; clients it leaves out get Store's default. It requires Store
+	// to be a QuotaStore.
+	Limit LimitFunc
+	// FailOpen lets requests through when Store returns an error; by
+	// default they are refused with 503.
+	FailOpen bool
+}
+
+// RateLimit allows each client rps requests per second with bursts of up to
+// burst, keyed by ClientKey. Place it after the auth middleware for sub
+// keying to take effect.
+func RateLimit(rps, burst int) Middleware {
+	return RateLimitBy(rps, burst, ClientKey)
+}
+
+// RateLimitBy is RateLimit with a custom client key.
+func RateLimitBy(rps, burst int, key KeyFunc) Middleware {
+	return NewRateLimit(RateLimitConfig{Store: NewMemoryRateStore(rps, burst), Key: key})
+}
+
+// NewRateLimit limits requests with cfg.Store. Clients over their limit get
+// 429 with Retry-After. With a QuotaStore every response also reports the
+// client's quota: X-RateLimit-Limit requests at once, X-RateLimit-Remaining
+// of them left, and all available again in X-RateLimit-Reset seconds. Store
+// errors are logged, at most once per storeErrorLogInterval, and fail the
+// request open or closed per cfg.FailOpen. NewRateLimit panics if cfg.Limit
+// is set and cfg.Store isn't a QuotaStore.
+func NewRateLimit(cfg RateLimitConfig) Middleware {
+	if cfg.Key == nil {
+		cfg.Key = ClientKey
+	}
+	quotas, _ := cfg.Store.(QuotaStore)
+	if cfg.Limit != nil && quotas == nil {
+		panic("middleware: RateLimitConfig.Limit needs a QuotaStore")
+	}
+	take := func(r *http.Request) (Quota, error) {
+		if quotas == nil {
+			ok, wait, err := cfg.Store.Allow(r.Context(), cfg.Key(r))
+			return Quota{Allowed: ok, RetryAfter: wait}, err
+		}
+		var limit Limit
+		if cfg.Limit != nil {
+			limit, _ = cfg.Limit(r)
+		}
+		return quotas.Take(r.Context(), cfg.Key(r), limit)
+	}
+	var lastLogged atomic.Int64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q, err := take(r)
+			if err != nil {
+				if now := time.Now().UnixNano(); now-lastLogged.Load() >= int64(storeErrorLogInterval) {
+					lastLogged.Store(now)
+					logging.Errorf("rate limit store unavailable (failing %s): %v", failMode(cfg.FailOpen), err)
+				}
+				if !cfg.FailOpen {
+					apierr.Write(w, r, http.StatusServiceUnavailable, apierr.CodeRateLimitUnavailable, "rate limiter unavailable")
+					return
+				}
+				q = Quota{Allowed: true}
+			}
+			if q.Limit > 0 {
+				h := w.Header()
+				h.Set("X-RateLimit-Limit", strconv.Itoa(q.Limit))
+				h.Set("X-RateLimit-Remaining", strconv.Itoa(q.Remaining))
+				h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(q.Reset)))
+			}
+			if !q.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(q.RetryAfter)))
+				apierr.Write(w, r, http.StatusTooManyRequests, apierr.CodeRateLimited, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+const storeErrorLogInterval = 10 * time.Second
+
+func failMode(open bool) string {
+	if open {
+		return "open"
+	}
+	return "closed"
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely.
+	full time.Time
+}
+
+type limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(rate, burst float64) *limiter {
+	return &limiter{rate: rate, burst: burst, now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow implements RateStore. It never fails.
+func (l *limiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	ok, wait := l.allow(key)
+	return ok, wait, nil
+}
+
+// Take implements QuotaStore. It never fails.
+func (l *limiter) Take(_ context.Context, key string, limit Limit) (Quota, error) {
+	return l.take(key, limit), nil
+}
+
+// allow takes a token from key's bucket at the default limit, or reports
+// how long until one is available.
+func (l *limiter) allow(key string) (bool, time.Duration) {
+	q := l.take(key, Limit{})
+	return q.Allowed, q.RetryAfter
+}
+
+// take takes a token from key's bucket, refilled at limit's rate up to its
+// burst. A client moved to another limit keeps its bucket, so its tokens
+// carry over up to the new burst.
+func (l *limiter) take(key string, limit Limit) Quota {
+	rate, burst := l.rate, l.burst
+	if limit.RPS > 0 && limit.Burst > 0 {
+		rate, burst = float64(limit.RPS), float64(limit.Burst)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	q := Quota{Limit: int(burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		q.Allowed = true
+	} else {
+		q.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	q.Remaining = int(b.tokens)
+	q.Reset = seconds((burst - b.tokens) / rate)
+	b.full = now.Add(q.Reset)
+	return q
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// sweep drops buckets that have been idle long enough to refill completely;
+// a fresh bucket behaves identically, so eviction never changes a verdict.
+func (l *limiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	interval := max(refill, time.Minute)
+	if now.Sub(l.lastSweep) < interval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
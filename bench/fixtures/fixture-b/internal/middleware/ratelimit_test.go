@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/auth"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("wait = %v, want 500ms", wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("independent client was limited")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("request after refill was limited")
+	}
+}
+
+func TestLimiterEvictsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	l.allow("a")
+	l.allow("b")
+	now = now.Add(2 * time.Minute)
+	l.allow("c")
+
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("idle bucket was not evicted")
+	}
+	if len(l.buckets) != 1 {
+		t.Fatalf("buckets = %d, want 1", len(l.buckets))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(1, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(addr, sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.RemoteAddr = addr
+		if sub != "" {
+			req = req.WithContext(auth.WithClaims(req.Context(), map[string]any{"sub": sub}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("10.0.0.1:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("first request status = %d", rec.Code)
+	}
+	rec := do("10.0.0.1:5678", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request from same IP status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+	if rec := do("10.0.0.1:5678", "user-1"); rec.Code != http.StatusOK {
+		t.Fatalf("authenticated request status = %d, want keyed by sub", rec.Code)
+	}
+}
+
+func TestRateLimitTiers(t *testing.T) {
+	h := NewRateLimit(RateLimitConfig{
+		Store: NewMemoryRateStore(1, 1),
+		Limit: ClaimLimit("tier", map[string]Limit{"pro": {RPS: 10, Burst: 3}}),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(sub, tier string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		if sub != "" {
+			req = req.WithContext(auth.WithClaims(req.Context(), map[string]any{"sub": sub, "tier": tier}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	quota := func(rec *httptest.ResponseRecorder) string {
+		return rec.Header().Get("X-RateLimit-Limit") + " " + rec.Header().Get("X-RateLimit-Remaining") +
+			" " + rec.Header().Get("X-RateLimit-Reset")
+	}
+
+	for i, want := range []string{"3 2 1", "3 1 1", "3 0 1"} {
+		if rec := do("user-1", "pro"); rec.Code != http.StatusOK || quota(rec) != want {
+			t.Fatalf("pro request %d: status = %d, quota = %q, want %q", i, rec.Code, quota(rec), want)
+		}
+	}
+	rec := do("user-1", "pro")
+	if rec.Code != http.StatusTooManyRequests || quota(rec) != "3 0 1" || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("pro over quota: status = %d, quota = %q, Retry-After = %q",
+			rec.Code, quota(rec), rec.Header().Get("Retry-After"))
+	}
+
+	// Unknown tiers and unauthenticated clients get the default limit.
+	for _, sub := range []string{"user-2", ""} {
+		if rec := do(sub, "enterprise"); rec.Code != http.StatusOK || quota(rec) != "1 0 1" {
+			t.Fatalf("default client %q: status = %d, quota = %q", sub, rec.Code, quota(rec))
+		}
+		if rec := do(sub, "enterprise"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("default client %q over quota: status = %d", sub, rec.Code)
+		}
+	}
+}
+
+func TestLimiterTakeWithLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(1, 1)
+	l.now = func() time.Time { return now }
+	slow := Limit{RPS: 1, Burst: 100}
+
+	for range 100 {
+		l.take("a", slow)
+	}
+	// Long enough for a default bucket to refill and be swept, but not a
+	// bucket of 100.
+	now = now.Add(61 * time.Second)
+	if q := l.take("a", slow); !q.Allowed || q.Remaining != 60 || q.Reset != 40*time.Second {
+		t.Fatalf("quota = %+v, want the bucket kept through the sweep", q)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Allow(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func TestRateLimitNeedsQuotaStore(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewRateLimit accepted a Limit without a QuotaStore")
+		}
+	}()
+	NewRateLimit(RateLimitConfig{Store: failingStore{}, Limit: ClaimLimit("tier", nil)})
+}
+
+func TestRateLimitStoreFailure(t *testing.T) {
+	captureLog(t)
+	for _, tt := range []struct {
+		failOpen bool
+		want     int
+	}{
+		{true, http.StatusOK},
+		{false, http.StatusServiceUnavailable},
+	} {
+		h := NewRateLimit(RateLimitConfig{Store: failingStore{}, FailOpen: tt.failOpen})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tt.want {
+			t.Errorf("FailOpen=%v: status = %d, want %d", tt.failOpen, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP rewrites r.RemoteAddr to the client address reported by trusted
+// proxies, so RemoteIP, the rate limiter and the access log see the client
+// rather than the load balancer in front of the gateway.
+//
+// Only requests whose connection comes from a trusted prefix are rewritten.
+// X-Forwarded-For is read right to left, skipping trusted hops, and the
+// first untrusted address is taken as the client; without X-Forwarded-For,
+// X-Real-IP is used. Requests from anywhere else have both headers removed,
+// so a client connecting directly can't spoof its address to anything
+// downstream. The port in RemoteAddr is kept from the proxy's connection.
+func RealIP(trusted ...netip.Prefix) Middleware {
+	isTrusted := func(ip netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			peer, err := netip.ParseAddr(host)
+			if err != nil || !isTrusted(peer) {
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("X-Real-IP")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if client, ok := forwardedClient(r.Header, isTrusted); ok {
+				r = r.WithContext(r.Context()) // shallow copy; don't mutate the caller's request
+				r.RemoteAddr = net.JoinHostPort(client.String(), port)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient picks the client address out of X-Forwarded-For or
+// X-Real-IP. Parsing stops at the first malformed entry, since anything to
+// its left can't be attributed to a trusted hop.
+func forwardedClient(h http.Header, isTrusted func(netip.Addr) bool) (netip.Addr, bool) {
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		ip, err := netip.ParseAddr(strings.TrimSpace(h.Get("X-Real-IP")))
+		return ip.Unmap(), err == nil
+	}
+
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap()
+		if !isTrusted(client) {
+			break
+		}
+	}
+	return client, client.IsValid()
+}
+
+// ParsePrefixes parses a comma-separated list of CIDR prefixes or bare IP
+// addresses, as accepted by RealIP.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses a CIDR prefix, masked, or a bare IP address as the
+// prefix holding only it.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
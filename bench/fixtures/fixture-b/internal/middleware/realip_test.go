@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParsePrefixes("10.0.0.0/8, 192.168.1.1, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+		wantXFF    string
+	}{
+		{"trusted proxy", "10.0.0.5:443", []string{"203.0.113.7"}, "", "203.0.113.7:443", "203.0.113.7"},
+		{"skips trusted hops", "10.0.0.5:443", []string{"198.51.100.1, 203.0.113.7, 10.1.2.3"}, "", "203.0.113.7:443", ""},
+		{"multiple headers", "10.0.0.5:443", []string{"198.51.100.1", "203.0.113.7"}, "", "203.0.113.7:443", ""},
+		{"all hops trusted", "10.0.0.5:443", []string{"10.9.9.9, 192.168.1.1"}, "", "10.9.9.9:443", ""},
+		{"stops at malformed hop", "10.0.0.5:443", []string{"203.0.113.7, junk, 10.1.1.1"}, "", "10.1.1.1:443", ""},
+		{"X-Real-IP fallback", "192.168.1.1:80", nil, "2001:db8::1", "[2001:db8::1]:80", ""},
+		{"IPv6 proxy", "[fd00::1]:443", []string{"203.0.113.7"}, "", "203.0.113.7:443", ""},
+		{"untrusted peer can't spoof", "203.0.113.9:5555", []string{"1.2.3.4"}, "1.2.3.4", "203.0.113.9:5555", ""},
+		{"trusted without headers", "10.0.0.5:443", nil, "", "10.0.0.5:443", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			h := RealIP(trusted...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got.RemoteAddr != tt.want {
+				t.Fatalf("RemoteAddr = %q, want %q", got.RemoteAddr, tt.want)
+			}
+			if tt.wantXFF != "" && got.Header.Get("X-Forwarded-For") != tt.wantXFF {
+				t.Fatalf("X-Forwarded-For = %q, want it kept for trusted proxies", got.Header.Get("X-Forwarded-For"))
+			}
+		})
+	}
+}
+
+func TestRealIPStripsSpoofedHeaders(t *testing.T) {
+	var got *http.Request
+	h := RealIP()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Real-IP", "1.2.3.4")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.Header.Get("X-Forwarded-For") != "" || got.Header.Get("X-Real-IP") != "" {
+		t.Fatalf("spoofable headers passed through: %v", got.Header)
+	}
+}
+
+func TestRealIPFeedsRemoteIP(t *testing.T) {
+	trusted, _ := ParsePrefixes("10.0.0.0/8")
+	var key string
+	h := RealIP(trusted...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { key = ClientKey(r) }))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:443"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if key != "ip:203.0.113.7" {
+		t.Fatalf("ClientKey = %q, want the forwarded client", key)
+	}
+}
+
+func TestParsePrefixesRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8,bad"} {
+		if _, err := ParsePrefixes(s); err == nil {
+			t.Errorf("ParsePrefixes(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/logging"
+)
+
+// PanicHook is told of a panic Recover caught: the value passed to panic,
+// the stack of the goroutine that panicked, and the request it was
+// serving.
+type PanicHook func(err any, stack []byte, r *http.Request)
+
+// RecoverConfig configures NewRecover.
+type RecoverConfig struct {
+	// OnPanic, if set, is called with every panic after it is logged, to
+	// ship it to an error tracker or alerting webhook. It runs on a
+	// goroutine of its own, so the client's 500 never waits for it, and
+	// gets a copy of the request whose context keeps the original's values
+	// but ends after HookTimeout. A hook that panics itself is logged and
+	// otherwise ignored. At most maxPanicHooks calls run at once, a call
+	// that ignores its context's end included; panics beyond that are only
+	// logged.
+	OnPanic PanicHook
+	// HookTimeout bounds each OnPanic call's context. Defaults to 5s.
+	HookTimeout time.Duration
+}
+
+const maxPanicHooks = 16
+
+// Recover turns a panic anywhere below it into a logged stack trace and a
+// 500 response. Place it first in Chain so it covers every later middleware.
+// http.ErrAbortHandler is re-panicked so net/http can abort the connection
+// as intended.
+func Recover(next http.Handler) http.Handler {
+	return NewRecover(RecoverConfig{})(next)
+}
+
+// NewRecover is Recover that also reports each panic to cfg.OnPanic.
+func NewRecover(cfg RecoverConfig) Middleware {
+	if cfg.HookTimeout <= 0 {
+		cfg.HookTimeout = 5 * time.Second
+	}
+	hooks := make(chan struct{}, maxPanicHooks)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				stack := debug.Stack()
+				logging.Errorf("panic: %s %s: %v\n%s", r.Method, r.URL.Path, err, stack)
+				if cfg.OnPanic != nil {
+					select {
+					case hooks <- struct{}{}:
+						go runPanicHook(cfg, hooks, err, stack, r)
+					default:
+						logging.Errorf("panic: %s %s: too many panic hooks running, not reporting", r.Method, r.URL.Path)
+					}
+				}
+				apierr.Write(w, r, http.StatusInternalServerError, apierr.CodeInternal, "internal server error")
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// runPanicHook calls cfg.OnPanic and frees its slot in hooks. The request
+// is copied, as the server is done with the original once the 500 is
+// written.
+func runPanicHook(cfg RecoverConfig, hooks chan struct{}, err any, stack []byte, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), cfg.HookTimeout)
+	defer func() {
+		if hookErr := recover(); hookErr != nil {
+			logging.Errorf("panic hook panicked: %v", hookErr)
+		}
+		cancel()
+		<-hooks
+	}()
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+	cfg.OnPanic(err, stack, req)
+}
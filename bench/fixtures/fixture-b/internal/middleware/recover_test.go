@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecover(t *testing.T) {
+	logs := captureLog(t)
+	h := Chain(Recover, NewLogger(TextFormat, io.Discard))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"error":{"code":"internal_error","message":"internal server error"}}` {
+		t.Fatalf("body = %s", got)
+	}
+	if !strings.Contains(logs.String(), "panic: GET /api/v1/users: boom") || !strings.Contains(logs.String(), "goroutine") {
+		t.Fatalf("panic and stack not logged:\n%s", logs.String())
+	}
+}
+
+// lockedBuffer is a log destination safe to read while goroutines log.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRecoverPanicHook(t *testing.T) {
+	logs := &lockedBuffer{}
+	prev := log.Writer()
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	type report struct {
+		err      any
+		stack    string
+		path     string
+		deadline bool
+	}
+	release := make(chan struct{})
+	reports := make(chan report, 1)
+	h := NewRecover(RecoverConfig{
+		OnPanic: func(err any, stack []byte, r *http.Request) {
+			<-release
+			_, deadline := r.Context().Deadline()
+			reports <- report{err, string(stack), r.URL.Path, deadline}
+			panic("hook failed too")
+		},
+		HookTimeout: time.Second,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	// The 500 is written while the hook is still blocked.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	close(release)
+	got := <-reports
+	if got.err != "boom" || !strings.Contains(got.stack, "goroutine") || got.path != "/api/v1/users" || !got.deadline {
+		t.Fatalf("hook got %+v", got)
+	}
+
+	// Its own panic is logged, not fatal.
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "panic hook panicked: hook failed too") {
+		if time.Now().After(deadline) {
+			t.Fatalf("hook panic not logged:\n%s", logs.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRecoverPanicHookLimit(t *testing.T) {
+	logs := captureLog(t)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	h := NewRecover(RecoverConfig{OnPanic: func(any, []byte, *http.Request) {
+		calls.Add(1)
+		<-release
+	}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	for range maxPanicHooks + 2 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	close(release)
+	if !strings.Contains(logs.String(), "too many panic hooks running") {
+		t.Fatal("panics over the hook limit weren't reported as dropped")
+	}
+	if n := calls.Load(); n > maxPanicHooks {
+		t.Fatalf("%d hooks ran, want at most %d", n, maxPanicHooks)
+	}
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Factory builds a middleware from its options, the raw JSON given for it
+// in the config, or nil if none were.
+type Factory func(options json.RawMessage) (Middleware, error)
+
+// Spec names a registered middleware and the options to build it with.
+type Spec struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// Registry maps names to middleware factories, so stacks can be listed in
+// config rather than compiled in.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+// DefaultRegistry holds the middleware Register adds and Build uses. It
+// starts out with those of this package that need no other state:
+//
+//   - recover, request_id, metrics, server_timing, idempotency
+//   - gzip, with option min_size
+//   - max_body_bytes, with option bytes (default 10MB)
+//   - logger, with option format, "json" (the default) or "text"
+var DefaultRegistry = builtins()
+
+// Register adds a middleware to DefaultRegistry.
+func Register(name string, f Factory) {
+	DefaultRegistry.Register(name, f)
+}
+
+// Build chains the middleware specs name from DefaultRegistry.
+func Build(specs []Spec) (Middleware, error) {
+	return DefaultRegistry.Build(specs)
+}
+
+// Register adds a middleware under name. It panics if name is empty or
+// already registered, as http.Handle does for duplicate patterns.
+func (reg *Registry) Register(name string, f Factory) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if name == "" {
+		panic("middleware: Register with empty name")
+	}
+	if _, dup := reg.factories[name]; dup {
+		panic(fmt.Sprintf("middleware: %s registered twice", name))
+	}
+	reg.factories[name] = f
+}
+
+// Clone returns a copy of reg that can be extended without affecting it.
+func (reg *Registry) Clone() *Registry {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return &Registry{factories: maps.Clone(reg.factories)}
+}
+
+// Names returns the registered names in sorted order.
+func (reg *Registry) Names() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return slices.Sorted(maps.Keys(reg.factories))
+}
+
+// Build builds the middleware specs name and chains them, the first
+// outermost. An unknown name or bad options fail the whole chain.
+func (reg *Registry) Build(specs []Spec) (Middleware, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	mws := make([]Middleware, 0, len(specs))
+	for _, spec := range specs {
+		f, ok := reg.factories[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q; registered: %s", spec.Name, strings.Join(slices.Sorted(maps.Keys(reg.factories)), ", "))
+		}
+		mw, err := f(spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", spec.Name, err)
+		}
+		mws = append(mws, mw)
+	}
+	return Chain(mws...), nil
+}
+
+// NoOptions is a Factory for mw, which takes no options.
+func NoOptions(mw Middleware) Factory {
+	return func(options json.RawMessage) (Middleware, error) {
+		if o := bytes.TrimSpace(options); len(o) > 0 && string(o) != "null" {
+			return nil, errors.New("takes no options")
+		}
+		return mw, nil
+	}
+}
+
+// DecodeOptions decodes a Factory's options into v, rejecting unknown
+// fields. It leaves v alone if there are no options.
+func DecodeOptions(options json.RawMessage, v any) error {
+	if len(bytes.TrimSpace(options)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(options))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+	return nil
+}
+
+func builtins() *Registry {
+	reg := NewRegistry()
+	reg.Register("recover", NoOptions(Recover))
+	reg.Register("request_id", NoOptions(RequestID))
+	reg.Register("metrics", NoOptions(Metrics))
+	reg.Register("server_timing", NoOptions(ServerTiming))
+	reg.Register("idempotency", NoOptions(Idempotency))
+	reg.Register("gzip", func(options json.RawMessage) (Middleware, error) {
+		var opts struct {
+			MinSize int      `json:"min_size"`
+			Types   []string `json:"types"`
+		}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return NewGzip(GzipConfig{MinSize: opts.MinSize, Types: opts.Types}), nil
+	})
+	reg.Register("max_body_bytes", func(options json.RawMessage) (Middleware, error) {
+		opts := struct {
+			Bytes int64 `json:"bytes"`
+		}{Bytes: 10 << 20}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		if opts.Bytes <= 0 {
+			return nil, errors.New("bytes must be positive")
+		}
+		return MaxBodyBytes(opts.Bytes), nil
+	})
+	reg.Register("logger", func(options json.RawMessage) (Middleware, error) {
+		var opts struct {
+			Format string `json:"format"`
+		}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		switch opts.Format {
+		case "", "json":
+			return Logger, nil
+		case "text":
+			return NewLogger(TextFormat, os.Stderr), nil
+		}
+		return nil, fmt.Errorf(`format: want "json" or "text", got %q`, opts.Format)
+	})
+	return reg
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func tagger(tag string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", tag)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRegistryBuild(t *testing.T) {
+	reg := DefaultRegistry.Clone()
+	reg.Register("tag", func(options json.RawMessage) (Middleware, error) {
+		var opts struct {
+			Tag string `json:"tag"`
+		}
+		if err := DecodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return tagger(opts.Tag), nil
+	})
+
+	mw, err := reg.Build([]Spec{
+		{Name: "tag", Options: json.RawMessage(`{"tag":"outer"}`)},
+		{Name: "gzip", Options: json.RawMessage(`{"min_size":1}`)},
+		{Name: "tag", Options: json.RawMessage(`{"tag":"inner"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("compress me"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Values("X-Order"); strings.Join(got, ",") != "outer,inner" {
+		t.Fatalf("X-Order = %q, want outer,inner", got)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("gzip's min_size option wasn't applied")
+	}
+
+	if _, ok := DefaultRegistry.factories["tag"]; ok {
+		t.Fatal("registering on a clone changed DefaultRegistry")
+	}
+}
+
+func TestRegistryBuildErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    Spec
+		wantErr string
+	}{
+		{"unknown name", Spec{Name: "gzp"}, `unknown middleware "gzp"`},
+		{"unknown option", Spec{Name: "gzip", Options: json.RawMessage(`{"level":9}`)}, "middleware gzip: options"},
+		{"options to a plain middleware", Spec{Name: "recover", Options: json.RawMessage(`{"x":1}`)}, "takes no options"},
+		{"bad option value", Spec{Name: "logger", Options: json.RawMessage(`{"format":"xml"}`)}, "format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Build([]Spec{{Name: "recover"}, tt.spec})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a name twice didn't panic")
+		}
+	}()
+	reg := NewRegistry()
+	reg.Register("x", NoOptions(Recover))
+	reg.Register("x", NoOptions(Recover))
+}
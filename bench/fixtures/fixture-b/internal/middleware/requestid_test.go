@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated when absent", "", false},
+		{"incoming reused", "abc-123", true},
+		{"too long regenerated", strings.Repeat("a", 129), false},
+		{"log injection regenerated", "abc\nGET /admin 200", false},
+		{"spaces regenerated", "abc 123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if echoed != ctxID {
+				t.Fatalf("echoed ID %q != context ID %q", echoed, ctxID)
+			}
+			if tt.keep && ctxID != tt.incoming {
+				t.Fatalf("ID = %q, want incoming %q", ctxID, tt.incoming)
+			}
+			if !tt.keep && !uuidPattern.MatchString(ctxID) {
+				t.Fatalf("ID = %q, want generated UUID", ctxID)
+			}
+		})
+	}
+}
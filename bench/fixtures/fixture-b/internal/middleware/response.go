@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isUpgrade reports whether r asks to switch protocols, as a WebSocket
+// handshake does. Such requests are long-lived connections rather than
+// request/response exchanges.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StatusClientClosedRequest is the status, borrowed from nginx, that
+// Logger and Metrics record for requests whose client disconnected before
+// the response was complete. It is never meant to reach a client.
+const StatusClientClosedRequest = 499
+
+// clientGone reports whether r's client has disconnected.
+func clientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// observe runs next and then done, which sees whether the client left
+// mid-request. done also runs when next aborts with http.ErrAbortHandler,
+// as the proxy does when it can't finish streaming a response, and the
+// panic then carries on; other panics are left to Recover.
+func observe(next http.Handler, w http.ResponseWriter, r *http.Request, done func(gone bool)) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler {
+				done(clientGone(r))
+			}
+			panic(err)
+		}
+	}()
+	next.ServeHTTP(w, r)
+	done(clientGone(r))
+}
+
+// statusWriter records the status code and body size written through it.
+// It passes Flush and Hijack through to the underlying writer so streaming
+// responses and protocol upgrades keep working when it's in the chain.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		// 1xx responses are informational; the final status is still to come.
+		w.wroteHeader = code >= 200
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: underlying ResponseWriter does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
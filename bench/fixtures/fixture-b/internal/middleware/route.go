@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+type routeKey struct{}
+
+// routeHolder is shared by pointer so a router deep in the chain can report
+// the matched route template back to middleware wrapping it.
+type routeHolder struct {
+	template string
+}
+
+// withRouteHolder returns r with a route holder, reusing an existing one so
+// every middleware in the chain sees the same template.
+func withRouteHolder(r *http.Request) (*http.Request, *routeHolder) {
+	if h, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+		return r, h
+	}
+	h := &routeHolder{}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, h)), h
+}
+
+// SetRoute records the route template that matched the request, e.g.
+// "/api/v1/users", for use as a low-cardinality metrics label. It is a no-op
+// if no route-aware middleware is in the chain.
+func SetRoute(ctx context.Context, template string) {
+	if h, ok := ctx.Value(routeKey{}).(*routeHolder); ok {
+		h.template = template
+	}
+}
+
+// routeLabel is the recorded template, or "unmatched" when the request
+// never reached a route. Raw paths are never used as labels.
+func (h *routeHolder) routeLabel() string {
+	if h.template == "" {
+		return "unmatched"
+	}
+	return h.template
+}
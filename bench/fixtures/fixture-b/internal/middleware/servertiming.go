@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timingKey struct{}
+
+// timings accumulates named durations for one request. It is locked
+// because the Timeout path and the handler goroutine may both touch it.
+type timings struct {
+	start time.Time
+
+	mu     sync.Mutex
+	phases []*phase
+}
+
+type phase struct {
+	name    string
+	start   time.Time
+	dur     time.Duration
+	running bool
+}
+
+func (t *timings) begin(name string) *phase {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := &phase{name: name, start: time.Now(), running: true}
+	t.phases = append(t.phases, p)
+	return p
+}
+
+func (t *timings) end(p *phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p.running {
+		p.running = false
+		p.dur = time.Since(p.start)
+	}
+}
+
+// header renders the Server-Timing value: each phase, summed by name in
+// order of first appearance, then "gateway" for the time not spent in any
+// of them and "total" for the time until the response header. Phases
+// still running, such as auth middleware that is writing its own 401,
+// count up to now.
+func (t *timings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	total := now.Sub(t.start)
+	var names []string
+	durs := map[string]time.Duration{}
+	for _, p := range t.phases {
+		d := p.dur
+		if p.running {
+			d = now.Sub(p.start)
+		}
+		if _, ok := durs[p.name]; !ok {
+			names = append(names, p.name)
+		}
+		durs[p.name] += d
+	}
+
+	var b strings.Builder
+	gateway := total
+	for _, name := range names {
+		gateway -= durs[name]
+		writeTiming(&b, name, durs[name])
+	}
+	writeTiming(&b, "gateway", max(gateway, 0))
+	writeTiming(&b, "total", total)
+	return b.String()
+}
+
+func writeTiming(b *strings.Builder, name string, d time.Duration) {
+	if b.Len() > 0 {
+		b.WriteString(", ")
+	}
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64))
+}
+
+// ServerTiming adds a Server-Timing header to each response breaking down
+// the time until the header was sent: the phases timed with StartTiming or
+// Timed, such as "auth" and "upstream", plus "gateway" for everything else
+// and "total". Browsers show it in their developer tools. Place it first
+// in the chain, after Recover, so the total covers every middleware.
+func ServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := &timings{start: time.Now()}
+		tw := &timingWriter{statusWriter: newStatusWriter(w), t: t}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), timingKey{}, t)))
+	})
+}
+
+// StartTiming starts timing name for the request's Server-Timing header
+// and returns the function that stops it. Calling stop more than once has
+// no further effect, and time spent under the same name adds up, so
+// retried upstream calls report their sum. Without ServerTiming in the
+// chain it costs nothing.
+func StartTiming(ctx context.Context, name string) (stop func()) {
+	t, ok := ctx.Value(timingKey{}).(*timings)
+	if !ok {
+		return func() {}
+	}
+	p := t.begin(name)
+	return func() { t.end(p) }
+}
+
+// Timed reports the time mw spends before passing the request on, or in
+// total if it responds itself, as name in the Server-Timing header. Wrap
+// the auth middleware with it to time authentication.
+func Timed(name string, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if stop, ok := r.Context().Value(timedKey{name}).(func()); ok {
+				stop()
+			}
+			next.ServeHTTP(w, r)
+		})
+		h := mw(inner)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stop := StartTiming(r.Context(), name)
+			defer stop()
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), timedKey{name}, stop)))
+		})
+	}
+}
+
+type timedKey struct{ name string }
+
+// timingWriter sets Server-Timing as the response header goes out.
+type timingWriter struct {
+	*statusWriter
+	t    *timings
+	sent bool
+}
+
+func (w *timingWriter) setHeader() {
+	if !w.sent {
+		w.sent = true
+		w.Header().Set("Server-Timing", w.t.header())
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.setHeader()
+	}
+	w.statusWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.statusWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	w.setHeader()
+	w.statusWriter.Flush()
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"api-gateway/internal/apierr"
+)
+
+func serverTiming(t *testing.T, rec *httptest.ResponseRecorder) map[string]time.Duration {
+	t.Helper()
+	out := map[string]time.Duration{}
+	for _, name := range []string{"auth", "upstream", "gateway", "total"} {
+		out[name] = -1
+	}
+	re := regexp.MustCompile(`([a-z]+);dur=([0-9.]+)`)
+	for _, m := range re.FindAllStringSubmatch(rec.Header().Get("Server-Timing"), -1) {
+		d, err := time.ParseDuration(m[2] + "ms")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[m[1]] = d
+	}
+	return out
+}
+
+func TestServerTiming(t *testing.T) {
+	slowAuth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			if r.Header.Get("Authorization") == "" {
+				apierr.Write(w, r, http.StatusUnauthorized, apierr.CodeUnauthorized, "unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := Chain(ServerTiming, Timed("auth", slowAuth))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 2; i++ {
+			stop := StartTiming(r.Context(), "upstream")
+			time.Sleep(15 * time.Millisecond)
+			stop()
+			stop()
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer x")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	got := serverTiming(t, rec)
+	if got["auth"] < 20*time.Millisecond || got["auth"] > 45*time.Millisecond {
+		t.Errorf("auth = %v, want about 20ms and none of the handler's time", got["auth"])
+	}
+	if got["upstream"] < 30*time.Millisecond {
+		t.Errorf("upstream = %v, want both calls summed", got["upstream"])
+	}
+	if got["gateway"] < 0 || got["total"] < got["auth"]+got["upstream"] {
+		t.Errorf("timings = %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := serverTiming(t, rec); rec.Code != http.StatusUnauthorized || got["auth"] < 20*time.Millisecond || got["upstream"] != -1 {
+		t.Errorf("rejected request: %d %v", rec.Code, got)
+	}
+}
+
+func TestServerTimingOffByDefault(t *testing.T) {
+	h := Timed("auth", func(next http.Handler) http.Handler { return next })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			StartTiming(r.Context(), "upstream")()
+		}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if v := rec.Header().Get("Server-Timing"); v != "" {
+		t.Fatalf("Server-Timing = %q without ServerTiming in the chain", v)
+	}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/apierr"
+)
+
+// timeoutGrace is how long Timeout waits after the deadline for the handler
+// to answer on its own before sending the 503.
+var timeoutGrace = 100 * time.Millisecond
+
+// Timeout bounds each request to d, independently of the server's
+// WriteTimeout. The handler's context is cancelled at the deadline, and a
+// handler that honours it gets a short grace period to respond itself, as
+// the proxy does with a 504. If it still hasn't started responding, the
+// client gets a 503 and any later writes from the handler fail with
+// http.ErrHandlerTimeout. A handler that
+// has already begun streaming can't have its status changed, so Timeout
+// waits for it to notice the cancelled context and return.
+//
+// Unlike http.TimeoutHandler, responses aren't buffered, so flushing and
+// hijacking keep working. Upgrade requests such as WebSocket handshakes
+// pass through without a deadline, since cancelling their context would
+// close the connection they open.
+func Timeout(d time.Duration) Middleware {
+	return timeout(func(*http.Request) time.Duration { return d }, false)
+}
+
+// timeoutWriteMargin is how long past a TimeoutFunc budget and its grace
+// period the response may take to write.
+const timeoutWriteMargin = time.Second
+
+// TimeoutFunc is Timeout with each request's budget chosen by budget, as
+// for per-route overrides; a budget of zero or less means no deadline.
+// As a budget may outlast the server's WriteTimeout, TimeoutFunc also
+// moves the connection's write deadline to the end of the budget, plus the
+// grace period and a second to write the response, through
+// http.ResponseController. The budget, not WriteTimeout, then decides when
+// a request is cut off.
+func TimeoutFunc(budget func(*http.Request) time.Duration) Middleware {
+	return timeout(budget, true)
+}
+
+func timeout(budget func(*http.Request) time.Duration, setWriteDeadline bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := budget(r)
+			if isUpgrade(r) || (setWriteDeadline && d <= 0) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if setWriteDeadline {
+				// Writers without deadlines, such as test recorders, are
+				// fine to leave as they are.
+				_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + timeoutGrace + timeoutWriteMargin))
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, h: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				grace := time.NewTimer(timeoutGrace)
+				defer grace.Stop()
+				select {
+				case <-done:
+				case <-grace.C:
+					tw.mu.Lock()
+					if !tw.wroteHeader {
+						tw.timedOut = true
+						apierr.Write(w, r, http.StatusServiceUnavailable, apierr.CodeRequestTimeout, "request timeout")
+						tw.mu.Unlock()
+						return
+					}
+					tw.mu.Unlock()
+					<-done
+				}
+			}
+			select {
+			case p := <-panicked:
+				panic(p)
+			default:
+			}
+			tw.finish()
+		})
+	}
+}
+
+// timeoutWriter serializes writes from the handler goroutine with the
+// timeout path, discarding them once the 503 has been sent. The handler gets
+// its own header map, copied onto the real one as the response starts, so
+// the two goroutines never touch the same map.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// start copies the handler's headers onto the real writer. Callers hold mu.
+func (tw *timeoutWriter) start() {
+	if !tw.wroteHeader {
+		maps.Copy(tw.w.Header(), tw.h)
+	}
+}
+
+// finish copies what the handler left in its header map once it has
+// returned: the whole map if it never wrote, or else the trailer values it
+// set after the body, which net/http only looks for on the real writer.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.start()
+		return
+	}
+	h := tw.w.Header()
+	for _, v := range tw.h.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vs, ok := tw.h[k]; ok {
+				h[k] = vs
+			}
+		}
+	}
+	for k, vs := range tw.h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			h[k] = vs
+		}
+	}
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.start()
+	tw.wroteHeader = tw.wroteHeader || code >= 200
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.start()
+	tw.wroteHeader = true
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.start()
+	tw.wroteHeader = true
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	h, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: underlying ResponseWriter does not support hijacking")
+	}
+	tw.start()
+	tw.wroteHeader = true
+	return h.Hijack()
+}
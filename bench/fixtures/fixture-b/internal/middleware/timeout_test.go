@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Run("fast handler", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "yes")
+			w.WriteHeader(http.StatusCreated)
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusCreated || rec.Header().Get("X-Handler") != "yes" {
+			t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+	})
+
+	t.Run("slow handler gets 503", func(t *testing.T) {
+		lateWrite := make(chan error, 1)
+		rec := httptest.NewRecorder()
+		Timeout(20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			time.Sleep(timeoutGrace + 20*time.Millisecond)
+			w.Header().Set("X-Late", "yes")
+			_, err := w.Write([]byte("too late"))
+			lateWrite <- err
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d, want 503", rec.Code)
+		}
+		if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+			t.Fatalf("late write error = %v, want ErrHandlerTimeout", err)
+		}
+		if rec.Header().Get("X-Late") != "" {
+			t.Fatal("header set after timeout leaked into response")
+		}
+	})
+
+	t.Run("handler answering within the grace period keeps its response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Timeout(20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusGatewayTimeout)
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want the handler's 504", rec.Code)
+		}
+	})
+
+	t.Run("streaming handler keeps its status", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Timeout(20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "partial" || !rec.Flushed {
+			t.Fatalf("status = %d, body = %q, flushed = %v", rec.Code, rec.Body.String(), rec.Flushed)
+		}
+	})
+
+	t.Run("trailers set after the body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "X-Checksum")
+			w.Write([]byte("body"))
+			w.Header().Set("X-Checksum", "sum")
+			w.Header().Set(http.TrailerPrefix+"X-Late", "late")
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		trailer := rec.Result().Trailer
+		if trailer.Get("X-Checksum") != "sum" || trailer.Get("X-Late") != "late" {
+			t.Fatalf("trailers = %v", trailer)
+		}
+	})
+
+	t.Run("headers without a write", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "yes")
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Header().Get("X-Handler") != "yes" {
+			t.Fatalf("headers = %v", rec.Header())
+		}
+	})
+
+	t.Run("panic propagates to caller", func(t *testing.T) {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("recovered %v, want boom", p)
+			}
+		}()
+		Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
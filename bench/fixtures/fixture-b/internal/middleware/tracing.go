@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/tracing"
+)
+
+// Tracing starts a server span for each request with a tracer from tp. An
+// incoming traceparent makes the span a child of the caller's, and the
+// span's context goes into the request context, where the proxy injects it
+// into the upstream request and Logger records its trace ID. Spans carry
+// the method, route template, status and request ID; 5xx responses mark
+// them as errors.
+//
+// With a nil tp it does nothing, and traceparent headers pass through to
+// upstreams untouched. Place it after RequestID and before Logger.
+func Tracing(tp tracing.TracerProvider) Middleware {
+	if tp == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	tracer := tp.Tracer("api-gateway")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, route := withRouteHolder(r)
+			ctx := r.Context()
+			if parent := tracing.Extract(r.Header); parent.IsValid() {
+				ctx = tracing.ContextWithSpanContext(ctx, parent)
+			}
+			ctx, span := tracer.Start(ctx, r.Method,
+				tracing.String("http.request.method", r.Method),
+				tracing.String("url.path", r.URL.Path),
+				tracing.String("client.address", RemoteIP(r)),
+			)
+			defer span.End()
+			if id := RequestIDFromContext(ctx); id != "" {
+				span.SetAttributes(tracing.String("gateway.request_id", id))
+			}
+
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			span.SetAttributes(tracing.Int("http.response.status_code", sw.status))
+			if route.template != "" {
+				span.SetName(r.Method + " " + route.template)
+				span.SetAttributes(tracing.String("http.route", route.template))
+			}
+			if sw.status >= 500 {
+				span.SetStatus(tracing.StatusError, http.StatusText(sw.status))
+			}
+		})
+	}
+}
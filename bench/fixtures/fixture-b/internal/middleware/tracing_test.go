@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/tracing"
+)
+
+// recordingProvider wraps a real tracer, capturing the spans it ends.
+type recordingProvider struct {
+	tracer tracing.Tracer
+	spans  []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracing.Span
+	name   string
+	attrs  map[string]any
+	status tracing.StatusCode
+}
+
+func (p *recordingProvider) Tracer(string) tracing.Tracer { return p }
+
+func (p *recordingProvider) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	ctx, span := p.tracer.Start(ctx, name)
+	s := &recordedSpan{Span: span, name: name, attrs: map[string]any{}}
+	s.SetAttributes(attrs...)
+	p.spans = append(p.spans, s)
+	return ctx, s
+}
+
+func (s *recordedSpan) SetName(name string) { s.name = name }
+
+func (s *recordedSpan) SetAttributes(attrs ...tracing.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code tracing.StatusCode, _ string) { s.status = code }
+
+func newRecordingProvider(t *testing.T) *recordingProvider {
+	p := tracing.NewProvider(tracing.ProviderConfig{Exporter: nopExporter{}, SampleRatio: 1})
+	t.Cleanup(func() { p.Shutdown(context.Background()) })
+	return &recordingProvider{tracer: p.Tracer("test")}
+}
+
+type nopExporter struct{}
+
+func (nopExporter) Export(context.Context, []tracing.SpanData) error { return nil }
+
+func TestTracing(t *testing.T) {
+	tp := newRecordingProvider(t)
+	var logs bytes.Buffer
+	var upstream tracing.SpanContext
+	h := Chain(RequestID, Tracing(tp), NewLogger(JSONFormat, &logs))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/api/v1/users")
+		upstream = tracing.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(tp.spans) != 1 {
+		t.Fatalf("started %d spans, want 1", len(tp.spans))
+	}
+	span := tp.spans[0]
+	if span.name != "GET /api/v1/users" || span.status != tracing.StatusError {
+		t.Errorf("span %q status %v", span.name, span.status)
+	}
+	for k, want := range map[string]any{
+		"http.request.method":       "GET",
+		"http.route":                "/api/v1/users",
+		"http.response.status_code": http.StatusBadGateway,
+		"gateway.request_id":        "req-1",
+	} {
+		if span.attrs[k] != want {
+			t.Errorf("%s = %v, want %v", k, span.attrs[k], want)
+		}
+	}
+	if upstream.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || upstream.SpanID.String() == "00f067aa0ba902b7" {
+		t.Errorf("handler saw span context %+v, want a child of the incoming one", upstream)
+	}
+
+	var entry map[string]any
+	json.Unmarshal(logs.Bytes(), &entry)
+	if entry["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("access log trace_id = %v", entry["trace_id"])
+	}
+}
+
+func TestTracingNilProviderIsNoop(t *testing.T) {
+	var sc tracing.SpanContext
+	h := Tracing(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc = tracing.SpanContextFromContext(r.Context())
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if sc.IsValid() {
+		t.Fatalf("span context %+v set without a provider", sc)
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/metrics"
+)
+
+// DefaultMaxURILength is the longest request URI MaxURILength admits when
+// configured with zero, well above what browsers and API clients send.
+const DefaultMaxURILength = 8 << 10
+
+var uriTooLong = metrics.Default.NewCounter("gateway_uri_too_long_total",
+	"Requests refused with 414 for a request URI over MaxURILength.")
+
+// MaxURILength rejects requests whose request URI, path and query
+// together, is longer than n bytes with 414 and a JSON error. n <= 0 means
+// DefaultMaxURILength. Place it ahead of the access log so oversized URIs,
+// as sent by probes, never reach it; refusals are counted in
+// gateway_uri_too_long_total instead.
+func MaxURILength(n int) Middleware {
+	if n <= 0 {
+		n = DefaultMaxURILength
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uri := r.RequestURI
+			if uri == "" {
+				uri = r.URL.RequestURI()
+			}
+			if len(uri) > n {
+				uriTooLong.Inc()
+				w.Header().Set("Connection", "close")
+				apierr.Write(w, r, http.StatusRequestURITooLong, apierr.CodeURITooLong, "request URI too long")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
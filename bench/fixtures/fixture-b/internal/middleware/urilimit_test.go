@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxURILength(t *testing.T) {
+	const limit = 64
+	// "/" plus the path below is exactly at the limit.
+	atLimit := strings.Repeat("p", limit-1)
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"short", "/api/v1/items?page=2", http.StatusOK},
+		{"at the limit", "/" + atLimit, http.StatusOK},
+		{"path too long", "/" + atLimit + "p", http.StatusRequestURITooLong},
+		{"query too long", "/api?q=" + strings.Repeat("x", limit), http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			h := Chain(MaxURILength(limit), NewLogger(JSONFormat, &logged))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && logged.Len() == 0 {
+				t.Fatal("admitted request wasn't logged")
+			}
+			if tt.want == http.StatusRequestURITooLong {
+				if !strings.Contains(rec.Body.String(), `"uri_too_long"`) {
+					t.Fatalf("body = %s", rec.Body.String())
+				}
+				if logged.Len() != 0 {
+					t.Fatalf("refused request was logged: %s", logged.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMaxURILengthDefault(t *testing.T) {
+	h := MaxURILength(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for target, want := range map[string]int{
+		"/search?q=" + strings.Repeat("x", 4<<10):               http.StatusOK,
+		"/search?q=" + strings.Repeat("x", DefaultMaxURILength): http.StatusRequestURITooLong,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%d-byte URI: status = %d, want %d", len(target), rec.Code, want)
+		}
+	}
+}
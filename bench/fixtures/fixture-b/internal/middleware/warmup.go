@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/apierr"
+	"api-gateway/internal/logging"
+)
+
+// WarmupConfig tunes NewWarmup.
+type WarmupConfig struct {
+	// Duration is the longest warmup lasts, counted from NewWarmup. Zero
+	// disables warmup.
+	Duration time.Duration
+	// Ready, if set, ends warmup early the first time it returns true. It
+	// is polled once a second in the background, never on a request's path.
+	Ready func(ctx context.Context) bool
+	// PathPrefixes limits warmup to requests under these prefixes. Empty
+	// means every request.
+	PathPrefixes []string
+}
+
+// NewWarmup returns middleware answering 503 with Retry-After until
+// cfg.Duration has passed since the call or cfg.Ready reports the
+// upstreams ready, whichever comes first, so a cold start doesn't send
+// traffic to upstreams that can't take it yet. Warmup ends once: it
+// doesn't come back when Ready later fails.
+func NewWarmup(cfg WarmupConfig) Middleware {
+	if cfg.Duration <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	m := newWarmup(cfg)
+	if cfg.Ready != nil {
+		go m.watch()
+	}
+	return m.middleware
+}
+
+type warmup struct {
+	cfg      WarmupConfig
+	now      func() time.Time
+	until    time.Time
+	interval time.Duration
+	over     atomic.Bool
+}
+
+func newWarmup(cfg WarmupConfig) *warmup {
+	prefixes := make([]string, len(cfg.PathPrefixes))
+	for i, p := range cfg.PathPrefixes {
+		prefixes[i] = strings.TrimSuffix(p, "/")
+	}
+	cfg.PathPrefixes = prefixes
+	return &warmup{cfg: cfg, now: time.Now, until: time.Now().Add(cfg.Duration), interval: time.Second}
+}
+
+// remaining returns how much of the warmup is left, or zero once it's over.
+func (m *warmup) remaining() time.Duration {
+	if m.over.Load() {
+		return 0
+	}
+	left := m.until.Sub(m.now())
+	if left <= 0 {
+		m.end("warmup period elapsed")
+		return 0
+	}
+	return left
+}
+
+func (m *warmup) end(reason string) {
+	if m.over.CompareAndSwap(false, true) {
+		logging.Infof("warmup over: %s", reason)
+	}
+}
+
+// watch polls cfg.Ready until it reports ready or the warmup elapses.
+func (m *warmup) watch() {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+	for m.remaining() > 0 {
+		if m.cfg.Ready(context.Background()) {
+			m.end("upstreams ready")
+			return
+		}
+		<-tick.C
+	}
+}
+
+func (m *warmup) applies(path string) bool {
+	if len(m.cfg.PathPrefixes) == 0 {
+		return true
+	}
+	for _, p := range m.cfg.PathPrefixes {
+		if path == p || strings.HasPrefix(path, p+"/") || p == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *warmup) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		left := m.remaining()
+		if left == 0 || !m.applies(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+		apierr.Write(w, r, http.StatusServiceUnavailable, apierr.CodeWarmingUp, "gateway is warming up")
+	})
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	m := newWarmup(WarmupConfig{Duration: 10 * time.Second, PathPrefixes: []string{"/api/v1/users/"}})
+	now := time.Now()
+	m.until = now.Add(10 * time.Second)
+	m.now = func() time.Time { return now }
+	h := m.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		elapsed        time.Duration
+		path           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"warming", 0, "/api/v1/users", http.StatusServiceUnavailable, "10"},
+		{"warming subpath", 2500 * time.Millisecond, "/api/v1/users/42", http.StatusServiceUnavailable, "8"},
+		{"other route", 3 * time.Second, "/api/v1/services", http.StatusOK, ""},
+		{"prefix boundary", 3 * time.Second, "/api/v1/usersx", http.StatusOK, ""},
+		{"elapsed", 10 * time.Second, "/api/v1/users", http.StatusOK, ""},
+		// Warmup doesn't come back, even if the clock does.
+		{"over for good", 0, "/api/v1/users", http.StatusOK, ""},
+	}
+	start := now
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start.Add(tt.elapsed)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Fatalf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestWarmupEndsWhenReady(t *testing.T) {
+	var ready atomic.Bool
+	m := newWarmup(WarmupConfig{
+		Duration: time.Hour,
+		Ready:    func(context.Context) bool { return ready.Load() },
+	})
+	m.interval = time.Millisecond
+	go m.watch()
+	h := m.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+		return rec.Code
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("before ready: status = %d, want 503", code)
+	}
+	ready.Store(true)
+	deadline := time.Now().Add(time.Second)
+	for serve() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("warmup didn't end once ready")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ready.Store(false)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("after ready: status = %d, want warmup to stay over", code)
+	}
+}
+
+func TestWarmupDisabled(t *testing.T) {
+	h := NewWarmup(WarmupConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 with no duration", rec.Code)
+	}
+}
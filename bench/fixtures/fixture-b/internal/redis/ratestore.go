@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/middleware"
+)
+
+// fixedWindow counts a request against KEYS[1] and returns the count and
+// the window's remaining milliseconds. The key is given its expiry on the
+// first request of each window; the PTTL check repairs a key left without
+// one, so a client can't be locked out forever.
+const fixedWindow = `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+  ttl = tonumber(ARGV[1])
+end
+return {n, ttl}
+`
+
+// RateStore is a middleware.QuotaStore shared by every gateway replica
+// pointed at the same Redis. Each client gets limit requests per window,
+// counted in a key that expires when the window ends; the increment and
+// expiry run as one script, so concurrent replicas can't lose counts.
+type RateStore struct {
+	client *Client
+	limit  int
+	window time.Duration
+	prefix string
+}
+
+// NewRateStore allows limit requests per window for each client, keeping
+// counters under keys starting with prefix.
+func NewRateStore(c *Client, limit int, window time.Duration, prefix string) *RateStore {
+	return &RateStore{client: c, limit: limit, window: window, prefix: prefix}
+}
+
+// Allow counts a request from key.
+func (s *RateStore) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	q, err := s.Take(ctx, key, middleware.Limit{})
+	return q.Allowed, q.RetryAfter, err
+}
+
+// Take counts a request from key against limit, as Burst requests per
+// Burst/RPS-second window. A client moved to another limit finishes its
+// current window under the new count.
+func (s *RateStore) Take(ctx context.Context, key string, limit middleware.Limit) (middleware.Quota, error) {
+	n, window := s.limit, s.window
+	if limit.RPS > 0 && limit.Burst > 0 {
+		n = limit.Burst
+		window = time.Duration(limit.Burst) * time.Second / time.Duration(limit.RPS)
+	}
+	ms := strconv.FormatInt(max(window.Milliseconds(), 1), 10)
+	reply, err := s.client.Do(ctx, "EVAL", fixedWindow, "1", s.prefix+key, ms)
+	if err != nil {
+		return middleware.Quota{}, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return middleware.Quota{}, fmt.Errorf("redis: unexpected rate limit reply %v", reply)
+	}
+	count, _ := items[0].(int64)
+	ttl, _ := items[1].(int64)
+	q := middleware.Quota{
+		Allowed:   count <= int64(n),
+		Limit:     n,
+		Remaining: int(max(int64(n)-count, 0)),
+		Reset:     time.Duration(ttl) * time.Millisecond,
+	}
+	if !q.Allowed {
+		q.RetryAfter = q.Reset
+	}
+	return q, nil
+}
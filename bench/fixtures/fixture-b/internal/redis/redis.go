@@ -0,0 +1,220 @@
+// Package redis is a minimal Redis client, covering just what the gateway
+// needs: pooled connections, AUTH/SELECT from a redis:// URL, and commands
+// whose replies are integers, strings, or arrays of them.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error is an error reply from the server, such as "NOSCRIPT ...".
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client sends commands to one Redis server. It is safe for concurrent use.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *conn
+
+	mu     sync.Mutex
+	closed bool // connections put back after Close are closed, not kept
+}
+
+type conn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+// NewClient returns a client for rawURL, of the form
+// redis://[user:password@]host[:port][/db]. Connections are opened on first
+// use, so an unreachable server surfaces as errors from Do rather than
+// here. Each command, including any dial, is bounded by timeout unless the
+// context's deadline is sooner.
+func NewClient(rawURL string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("redis: invalid URL %q: want redis://host:port", rawURL)
+	}
+	c := &Client{addr: u.Host, timeout: timeout, idle: make(chan *conn, 16)}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Do sends one command and returns its reply: int64, string, nil, or
+// []any. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(ctx, args)
+	var redisErr Error
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection may be mid-reply; don't reuse it.
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes idle connections. Connections in use are closed when their
+// command finishes.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, br: bufio.NewReader(nc)}
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := cn.do(ctx, args); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) do(ctx context.Context, args []string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(cn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(cn.br)
+}
+
+func readReply(br *bufio.Reader) (any, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, Error(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			// An error inside an array is a value, not a failed command.
+			item, err := readReply(br)
+			var redisErr Error
+			if errors.As(err, &redisErr) {
+				item, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
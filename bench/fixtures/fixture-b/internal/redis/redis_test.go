@@ -0,0 +1,219 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/internal/middleware"
+)
+
+// fakeServer speaks enough RESP to test the client. It answers PING, AUTH
+// and SELECT, runs EVAL of the fixed-window script against an in-memory
+// counter, and rejects everything else.
+type fakeServer struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	counts   map[string]int64
+	commands []string
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, password: password, counts: map[string]int64{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url() string { return "redis://" + s.ln.Addr().String() }
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	authed := s.password == ""
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args[0])
+		s.mu.Unlock()
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == s.password
+			if !authed {
+				fmt.Fprint(c, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			fmt.Fprint(c, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+		case cmd == "PING":
+			fmt.Fprint(c, "+PONG\r\n")
+		case cmd == "SELECT":
+			fmt.Fprint(c, "+OK\r\n")
+		case cmd == "EVAL" && len(args) == 5:
+			s.mu.Lock()
+			s.counts[args[3]]++
+			n := s.counts[args[3]]
+			s.mu.Unlock()
+			fmt.Fprintf(c, "*2\r\n:%d\r\n:%s\r\n", n, args[4])
+		default:
+			fmt.Fprintf(c, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	if n == 0 {
+		return nil, errors.New("empty command")
+	}
+	for i := range args {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(br, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func TestClientDo(t *testing.T) {
+	srv := newFakeServer(t, "secret")
+	c, err := NewClient("redis://:secret@"+srv.ln.Addr().String()+"/2", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		reply, err := c.Do(context.Background(), "PING")
+		if err != nil || reply != "PONG" {
+			t.Fatalf("PING = %v, %v", reply, err)
+		}
+	}
+	_, err = c.Do(context.Background(), "NOPE")
+	var redisErr Error
+	if !errors.As(err, &redisErr) || !strings.HasPrefix(string(redisErr), "ERR unknown command") {
+		t.Fatalf("err = %v, want an error reply", err)
+	}
+	if reply, err := c.Do(context.Background(), "PING"); err != nil || reply != "PONG" {
+		t.Fatalf("PING after error reply = %v, %v", reply, err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got := strings.Join(srv.commands, " "); got != "AUTH SELECT PING PING NOPE PING" {
+		t.Fatalf("commands = %s, want one connection set up once and reused", got)
+	}
+}
+
+func TestClientCloseClosesConnectionsInUse(t *testing.T) {
+	srv := newFakeServer(t, "")
+	c, err := NewClient(srv.url(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := c.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c.put(cn)
+	if len(c.idle) != 0 {
+		t.Fatal("connection put back after Close was kept idle")
+	}
+	if _, err := cn.do(context.Background(), []string{"PING"}); err == nil {
+		t.Fatal("connection put back after Close is still open")
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := NewClient("redis://"+addr, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(context.Background(), "PING"); err == nil {
+		t.Fatal("Do succeeded against a closed port")
+	}
+}
+
+func TestNewClientRejectsBadURLs(t *testing.T) {
+	for _, raw := range []string{"localhost:6379", "http://localhost", "redis://localhost/x"} {
+		if _, err := NewClient(raw, 0); err == nil {
+			t.Errorf("%q accepted", raw)
+		}
+	}
+}
+
+func TestRateStore(t *testing.T) {
+	srv := newFakeServer(t, "")
+	c, err := NewClient(srv.url(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewRateStore(c, 2, 1500*time.Millisecond, "rl:")
+
+	for i := 0; i < 2; i++ {
+		if ok, _, err := store.Allow(context.Background(), "a"); !ok || err != nil {
+			t.Fatalf("request %d: ok = %v, err = %v", i, ok, err)
+		}
+	}
+	ok, wait, err := store.Allow(context.Background(), "a")
+	if ok || err != nil || wait != 1500*time.Millisecond {
+		t.Fatalf("over limit: ok = %v, wait = %v, err = %v", ok, wait, err)
+	}
+	if ok, _, _ := store.Allow(context.Background(), "b"); !ok {
+		t.Fatal("independent client was limited")
+	}
+
+	pro := middleware.Limit{RPS: 2, Burst: 4}
+	q, err := store.Take(context.Background(), "c", pro)
+	if err != nil || !q.Allowed || q.Limit != 4 || q.Remaining != 3 || q.Reset != 2*time.Second {
+		t.Fatalf("Take with a limit = %+v, %v; want 4 per 2s window", q, err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.counts["rl:a"] != 3 {
+		t.Fatalf("counts = %v, want keys under the prefix", srv.counts)
+	}
+}
@@ -0,0 +1,231 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"api-gateway/internal/logging"
+)
+
+// SpanData is a finished span, as handed to an Exporter.
+type SpanData struct {
+	// Scope is the name of the tracer that started the span.
+	Scope       string
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanID
+	Start, End  time.Time
+	Attributes  []Attribute
+	Status      StatusCode
+	Description string
+}
+
+// Exporter sends finished spans somewhere, such as an OTLP collector.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// ProviderConfig configures NewProvider.
+type ProviderConfig struct {
+	Exporter Exporter
+	// SampleRatio is the fraction of new traces recorded, from 0 to 1.
+	// Requests that arrive with a traceparent follow its sampled flag.
+	SampleRatio float64
+	// BatchSize spans are exported at once, or whatever has accumulated
+	// after FlushInterval. Defaults to 512 and 5s.
+	BatchSize     int
+	FlushInterval time.Duration
+	// QueueSize bounds the spans waiting for export; more are dropped.
+	// Defaults to 2048.
+	QueueSize int
+}
+
+// Provider is a TracerProvider that samples traces and exports the
+// sampled spans in batches from a background goroutine. Call Shutdown to
+// flush what's queued before the process exits.
+type Provider struct {
+	cfg       ProviderConfig
+	threshold uint64
+
+	queue    chan SpanData
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan error
+}
+
+// NewProvider starts a provider exporting through cfg.Exporter.
+func NewProvider(cfg ProviderConfig) *Provider {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 2048
+	}
+	ratio := min(max(cfg.SampleRatio, 0), 1)
+	p := &Provider{
+		cfg: cfg,
+		// Trace IDs are random, so comparing their low 63 bits against a
+		// threshold samples the ratio, and every replica agrees on a trace.
+		threshold: uint64(ratio * (1 << 63)),
+		queue:     make(chan SpanData, cfg.QueueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan error, 1),
+	}
+	go p.run()
+	return p
+}
+
+// Tracer returns a tracer whose spans are reported under name.
+func (p *Provider) Tracer(name string) Tracer {
+	return &tracer{p: p, scope: name}
+}
+
+// Shutdown exports the queued spans and stops the provider. Spans ended
+// afterwards are dropped.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Provider) sampled(id TraceID) bool {
+	return binary.BigEndian.Uint64(id[8:])>>1 < p.threshold
+}
+
+func (p *Provider) enqueue(s SpanData) {
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+	select {
+	case p.queue <- s:
+	default:
+		// Never block a request on the exporter.
+	}
+}
+
+func (p *Provider) run() {
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, p.cfg.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := p.cfg.Exporter.Export(ctx, batch)
+		if err != nil {
+			logging.Warnf("tracing: dropped %d spans: %v", len(batch), err)
+		}
+		batch = batch[:0]
+		return err
+	}
+	for {
+		select {
+		case s := <-p.queue:
+			if batch = append(batch, s); len(batch) >= p.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.stop:
+		drain:
+			for {
+				select {
+				case s := <-p.queue:
+					batch = append(batch, s)
+				default:
+					break drain
+				}
+			}
+			p.done <- flush()
+			return
+		}
+	}
+}
+
+type tracer struct {
+	p     *Provider
+	scope string
+}
+
+func (t *tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceState: parent.TraceState}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.p.sampled(sc.TraceID)
+	}
+	rand.Read(sc.SpanID[:])
+
+	s := &span{
+		p: t.p,
+		data: SpanData{
+			Scope:       t.scope,
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Start:       time.Now(),
+			Attributes:  attrs,
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), s
+}
+
+type span struct {
+	p *Provider
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *span) SpanContext() SpanContext { return s.data.SpanContext }
+
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+func (s *span) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+func (s *span) SetStatus(code StatusCode, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = code
+	s.data.Description = description
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if !s.data.SpanContext.Sampled {
+		return
+	}
+	s.data.End = time.Now()
+	s.p.enqueue(s.data)
+}
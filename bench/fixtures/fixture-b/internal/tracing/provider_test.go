@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	requests []map[string]any
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var v map[string]any
+	json.Unmarshal(body, &v)
+	c.mu.Lock()
+	c.requests = append(c.requests, v)
+	c.mu.Unlock()
+}
+
+// spans flattens the exported spans by name.
+func (c *collector) spans() map[string]map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := map[string]map[string]any{}
+	for _, req := range c.requests {
+		for _, rs := range req["resourceSpans"].([]any) {
+			for _, ss := range rs.(map[string]any)["scopeSpans"].([]any) {
+				for _, s := range ss.(map[string]any)["spans"].([]any) {
+					span := s.(map[string]any)
+					out[span["name"].(string)] = span
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestProviderExportsToOTLP(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	p := NewProvider(ProviderConfig{
+		Exporter:      NewOTLPExporter(srv.URL, "test-svc"),
+		SampleRatio:   0,
+		FlushInterval: time.Hour,
+	})
+	tracer := p.Tracer("test")
+
+	// Unsampled root: never exported, but still propagated.
+	_, root := tracer.Start(context.Background(), "dropped")
+	root.End()
+	if !root.SpanContext().IsValid() || root.SpanContext().Sampled {
+		t.Fatalf("root span context = %+v", root.SpanContext())
+	}
+
+	// A sampled remote parent overrides the ratio.
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.Start(ContextWithSpanContext(context.Background(), parent), "GET")
+	span.SetName("GET /api")
+	span.SetAttributes(Int("http.response.status_code", 502), Bool("retried", true))
+	span.SetStatus(StatusError, "Bad Gateway")
+	span.End()
+	span.End()
+	if got := SpanContextFromContext(ctx); got.TraceID != parent.TraceID || got.SpanID == parent.SpanID {
+		t.Fatalf("child context = %+v", got)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := c.spans()
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1: %v", len(spans), spans)
+	}
+	got := spans["GET /api"]
+	if got["traceId"] != parent.TraceID.String() || got["parentSpanId"] != parent.SpanID.String() {
+		t.Errorf("span IDs = %v", got)
+	}
+	if got["status"].(map[string]any)["code"] != float64(StatusError) || got["kind"] != float64(spanKindServer) {
+		t.Errorf("status/kind = %v/%v", got["status"], got["kind"])
+	}
+	attrs := got["attributes"].([]any)
+	if len(attrs) != 2 || attrs[0].(map[string]any)["value"].(map[string]any)["intValue"] != "502" {
+		t.Errorf("attributes = %v", attrs)
+	}
+	resource := c.requests[0]["resourceSpans"].([]any)[0].(map[string]any)["resource"]
+	if b, _ := json.Marshal(resource); string(b) != `{"attributes":[{"key":"service.name","value":{"stringValue":"test-svc"}}]}` {
+		t.Errorf("resource = %s", b)
+	}
+}
+
+func TestProviderSampleRatio(t *testing.T) {
+	for _, tt := range []struct {
+		ratio float64
+		want  bool
+	}{{0, false}, {1, true}} {
+		p := NewProvider(ProviderConfig{Exporter: NewOTLPExporter("http://127.0.0.1:1", "x"), SampleRatio: tt.ratio})
+		for i := 0; i < 20; i++ {
+			_, span := p.Tracer("t").Start(context.Background(), "s")
+			if span.SpanContext().Sampled != tt.want {
+				t.Fatalf("ratio %v: sampled = %v", tt.ratio, !tt.want)
+			}
+		}
+		p.Shutdown(context.Background())
+	}
+}
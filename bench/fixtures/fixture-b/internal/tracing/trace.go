@@ -0,0 +1,167 @@
+// Package tracing provides spans and W3C Trace Context propagation for the
+// gateway. Its interfaces follow OpenTelemetry's shape, so a provider backed
+// by the OpenTelemetry SDK can be adapted to TracerProvider; the package's
+// own Provider exports to an OTLP/HTTP collector without extra dependencies.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceID and SpanID identify a trace and a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether t is non-zero, as the spec requires.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether s is non-zero, as the spec requires.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Remote marks a context extracted from an incoming request.
+	Remote bool
+}
+
+// IsValid reports whether sc has both IDs set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent renders sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions above 00
+// are accepted as long as they start with the version 00 fields, as the
+// spec asks of forward-compatible parsers.
+func ParseTraceparent(v string) (SpanContext, error) {
+	v = strings.TrimSpace(v)
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("tracing: unsupported traceparent version %q", parts[0])
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || strings.ToLower(parts[1]) != parts[1] {
+		return SpanContext{}, fmt.Errorf("tracing: malformed trace ID %q", parts[1])
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || strings.ToLower(parts[2]) != parts[2] {
+		return SpanContext{}, fmt.Errorf("tracing: malformed span ID %q", parts[2])
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("tracing: malformed flags %q", parts[3])
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("tracing: all-zero ID in traceparent %q", v)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+// Extract reads the traceparent and tracestate headers, returning an
+// invalid SpanContext if there is no usable traceparent.
+func Extract(h http.Header) SpanContext {
+	sc, err := ParseTraceparent(h.Get("Traceparent"))
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.TraceState = strings.Join(h.Values("Tracestate"), ",")
+	return sc
+}
+
+// Inject writes the span context in ctx, if any, to h as traceparent and
+// tracestate, replacing any values already there. Without one, h is left
+// alone, so headers from an untraced client pass through untouched.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	h.Set("Traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set("Tracestate", sc.TraceState)
+	} else {
+		h.Del("Tracestate")
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns ctx carrying sc, as the parent for spans
+// started from it and the context Inject propagates.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context in ctx, or an invalid
+// one.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Attribute is a key/value pair recorded on a span. Values are strings,
+// bools, ints, int64s, or float64s.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String, Int and Bool build attributes.
+func String(k, v string) Attribute    { return Attribute{k, v} }
+func Int(k string, v int) Attribute   { return Attribute{k, v} }
+func Bool(k string, v bool) Attribute { return Attribute{k, v} }
+
+// StatusCode is a span's outcome, with OpenTelemetry's values.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// Span is one timed operation.
+type Span interface {
+	SpanContext() SpanContext
+	SetName(name string)
+	SetAttributes(attrs ...Attribute)
+	SetStatus(code StatusCode, description string)
+	End()
+}
+
+// Tracer starts spans. Start makes the new span a child of the span
+// context in ctx, if any, and returns a context carrying the new span's.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// TracerProvider hands out tracers by instrumentation name.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
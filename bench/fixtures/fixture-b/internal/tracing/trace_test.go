@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseTraceparent(%q) err = %v, want ok=%v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && (sc.Sampled != tt.sampled || !sc.Remote) {
+			t.Errorf("ParseTraceparent(%q) = %+v", tt.in, sc)
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	in := http.Header{}
+	in.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Add("Tracestate", "vendor=a")
+	in.Add("Tracestate", "other=b")
+	sc := Extract(in)
+	if !sc.IsValid() || sc.TraceState != "vendor=a,other=b" {
+		t.Fatalf("Extract = %+v", sc)
+	}
+
+	out := http.Header{}
+	Inject(context.Background(), out)
+	if len(out) != 0 {
+		t.Fatalf("Inject without a span context wrote %v", out)
+	}
+	Inject(ContextWithSpanContext(context.Background(), sc), out)
+	if out.Get("Traceparent") != in.Get("Traceparent") || out.Get("Tracestate") != "vendor=a,other=b" {
+		t.Fatalf("Inject wrote %v", out)
+	}
+}